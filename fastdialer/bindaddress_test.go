@@ -0,0 +1,59 @@
+//go:build linux
+
+package fastdialer
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBindAddresses(t *testing.T) {
+	// the whole 127.0.0.0/8 range is assigned to the loopback interface on linux
+	listener, err := net.Listen("tcp", "0.0.0.0:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	sources := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+			sources <- host
+			conn.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.Nil(t, err)
+
+	options := DefaultOptions
+	options.BindAddresses = []string{"127.0.0.2", "127.0.0.3", "::1"}
+	fd := newTestDialer(t, options, &mockResolver{})
+	var bound []string
+	for i := 0; i < 3; i++ {
+		conn, err := fd.Dial(context.Background(), "tcp", net.JoinHostPort("127.0.0.1", port))
+		require.Nil(t, err)
+		conn.Close()
+		bound = append(bound, <-sources)
+	}
+	require.Equal(t, []string{"127.0.0.2", "127.0.0.3", "127.0.0.2"}, bound)
+
+	// combined with the source port range
+	port2 := freePort(t)
+	options.SourcePortRange = [2]int{port2, port2}
+	fd = newTestDialer(t, options, &mockResolver{})
+	conn, err := fd.Dial(context.Background(), "tcp", net.JoinHostPort("127.0.0.1", port))
+	require.Nil(t, err)
+	defer conn.Close()
+	require.Equal(t, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2).To4(), Port: port2}, conn.LocalAddr())
+	<-sources
+
+	options.BindAddresses = []string{"127.0.0.256"}
+	_, err = NewDialer(options)
+	require.ErrorIs(t, err, InvalidBindAddressError)
+	require.ErrorIs(t, options.Validate(), InvalidBindAddressError)
+}
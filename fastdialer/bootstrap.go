@@ -0,0 +1,155 @@
+package fastdialer
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	iputil "github.com/boss-net/goutils/ip"
+	"github.com/boss-net/retryabledns/doh"
+)
+
+// isPlainResolver checks if the normalized resolver is a dns over udp or tcp one
+func isPlainResolver(resolver string) bool {
+	return !strings.HasPrefix(resolver, "doh:") && !strings.HasPrefix(resolver, "dot:")
+}
+
+// bootstrapResolvers resolves the hostnames of the doh and dot resolvers through
+// Options.BootstrapResolvers, which aren't used for anything else. Only the connections go to
+// the resolved address, the hostname is still sent as server name and doh Host header and the
+// certificates are verified against it with Options.BootstrapRootCAs. The plain resolvers and
+// the encrypted ones given by ip are returned unchanged, first the BaseResolvers then the
+// DoTResolvers, along with the bootstrapped ones
+func bootstrapResolvers(options Options, resolvers []string) ([]string, []string, []dnsResolver, error) {
+	bootstrap := normalizeResolvers(options.BootstrapResolvers)
+	for _, resolver := range bootstrap {
+		if !isValidResolver(resolver) || !isPlainResolver(resolver) {
+			return nil, nil, nil, fmt.Errorf("%w: %s", BootstrapResolverError, resolver)
+		}
+	}
+	if options.DNSForceTCP {
+		bootstrap = forceTCPResolvers(bootstrap)
+	}
+	client, err := newDNSClient(bootstrap, options.MaxRetries)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// the first address of each hostname
+	resolved := make(map[string]string)
+	lookup := func(host string) (string, error) {
+		if ip, ok := resolved[host]; ok {
+			return ip, nil
+		}
+		data, err := client.Resolve(host)
+		if err == nil && len(data.A)+len(data.AAAA) == 0 {
+			err = NoAddressFoundError
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: %s: %w", BootstrapFailedError, host, err)
+		}
+		resolved[host] = addresses(data)[0]
+		return resolved[host], nil
+	}
+
+	var (
+		plain   []string
+		dohPool = &dohResolvers{maxRetries: options.MaxRetries}
+		dotPool = &dotResolvers{maxRetries: options.MaxRetries}
+	)
+	for _, resolver := range resolvers {
+		if address, ok := strings.CutPrefix(resolver, "doh:"); ok {
+			endpoint, method := dohMethod(address)
+			u, err := url.Parse(endpoint)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("%w: %s", InvalidResolverError, resolver)
+			}
+			if iputil.IsIP(u.Hostname()) {
+				plain = append(plain, resolver)
+				continue
+			}
+			ip, err := lookup(u.Hostname())
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			port := u.Port()
+			if port == "" {
+				port = "443"
+				if u.Scheme == "http" {
+					port = "80"
+				}
+			}
+			dohPool.resolvers = append(dohPool.resolvers, dohResolver{
+				url:    endpoint,
+				method: method,
+				client: newPinnedDoHClient(net.JoinHostPort(ip, port), options.BootstrapRootCAs),
+			})
+		} else if address, ok := strings.CutPrefix(resolver, "dot:"); ok {
+			host, port, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("%w: %s", InvalidResolverError, resolver)
+			}
+			if iputil.IsIP(host) {
+				plain = append(plain, resolver)
+				continue
+			}
+			ip, err := lookup(host)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			dotPool.resolvers = append(dotPool.resolvers, dotResolver{
+				address: net.JoinHostPort(ip, port),
+				client:  newDoTClient(host, options.BootstrapRootCAs),
+			})
+		} else {
+			plain = append(plain, resolver)
+		}
+	}
+
+	var dotPlain []string
+	for _, resolver := range options.DoTResolvers {
+		address, ok := strings.CutPrefix(resolver, dotScheme)
+		if !ok || address == "" {
+			dotPlain = append(dotPlain, resolver)
+			continue
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			host, port = strings.Trim(address, "[]"), dotDefaultPort
+		}
+		if iputil.IsIP(host) {
+			dotPlain = append(dotPlain, resolver)
+			continue
+		}
+		ip, err := lookup(host)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		serverName := options.DoTServerName
+		if serverName == "" {
+			serverName = host
+		}
+		dotPool.resolvers = append(dotPool.resolvers, dotResolver{
+			address: net.JoinHostPort(ip, port),
+			client:  newDoTClient(serverName, options.BootstrapRootCAs),
+		})
+	}
+
+	var bootstrapped []dnsResolver
+	if len(dohPool.resolvers) > 0 {
+		bootstrapped = append(bootstrapped, dohPool)
+	}
+	if len(dotPool.resolvers) > 0 {
+		bootstrapped = append(bootstrapped, dotPool)
+	}
+	return plain, dotPlain, bootstrapped, nil
+}
+
+// dohMethod trims the :get or :post suffix of the doh resolver url and returns the http
+// method it selects, post by default
+func dohMethod(address string) (string, doh.Method) {
+	if trimmed, ok := strings.CutSuffix(address, ":get"); ok {
+		return trimmed, doh.MethodGet
+	}
+	return strings.TrimSuffix(address, ":post"), doh.MethodPost
+}
@@ -0,0 +1,159 @@
+package fastdialer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// serverNames records the names requested by the clients of a server
+type serverNames struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (s *serverNames) add(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names = append(s.names, name)
+}
+
+// record is a tls.Config.GetConfigForClient recording the server names
+func (s *serverNames) record(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	s.add(hello.ServerName)
+	return nil, nil
+}
+
+func (s *serverNames) get() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.names...)
+}
+
+// newTestDoHServer starts a dns over https server with the certificate answering the A queries
+// with the ip, it records the server names and the Host headers of the requests
+func newTestDoHServer(t *testing.T, ip string, cert tls.Certificate, names, hosts *serverNames) string {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts.add(r.Host)
+		body, err := io.ReadAll(r.Body)
+		req := &dns.Msg{}
+		if err != nil || req.Unpack(body) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp := answerIP(req, ip)
+		packed, _ := resp.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(packed)
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}, GetConfigForClient: names.record}
+	// the rejected handshakes are expected
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// answerIP replies to the A queries with the ip
+func answerIP(req *dns.Msg, ip string) *dns.Msg {
+	resp := &dns.Msg{}
+	resp.SetReply(req)
+	if question := req.Question[0]; question.Qtype == dns.TypeA {
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP(ip),
+		})
+	}
+	return resp
+}
+
+func TestBootstrapResolvers(t *testing.T) {
+	dohCert := newTestCertificate(t, "doh.example.com")
+	var dohNames, dohHosts serverNames
+	_, dohPort, err := net.SplitHostPort(strings.TrimPrefix(newTestDoHServer(t, "10.0.0.7", dohCert, &dohNames, &dohHosts), "https://"))
+	require.Nil(t, err)
+	dotCert := newTestCertificate(t, "dot.example.com")
+	var dotNames serverNames
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{dotCert}, GetConfigForClient: dotNames.record})
+	require.Nil(t, err)
+	dotServer := &dns.Server{Listener: listener, Net: "tcp-tls", Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		_ = w.WriteMsg(answerIP(req, "10.0.0.8"))
+	})}
+	go dotServer.ActivateAndServe() //nolint:errcheck
+	t.Cleanup(func() { _ = dotServer.Shutdown() })
+	_, dotPort, err := net.SplitHostPort(listener.Addr().String())
+	require.Nil(t, err)
+
+	// the bootstrap resolver only knows the doh and dot servers
+	var bootstrapCalls int32
+	bootstrap := newTestDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt32(&bootstrapCalls, 1)
+		resp := &dns.Msg{}
+		resp.SetRcode(req, dns.RcodeNameError)
+		if name := req.Question[0].Name; name == "doh.example.com." || name == "dot.example.com." {
+			resp = answerIP(req, "127.0.0.1")
+		}
+		_ = w.WriteMsg(resp)
+	}, nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(dohCert.Leaf)
+	roots.AddCert(dotCert.Leaf)
+
+	options := DefaultOptions
+	options.BootstrapResolvers = []string{bootstrap}
+	options.BootstrapRootCAs = roots
+	dohURL := "https://doh.example.com:" + dohPort + "/dns-query"
+	fd := newResolverTestDialer(t, options, "doh:"+dohURL)
+	calls := atomic.LoadInt32(&bootstrapCalls)
+	require.Positive(t, calls)
+
+	data, err := fd.GetDNSData("target.example.org")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.7"}, data.A)
+	require.Equal(t, "doh:"+dohURL, data.Resolver[0])
+	// only the address is bootstrapped, the server still sees its hostname
+	require.Contains(t, dohNames.get(), "doh.example.com")
+	require.Contains(t, dohHosts.get(), "doh.example.com:"+dohPort)
+	// the targets aren't resolved by the bootstrap resolver
+	require.Equal(t, calls, atomic.LoadInt32(&bootstrapCalls))
+
+	dotOptions := options
+	dotOptions.BaseResolvers = nil
+	dotOptions.DoTResolvers = []string{"tls://dot.example.com:" + dotPort}
+	fd = newDoTTestDialer(t, dotOptions)
+	data, err = fd.GetDNSData("target.example.org")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.8"}, data.A)
+	require.Equal(t, []string{"dot.example.com"}, dotNames.get()[:1])
+
+	// the certificates are verified against the hostnames
+	options.BootstrapRootCAs = nil
+	fd = newResolverTestDialer(t, options, "doh:"+dohURL)
+	_, err = fd.GetDNSData("target.example.org")
+	require.NotNil(t, err)
+	dotOptions.BootstrapRootCAs = nil
+	fd = newDoTTestDialer(t, dotOptions)
+	_, err = fd.GetDNSData("target.example.org")
+	require.NotNil(t, err)
+
+	// unresolvable endpoints and encrypted bootstrap resolvers are rejected
+	options.BaseResolvers = []string{"doh:https://missing.example.com/dns-query"}
+	_, err = NewDialer(options)
+	require.ErrorIs(t, err, BootstrapFailedError)
+	options.BootstrapResolvers = []string{"doh:https://dns.google/dns-query"}
+	_, err = NewDialer(options)
+	require.ErrorIs(t, err, BootstrapResolverError)
+	require.ErrorIs(t, options.Validate(), BootstrapResolverError)
+}
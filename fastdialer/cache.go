@@ -0,0 +1,126 @@
+package fastdialer
+
+import (
+	"context"
+	"time"
+
+	sliceutil "github.com/boss-net/goutils/slice"
+	"github.com/boss-net/hmap/store/hybrid"
+	retryabledns "github.com/boss-net/retryabledns"
+)
+
+// Cache stores the serialized dns data by hostname
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte) error
+	Delete(key string) error
+	Close() error
+}
+
+// CacheScanner is optionally implemented by the caches set with Options.Cache to support FlushExpired,
+// Scan calls the function with every entry
+type CacheScanner interface {
+	Scan(func(key, value []byte) error)
+}
+
+// cacheScanner returns the scanner of the entries of the cache, the l1 cache in front of it is skipped
+func cacheScanner(cache Cache) (CacheScanner, bool) {
+	if l1, ok := cache.(*l1Cache); ok {
+		cache = l1.Cache
+	}
+	scanner, ok := cache.(CacheScanner)
+	return scanner, ok
+}
+
+// cacheNamespaceSeparator separates the namespace and the hostname in the keys of the
+// namespaced cache entries, it can't appear in hostnames
+const cacheNamespaceSeparator = "\x00"
+
+// cacheKey returns the cache key of the hostname in the namespace of the context, falling
+// back to Options.CacheNamespace. Entries outside of any namespace are keyed by hostname
+func (d *Dialer) cacheKey(ctx context.Context, hostname string) string {
+	namespace, ok := cacheNamespaceFromContext(ctx)
+	if !ok {
+		namespace = d.options.CacheNamespace
+	}
+	if namespace == "" {
+		return hostname
+	}
+	return namespace + cacheNamespaceSeparator + hostname
+}
+
+// cachedDNSData returns the dns data cached under the key, none if it can't be decoded
+func (d *Dialer) cachedDNSData(key string) (*retryabledns.DNSData, error) {
+	var data retryabledns.DNSData
+	dataBytes, ok := d.hm.Get(key)
+	if !ok {
+		return nil, NoDNSDataError
+	}
+	if err := data.Unmarshal(dataBytes); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// hybridCache is the default cache backed by a hybrid map
+type hybridCache struct {
+	*hybrid.HybridMap
+}
+
+func (c *hybridCache) Delete(key string) error {
+	return c.Del(key)
+}
+
+// CacheSource is the origin of a cached entry
+type CacheSource string
+
+const (
+	CacheSourceResolver  CacheSource = "resolver"
+	CacheSourceSystem    CacheSource = "system"
+	CacheSourceHostsFile CacheSource = "hostsfile"
+	// CacheSourceOverride entries were stored by the caller, for example with PreloadCacheFile
+	CacheSourceOverride CacheSource = "override"
+)
+
+// CacheMeta describes a cached entry
+type CacheMeta struct {
+	Hostname  string
+	Source    CacheSource
+	CachedAt  time.Time     // resolution time, zero for the hosts file entries and overrides
+	Age       time.Duration // elapsed since CachedAt
+	TTL       time.Duration // record ttl of the answer
+	ExpiresAt time.Time     // zero for entries which never expire
+	Size      int           // size of the serialized entry in bytes
+}
+
+// CacheEntryInfo returns the metadata of the cached entry of the hostname without resolving it
+func (d *Dialer) CacheEntryInfo(hostname string) (*CacheMeta, error) {
+	hostname = asAscii(hostname)
+	dataBytes, ok := d.hm.Get(d.cacheKey(context.Background(), hostname))
+	if !ok {
+		return nil, NoDNSDataError
+	}
+	var data retryabledns.DNSData
+	if err := data.Unmarshal(dataBytes); err != nil {
+		return nil, err
+	}
+	meta := &CacheMeta{Hostname: hostname, TTL: time.Duration(data.TTL) * time.Second, Size: len(dataBytes)}
+	switch {
+	case data.HostsFile:
+		meta.Source = CacheSourceHostsFile
+	case sliceutil.Contains(data.Resolver, systemResolverName):
+		meta.Source = CacheSourceSystem
+	case !data.Timestamp.IsZero():
+		meta.Source = CacheSourceResolver
+	default:
+		meta.Source = CacheSourceOverride
+	}
+	if !data.Timestamp.IsZero() {
+		meta.CachedAt = data.Timestamp
+		meta.Age = time.Since(data.Timestamp)
+	}
+	if expiry, ok := d.expiresAt(&data); ok {
+		meta.ExpiresAt = expiry
+	}
+	return meta, nil
+}
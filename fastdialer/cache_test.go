@@ -0,0 +1,186 @@
+package fastdialer
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	retryabledns "github.com/boss-net/retryabledns"
+	"github.com/stretchr/testify/require"
+)
+
+// mapCache is a map backed Cache
+type mapCache struct {
+	mu     sync.Mutex
+	items  map[string][]byte
+	closed bool
+}
+
+func (c *mapCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.items[key]
+	return value, ok
+}
+
+func (c *mapCache) Set(key string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = value
+	return nil
+}
+
+func (c *mapCache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.items, key)
+	return nil
+}
+
+func (c *mapCache) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestCustomCache(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	cache := &mapCache{items: make(map[string][]byte)}
+	options := DefaultOptions
+	options.Cache = cache
+	resolver := &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)}
+	fd := newTestDialer(t, options, resolver)
+
+	conn, err := fd.Dial(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+	require.Nil(t, err)
+	conn.Close()
+	_, ok := cache.Get("example.com")
+	require.True(t, ok)
+
+	// entries written by another instance sharing the cache are used
+	shared := newTestDialer(t, options, resolver)
+	conn, err = shared.Dial(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+	require.Nil(t, err)
+	conn.Close()
+	require.Equal(t, int32(1), atomic.LoadInt32(&resolver.resolveCalls))
+
+	fd.Close()
+	require.False(t, cache.closed)
+}
+
+func TestCacheEntryInfo(t *testing.T) {
+	resolvedAt := time.Now().Add(-time.Minute)
+	resolver := &mockResolver{
+		resolve: func(host string) (*retryabledns.DNSData, error) {
+			if host == "fallback.example.com" {
+				return &retryabledns.DNSData{Host: host}, nil
+			}
+			return &retryabledns.DNSData{Host: host, A: []string{"10.0.0.1"}, TTL: 300, Timestamp: resolvedAt, Resolver: []string{"127.0.0.1:53"}}, nil
+		},
+		syscall: staticAnswer([]string{"10.0.0.2"}, nil),
+	}
+	options := DefaultOptions
+	options.EnableFallback = true
+	options.FallbackCondition = FallbackOnEmpty
+	fd := newTestDialer(t, options, resolver)
+
+	_, err := fd.CacheEntryInfo("example.com")
+	require.ErrorIs(t, err, NoDNSDataError)
+
+	_, err = fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	meta, err := fd.CacheEntryInfo("example.com")
+	require.Nil(t, err)
+	require.Equal(t, CacheSourceResolver, meta.Source)
+	require.True(t, meta.CachedAt.Equal(resolvedAt))
+	require.GreaterOrEqual(t, meta.Age, time.Minute)
+	require.Equal(t, 300*time.Second, meta.TTL)
+	require.True(t, meta.ExpiresAt.Equal(resolvedAt.Add(300*time.Second)))
+	require.Positive(t, meta.Size)
+
+	_, err = fd.GetDNSData("fallback.example.com")
+	require.Nil(t, err)
+	meta, err = fd.CacheEntryInfo("fallback.example.com")
+	require.Nil(t, err)
+	require.Equal(t, CacheSourceSystem, meta.Source)
+	require.Equal(t, syscallTTL*time.Second, meta.TTL)
+	require.True(t, meta.ExpiresAt.Equal(meta.CachedAt.Add(syscallTTL*time.Second)))
+
+	dir := t.TempDir()
+	preload := filepath.Join(dir, "preload")
+	require.Nil(t, os.WriteFile(preload, []byte("preloaded.example.com 10.0.0.3\n"), 0o600))
+	require.Nil(t, fd.PreloadCacheFile(preload))
+	meta, err = fd.CacheEntryInfo("preloaded.example.com")
+	require.Nil(t, err)
+	require.Equal(t, CacheSourceOverride, meta.Source)
+	require.Zero(t, meta.Age)
+
+	hosts := filepath.Join(dir, "hosts")
+	require.Nil(t, os.WriteFile(hosts, []byte("10.0.0.4 hosts.example.com\n"), 0o600))
+	t.Setenv("HOSTS_PATH", hosts)
+	require.Nil(t, loadHostsFile(fd.hm))
+	meta, err = fd.CacheEntryInfo("hosts.example.com")
+	require.Nil(t, err)
+	require.Equal(t, CacheSourceHostsFile, meta.Source)
+}
+
+func TestWithNoCache(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	resolver := &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)}
+	fd := newTestDialer(t, DefaultOptions, resolver)
+	setCachedData(t, fd, &retryabledns.DNSData{Host: "example.com", A: []string{"10.0.0.1"}, TTL: 300, Timestamp: time.Now()})
+	ctx := WithNoCache(context.Background())
+
+	data, err := fd.GetDNSDataContext(ctx, "example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1"}, data.A)
+	require.Equal(t, int32(1), atomic.LoadInt32(&resolver.resolveCalls))
+
+	conn, info, err := fd.DialWithInfo(ctx, "tcp", net.JoinHostPort("example.com", port))
+	require.Nil(t, err)
+	conn.Close()
+	require.False(t, info.FromCache)
+	require.Equal(t, int32(2), atomic.LoadInt32(&resolver.resolveCalls))
+
+	// the cached entry is left untouched and still used by the other calls
+	data, err = fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, data.A)
+	require.Equal(t, int32(2), atomic.LoadInt32(&resolver.resolveCalls))
+}
+
+func TestCacheNamespace(t *testing.T) {
+	options := DefaultOptions
+	options.Cache = &mapCache{items: make(map[string][]byte)}
+	resolver := &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)}
+	fd := newTestDialer(t, options, resolver)
+	first := WithCacheNamespace(context.Background(), "first")
+	second := WithCacheNamespace(context.Background(), "second")
+
+	// the same hostname is resolved once per namespace
+	for i := 0; i < 2; i++ {
+		_, err := fd.GetDNSDataContext(first, "example.com")
+		require.Nil(t, err)
+		_, err = fd.GetDNSDataContext(second, "example.com")
+		require.Nil(t, err)
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&resolver.resolveCalls))
+	_, err := fd.GetDNSDataFromCache("example.com")
+	require.ErrorIs(t, err, NoDNSDataError)
+
+	// a dialer sharing the store reads the entries of its default namespace
+	options.CacheNamespace = "first"
+	other := newTestDialer(t, options, &mockResolver{resolve: failingAnswer})
+	data, err := other.GetDNSDataFromCache("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1"}, data.A)
+	// the empty namespace holds the entries outside of any namespace
+	_, err = other.GetDNSDataContext(WithCacheNamespace(context.Background(), ""), "example.com")
+	require.Error(t, err)
+}
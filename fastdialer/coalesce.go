@@ -0,0 +1,92 @@
+package fastdialer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	utls "github.com/refraction-networking/utls"
+	ztls "github.com/zmap/zcrypto/tls"
+)
+
+// coalesceData is the ip and the certificate names of the last tls connection to a host
+type coalesceData struct {
+	ip    string
+	names []string
+}
+
+// CoalesceKey returns a key shared by the hostnames whose last tls connections, recorded with
+// Options.WithCoalescingData, were established to the same ip with the same certificate names.
+// An http/2 client can reuse a connection for every hostname sharing its key
+func (d *Dialer) CoalesceKey(hostname string) (string, error) {
+	hostname = asAscii(hostname)
+	value, ok := d.coalescing.Load(hostname)
+	if !ok {
+		return "", NoCoalesceDataError
+	}
+	data := value.(coalesceData)
+	if !matchCertificateNames(hostname, data.names) {
+		return "", fmt.Errorf("%w: %s", CertificateNameMismatchError, hostname)
+	}
+	return data.ip + "|" + strings.Join(data.names, ","), nil
+}
+
+// recordCoalesceData stores the ip and the certificate names of the tls connection to the host
+func (d *Dialer) recordCoalesceData(hostname, ip string, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	d.coalescing.Store(hostname, coalesceData{ip: ip, names: names})
+}
+
+// certificateNames returns the sorted dns and ip names of the leaf certificate of the tls,
+// utls or ztls connection
+func certificateNames(conn net.Conn) []string {
+	var (
+		dnsNames []string
+		ips      []net.IP
+	)
+	switch tlsConn := conn.(type) {
+	case *tls.Conn:
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			dnsNames, ips = certs[0].DNSNames, certs[0].IPAddresses
+		}
+	case *utls.UConn:
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			dnsNames, ips = certs[0].DNSNames, certs[0].IPAddresses
+		}
+	case *ztls.Conn:
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			dnsNames, ips = certs[0].DNSNames, certs[0].IPAddresses
+		}
+	}
+	names := make([]string, 0, len(dnsNames)+len(ips))
+	for _, name := range dnsNames {
+		names = append(names, strings.ToLower(name))
+	}
+	for _, ip := range ips {
+		names = append(names, ip.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matchCertificateNames checks if the hostname is covered by one of the certificate names,
+// a wildcard matches a single label
+func matchCertificateNames(hostname string, names []string) bool {
+	hostname = strings.ToLower(strings.TrimSuffix(strings.Trim(hostname, "[]"), "."))
+	for _, name := range names {
+		if suffix, ok := strings.CutPrefix(name, "*"); ok {
+			if label, found := strings.CutSuffix(hostname, suffix); found && label != "" && !strings.Contains(label, ".") {
+				return true
+			}
+			continue
+		}
+		if hostname == name {
+			return true
+		}
+	}
+	return false
+}
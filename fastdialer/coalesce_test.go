@@ -0,0 +1,54 @@
+package fastdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoalesceKey(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{newTestCertificate(t, "a.example.com", "b.example.com")},
+	}))
+	require.Nil(t, err)
+	options := DefaultOptions
+	options.WithCoalescingData = true
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+
+	_, err = fd.CoalesceKey("a.example.com")
+	require.ErrorIs(t, err, NoCoalesceDataError)
+
+	var keys []string
+	for _, hostname := range []string{"a.example.com", "b.example.com"} {
+		conn, info, err := fd.DialTLSWithInfo(context.Background(), "tcp", net.JoinHostPort(hostname, port))
+		require.Nil(t, err, hostname)
+		conn.Close()
+		require.Equal(t, []string{"127.0.0.1", "a.example.com", "b.example.com", "localhost"}, info.SANs)
+
+		key, err := fd.CoalesceKey(hostname)
+		require.Nil(t, err, hostname)
+		keys = append(keys, key)
+	}
+	require.Equal(t, keys[0], keys[1])
+	require.Contains(t, keys[0], "127.0.0.1")
+
+	// the verification is skipped, the certificate doesn't cover the host
+	conn, err := fd.DialTLS(context.Background(), "tcp", net.JoinHostPort("c.example.com", port))
+	require.Nil(t, err)
+	conn.Close()
+	_, err = fd.CoalesceKey("c.example.com")
+	require.ErrorIs(t, err, CertificateNameMismatchError)
+}
+
+func TestMatchCertificateNames(t *testing.T) {
+	names := []string{"*.example.com", "example.org", "10.0.0.1"}
+	for _, hostname := range []string{"a.example.com", "A.Example.com.", "example.org", "10.0.0.1"} {
+		require.True(t, matchCertificateNames(hostname, names), hostname)
+	}
+	for _, hostname := range []string{"example.com", "a.b.example.com", "www.example.org", "10.0.0.2"} {
+		require.False(t, matchCertificateNames(hostname, names), hostname)
+	}
+}
@@ -0,0 +1,98 @@
+package fastdialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// ConnErrorKind classifies why connecting to an address failed
+type ConnErrorKind int
+
+const (
+	// ConnUnknown errors couldn't be classified, for example the failed handshakes
+	ConnUnknown ConnErrorKind = iota
+	// ConnRefused addresses actively refused the connection, the port is closed
+	ConnRefused
+	// ConnTimeout addresses didn't answer in time, the port is likely filtered
+	ConnTimeout
+	// ConnReset connections were reset or aborted by the peer
+	ConnReset
+	// ConnUnreachable addresses have no route to them
+	ConnUnreachable
+)
+
+func (k ConnErrorKind) String() string {
+	switch k {
+	case ConnRefused:
+		return "refused"
+	case ConnTimeout:
+		return "timeout"
+	case ConnReset:
+		return "reset"
+	case ConnUnreachable:
+		return "unreachable"
+	default:
+		return "unknown"
+	}
+}
+
+// ClassifyConnError derives the kind of a connection error from the underlying syscall error
+func ClassifyConnError(err error) ConnErrorKind {
+	if err == nil {
+		return ConnUnknown
+	}
+	for kind, errnos := range connErrnos {
+		for _, errno := range errnos {
+			if errors.Is(err, errno) {
+				return kind
+			}
+		}
+	}
+	var netErr net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ConnTimeout
+	}
+	return ConnUnknown
+}
+
+// ConnectAttempt is a failed connection to one of the addresses of the host
+type ConnectAttempt struct {
+	Address string // dialed ip:port
+	Kind    ConnErrorKind
+	Err     error
+}
+
+// ConnectError is returned when none of the addresses of the host could be connected
+// to, it matches CouldNotConnectError and the errors of the attempts
+type ConnectError struct {
+	Hostname string
+	Attempts []ConnectAttempt // in the order they failed
+}
+
+// newConnectError returns the error of the failed attempts, or CouldNotConnectError if none was made
+func newConnectError(hostname string, attempts []ConnectAttempt) error {
+	if len(attempts) == 0 {
+		return CouldNotConnectError
+	}
+	return &ConnectError{Hostname: hostname, Attempts: attempts}
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("%s: %s", CouldNotConnectError, e.Attempts[len(e.Attempts)-1].Err)
+}
+
+func (e *ConnectError) Unwrap() []error {
+	errs := []error{CouldNotConnectError}
+	for _, attempt := range e.Attempts {
+		errs = append(errs, attempt.Err)
+	}
+	return errs
+}
+
+// Kind returns the classification of the last attempt
+func (e *ConnectError) Kind() ConnErrorKind {
+	return e.Attempts[len(e.Attempts)-1].Kind
+}
@@ -0,0 +1,69 @@
+package fastdialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// dialError wraps the errno like the errors returned by net.Dialer
+func dialError(errno syscall.Errno) error {
+	return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errno)}
+}
+
+func TestClassifyConnError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ConnErrorKind
+	}{
+		{"nil", nil, ConnUnknown},
+		{"deadline", &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}, ConnTimeout},
+		{"context", context.DeadlineExceeded, ConnTimeout},
+		{"wrapped", fmt.Errorf("%w: %w", CouldNotConnectError, dialError(connErrnos[ConnRefused][0])), ConnRefused},
+		{"handshake", errors.New("tls: handshake failure"), ConnUnknown},
+	}
+	for kind, errnos := range connErrnos {
+		for _, errno := range errnos {
+			tests = append(tests, struct {
+				name string
+				err  error
+				want ConnErrorKind
+			}{fmt.Sprintf("%s %d", kind, errno), dialError(errno), kind})
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, ClassifyConnError(tt.err))
+		})
+	}
+}
+
+func TestConnectError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.Nil(t, err)
+	listener.Close()
+
+	for _, delay := range []time.Duration{0, 50 * time.Millisecond} {
+		options := DefaultOptions
+		options.HappyEyeballsDelay = delay
+		fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1", "127.0.0.2"}, nil)})
+		_, err = fd.Dial(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+		require.ErrorIs(t, err, CouldNotConnectError, delay)
+		var connectErr *ConnectError
+		require.ErrorAs(t, err, &connectErr, delay)
+		require.Equal(t, "example.com", connectErr.Hostname)
+		require.Len(t, connectErr.Attempts, 2)
+		require.Equal(t, net.JoinHostPort("127.0.0.2", port), connectErr.Attempts[1].Address)
+		require.Equal(t, ConnRefused, connectErr.Kind(), delay)
+	}
+}
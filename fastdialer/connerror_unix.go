@@ -0,0 +1,12 @@
+//go:build !windows
+
+package fastdialer
+
+import "syscall"
+
+var connErrnos = map[ConnErrorKind][]syscall.Errno{
+	ConnRefused:     {syscall.ECONNREFUSED},
+	ConnTimeout:     {syscall.ETIMEDOUT},
+	ConnReset:       {syscall.ECONNRESET, syscall.ECONNABORTED},
+	ConnUnreachable: {syscall.ENETUNREACH, syscall.EHOSTUNREACH},
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package fastdialer
+
+import "syscall"
+
+// winsock error codes, syscall only defines a few of them
+const (
+	wsaECONNABORTED = syscall.Errno(10053)
+	wsaECONNRESET   = syscall.Errno(10054)
+	wsaETIMEDOUT    = syscall.Errno(10060)
+	wsaECONNREFUSED = syscall.Errno(10061)
+	wsaENETUNREACH  = syscall.Errno(10051)
+	wsaEHOSTUNREACH = syscall.Errno(10065)
+)
+
+var connErrnos = map[ConnErrorKind][]syscall.Errno{
+	ConnRefused:     {wsaECONNREFUSED},
+	ConnTimeout:     {wsaETIMEDOUT},
+	ConnReset:       {wsaECONNRESET, wsaECONNABORTED},
+	ConnUnreachable: {wsaENETUNREACH, wsaEHOSTUNREACH},
+}
@@ -0,0 +1,182 @@
+package fastdialer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// wrappedConn is a connection returned through the limits or Options.ConnWrappers, it keeps
+// the dialed one so that the dialer can still inspect its tls state
+type wrappedConn struct {
+	net.Conn
+	dialed net.Conn
+}
+
+// wrappedTLSConn is a wrapped tls or utls connection, it still exposes the negotiated state so
+// that the net/http versions checking for ConnectionState rather than *tls.Conn pick up the alpn
+// protocol and fill Response.TLS when used as DialTLSContext
+type wrappedTLSConn struct {
+	*wrappedConn
+}
+
+// ConnectionState returns the negotiated state of the dialed connection
+func (c *wrappedTLSConn) ConnectionState() tls.ConnectionState {
+	state, _ := connectionState(c.dialed)
+	return state
+}
+
+// NetConn returns the connection returned by Options.ConnWrappers
+func (c *wrappedTLSConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// wrapConn applies the connection limits then Options.ConnWrappers in order to an established
+// connection before it's returned to the caller. Wrapped tls connections no longer expose the tls
+// types but keep their ConnectionState method
+func (d *Dialer) wrapConn(conn net.Conn) net.Conn {
+	limited := d.options.MaxConnBytes > 0 || d.options.MaxConnDuration > 0
+	if conn == nil || (len(d.options.ConnWrappers) == 0 && !limited) {
+		return conn
+	}
+	wrapped := conn
+	if limited {
+		wrapped = newLimitedConn(conn, d.options.MaxConnBytes, d.options.MaxConnDuration)
+	}
+	for _, wrapper := range d.options.ConnWrappers {
+		wrapped = wrapper(wrapped)
+	}
+	wrappedConn := &wrappedConn{Conn: wrapped, dialed: conn}
+	if _, ok := connectionState(conn); ok {
+		return &wrappedTLSConn{wrappedConn: wrappedConn}
+	}
+	return wrappedConn
+}
+
+// dialedConn returns the connection as dialed, before the wrappers were applied
+func dialedConn(conn net.Conn) net.Conn {
+	switch wrapped := conn.(type) {
+	case *wrappedConn:
+		return wrapped.dialed
+	case *wrappedTLSConn:
+		return wrapped.dialed
+	default:
+		return conn
+	}
+}
+
+// LocalAddr returns the local address of a connection returned by the dialer, the one of the
+// dialed socket even when Options.ConnWrappers replace it
+func LocalAddr(conn net.Conn) net.Addr {
+	return dialedConn(conn).LocalAddr()
+}
+
+// limitedConn closes the connection once Options.MaxConnBytes were transferred or
+// Options.MaxConnDuration elapsed, the following reads and writes fail with ConnLimitExceededError
+type limitedConn struct {
+	net.Conn
+	maxBytes int64
+	timer    *time.Timer
+
+	mu          sync.Mutex
+	transferred int64
+	exceeded    error
+}
+
+func newLimitedConn(conn net.Conn, maxBytes int64, maxDuration time.Duration) *limitedConn {
+	limited := &limitedConn{Conn: conn, maxBytes: maxBytes}
+	if maxDuration > 0 {
+		limited.timer = time.AfterFunc(maxDuration, func() {
+			limited.exceed(fmt.Errorf("%w: %s elapsed", ConnLimitExceededError, maxDuration))
+		})
+	}
+	return limited
+}
+
+func (c *limitedConn) Read(b []byte) (int, error) {
+	allowed, err := c.reserve(b)
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(allowed)
+	c.release(len(allowed) - n)
+	return n, c.limitErr(err)
+}
+
+func (c *limitedConn) Write(b []byte) (int, error) {
+	allowed, err := c.reserve(b)
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Write(allowed)
+	c.release(len(allowed) - n)
+	if err == nil && n < len(b) {
+		// the limit was reached in the middle of the buffer
+		c.exceed(c.bytesErr())
+	}
+	return n, c.limitErr(err)
+}
+
+func (c *limitedConn) Close() error {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	return c.Conn.Close()
+}
+
+// reserve accounts for the part of the buffer transferable before the byte limit is reached,
+// the connection is closed if nothing is left
+func (c *limitedConn) reserve(b []byte) ([]byte, error) {
+	c.mu.Lock()
+	if c.exceeded == nil && c.maxBytes > 0 && len(b) > 0 {
+		remaining := c.maxBytes - c.transferred
+		if int64(len(b)) > remaining {
+			b = b[:remaining]
+		}
+		c.transferred += int64(len(b))
+		if len(b) == 0 {
+			c.mu.Unlock()
+			c.exceed(c.bytesErr())
+			return nil, c.limitErr(nil)
+		}
+	}
+	err := c.exceeded
+	c.mu.Unlock()
+	return b, err
+}
+
+// release gives back the reserved bytes which weren't transferred
+func (c *limitedConn) release(unused int) {
+	if c.maxBytes <= 0 || unused <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.transferred -= int64(unused)
+	c.mu.Unlock()
+}
+
+// exceed records the first exceeded limit and closes the connection
+func (c *limitedConn) exceed(err error) {
+	c.mu.Lock()
+	if c.exceeded == nil {
+		c.exceeded = err
+	}
+	c.mu.Unlock()
+	c.Close()
+}
+
+// limitErr replaces the error, usually caused by the closed connection, once a limit was exceeded
+func (c *limitedConn) limitErr(err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.exceeded != nil {
+		return c.exceeded
+	}
+	return err
+}
+
+func (c *limitedConn) bytesErr() error {
+	return fmt.Errorf("%w: %d bytes transferred", ConnLimitExceededError, c.maxBytes)
+}
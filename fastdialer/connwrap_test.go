@@ -0,0 +1,218 @@
+package fastdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingConn counts the bytes read and written through the connection
+type countingConn struct {
+	net.Conn
+	read, written *int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(c.read, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(c.written, int64(n))
+	return n, err
+}
+
+// newEchoServer starts a tcp listener echoing back what it receives and returns its address
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestConnWrappers(t *testing.T) {
+	var read, written int64
+	var order []string
+	options := DefaultOptions
+	options.ConnWrappers = []func(net.Conn) net.Conn{
+		func(conn net.Conn) net.Conn {
+			order = append(order, "counter")
+			return &countingConn{Conn: conn, read: &read, written: &written}
+		},
+		func(conn net.Conn) net.Conn {
+			// applied last, it wraps the counter
+			_, ok := conn.(*countingConn)
+			require.True(t, ok)
+			order = append(order, "outer")
+			return conn
+		},
+	}
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+
+	_, port, err := net.SplitHostPort(newEchoServer(t))
+	require.Nil(t, err)
+	conn, err := fd.Dial(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+	require.Nil(t, err)
+	defer conn.Close()
+	require.Equal(t, []string{"counter", "outer"}, order)
+
+	_, err = conn.Write([]byte("hello"))
+	require.Nil(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(buf))
+	require.Equal(t, int64(5), atomic.LoadInt64(&written))
+	require.Equal(t, int64(5), atomic.LoadInt64(&read))
+}
+
+func TestConnWrappersTLS(t *testing.T) {
+	var read, written int64
+	options := DefaultOptions
+	options.ConnWrappers = []func(net.Conn) net.Conn{
+		func(conn net.Conn) net.Conn {
+			return &countingConn{Conn: conn, read: &read, written: &written}
+		},
+	}
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+
+	_, port, err := net.SplitHostPort(newTestTLSServer(t, &tls.Config{}))
+	require.Nil(t, err)
+	conn, state, err := fd.DialTLSState(context.Background(), "tcp", net.JoinHostPort("localhost", port), nil)
+	require.Nil(t, err)
+	defer conn.Close()
+	// the tls state is still available and the handshake isn't counted
+	require.True(t, state.HandshakeComplete)
+	require.Zero(t, atomic.LoadInt64(&written))
+
+	_, err = conn.Write([]byte("hello"))
+	require.Nil(t, err)
+	require.Equal(t, int64(5), atomic.LoadInt64(&written))
+
+	// the wrapped connection still exposes its state
+	stater, ok := conn.(interface{ ConnectionState() tls.ConnectionState })
+	require.True(t, ok)
+	require.True(t, stater.ConnectionState().HandshakeComplete)
+	require.Equal(t, state.CipherSuite, stater.ConnectionState().CipherSuite)
+	_, ok = conn.(interface{ NetConn() net.Conn }).NetConn().(*countingConn)
+	require.True(t, ok)
+}
+
+func TestMaxConnBytes(t *testing.T) {
+	options := DefaultOptions
+	options.MaxConnBytes = 10
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+
+	conn, err := fd.Dial(context.Background(), "tcp", newEchoServer(t))
+	require.Nil(t, err)
+	defer conn.Close()
+
+	n, err := conn.Write([]byte("12345678"))
+	require.Nil(t, err)
+	require.Equal(t, 8, n)
+	// only the bytes left before the limit are read
+	buf := make([]byte, 8)
+	n, err = io.ReadAtLeast(conn, buf, 2)
+	require.Nil(t, err)
+	require.Equal(t, "12", string(buf[:n]))
+
+	_, err = conn.Read(buf)
+	require.ErrorIs(t, err, ConnLimitExceededError)
+	_, err = conn.Write([]byte("9"))
+	require.ErrorIs(t, err, ConnLimitExceededError)
+	// the connection itself is closed
+	_, err = dialedConn(conn).Write([]byte("9"))
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestMaxConnBytesWrite(t *testing.T) {
+	options := DefaultOptions
+	options.MaxConnBytes = 4
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+
+	conn, err := fd.Dial(context.Background(), "tcp", newEchoServer(t))
+	require.Nil(t, err)
+	defer conn.Close()
+
+	n, err := conn.Write([]byte("12345678"))
+	require.ErrorIs(t, err, ConnLimitExceededError)
+	require.Equal(t, 4, n)
+}
+
+func TestMaxConnDuration(t *testing.T) {
+	options := DefaultOptions
+	options.MaxConnDuration = 50 * time.Millisecond
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+
+	conn, err := fd.Dial(context.Background(), "tcp", newEchoServer(t))
+	require.Nil(t, err)
+	defer conn.Close()
+
+	// the echo server never sends anything unprompted
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, ConnLimitExceededError)
+	require.GreaterOrEqual(t, time.Since(start), options.MaxConnDuration)
+	_, err = conn.Write([]byte("1"))
+	require.ErrorIs(t, err, ConnLimitExceededError)
+}
+
+// fixedAddrConn reports a local address of its own
+type fixedAddrConn struct {
+	net.Conn
+}
+
+func (c *fixedAddrConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1}
+}
+
+func TestLocalAddr(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	accepted := make(chan net.Addr, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn.RemoteAddr()
+			conn.Close()
+		}
+	}()
+
+	options := DefaultOptions
+	options.ConnWrappers = []func(net.Conn) net.Conn{
+		func(conn net.Conn) net.Conn { return &fixedAddrConn{Conn: conn} },
+	}
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.Nil(t, err)
+	conn, info, err := fd.DialWithInfo(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+	require.Nil(t, err)
+	defer conn.Close()
+
+	// the address seen by the server is the one of the socket, hidden by the wrapper
+	remote := <-accepted
+	require.Equal(t, remote.String(), info.LocalAddr.String())
+	require.Equal(t, remote.String(), LocalAddr(conn).String())
+	require.NotEqual(t, remote.String(), conn.LocalAddr().String())
+}
@@ -1,5 +1,10 @@
 package fastdialer
 
+import (
+	"context"
+	"crypto/tls"
+)
+
 type ContextOption string
 
 const (
@@ -7,3 +12,69 @@ const (
 	SniName ContextOption = "sni-name"
 	IP      ContextOption = "ip"
 )
+
+type forcedIPKey struct{}
+
+// WithForcedIP restricts the dial to the ip, which must be one of the resolved addresses
+func WithForcedIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, forcedIPKey{}, ip)
+}
+
+type noCacheKey struct{}
+
+// WithNoCache makes the resolutions of this dial or GetDNSDataContext call bypass the dns
+// cache, nothing is read from or written to it
+func WithNoCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// noCacheFromContext checks if the context was returned by WithNoCache
+func noCacheFromContext(ctx context.Context) bool {
+	noCache, _ := ctx.Value(noCacheKey{}).(bool)
+	return noCache
+}
+
+type noSyscallFallbackKey struct{}
+
+// WithNoSyscallFallback forbids the syscall resolver for the resolutions of this dial or
+// GetDNSDataContext call, even with Options.EnableFallback, so they never reach the system resolver.
+// Cached data resolved through the fallback by other calls is still used
+func WithNoSyscallFallback(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noSyscallFallbackKey{}, true)
+}
+
+// noSyscallFallbackFromContext checks if the context was returned by WithNoSyscallFallback
+func noSyscallFallbackFromContext(ctx context.Context) bool {
+	noFallback, _ := ctx.Value(noSyscallFallbackKey{}).(bool)
+	return noFallback
+}
+
+type cacheNamespaceKey struct{}
+
+// WithCacheNamespace isolates the dns cache entries of this dial or GetDNSDataContext call in
+// the namespace, taking precedence over Options.CacheNamespace. Namespaces share the cache store,
+// the empty one holds the entries outside of any namespace
+func WithCacheNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, cacheNamespaceKey{}, namespace)
+}
+
+// cacheNamespaceFromContext returns the namespace set with WithCacheNamespace, if any
+func cacheNamespaceFromContext(ctx context.Context) (string, bool) {
+	namespace, ok := ctx.Value(cacheNamespaceKey{}).(string)
+	return namespace, ok
+}
+
+type tlsConfigKey struct{}
+
+// WithTLSConfig sets the tls config used by DialTLS for this dial, taking precedence over
+// the insecure default and Options.WithZTLS. The server name is set to the hostname only if
+// the config leaves it empty
+func WithTLSConfig(ctx context.Context, config *tls.Config) context.Context {
+	return context.WithValue(ctx, tlsConfigKey{}, config)
+}
+
+// tlsConfigFromContext returns the tls config set with WithTLSConfig, if any
+func tlsConfigFromContext(ctx context.Context) *tls.Config {
+	config, _ := ctx.Value(tlsConfigKey{}).(*tls.Config)
+	return config
+}
@@ -9,18 +9,20 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/boss-net/fastdialer/fastdialer/ja3/impersonate"
-	"github.com/boss-net/hmap/store/hybrid"
-	"github.com/projectdiscovery/networkpolicy"
-	retryabledns "github.com/boss-net/retryabledns"
 	cryptoutil "github.com/boss-net/goutils/crypto"
 	errorutil "github.com/boss-net/goutils/errors"
 	iputil "github.com/boss-net/goutils/ip"
-	ptrutil "github.com/boss-net/goutils/ptr"
-	utls "github.com/refraction-networking/utls"
+	sliceutil "github.com/boss-net/goutils/slice"
+	"github.com/boss-net/hmap/store/hybrid"
+	retryabledns "github.com/boss-net/retryabledns"
+	"github.com/projectdiscovery/networkpolicy"
 	"github.com/zmap/zcrypto/encoding/asn1"
 	ztls "github.com/zmap/zcrypto/tls"
 	"golang.org/x/net/proxy"
@@ -41,18 +43,38 @@ func init() {
 
 // Dialer structure containing data information
 type Dialer struct {
-	options       *Options
-	dnsclient     *retryabledns.Client
-	hm            *hybrid.HybridMap
-	dialerHistory *hybrid.HybridMap
-	dialerTLSData *hybrid.HybridMap
-	dialer        *net.Dialer
-	proxyDialer   *proxy.Dialer
-	networkpolicy *networkpolicy.NetworkPolicy
+	options         *Options
+	dnsclient       dnsResolver
+	resolvers       []string
+	services        map[string]serviceAddress
+	hm              Cache
+	dialerHistory   *hybrid.HybridMap
+	dialerTLSData   *hybrid.HybridMap
+	dialer          *net.Dialer
+	proxyDialer     *proxy.Dialer
+	policyMu        sync.RWMutex
+	networkpolicy   *networkpolicy.NetworkPolicy
+	policyRules     networkpolicy.Options
+	sessionCache    tls.ClientSessionCache
+	syscallResolver ipAddrResolver
+	literals        sync.Map
+	literalsCount   atomic.Int32
+	coalescing      sync.Map
+	adaptive        AdaptiveStrategy // used with Options.AdaptiveSelection
+	// ctx is canceled on close, background goroutines must stop once it's done
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	closed    chan struct{}
 }
 
 // NewDialer instance
 func NewDialer(options Options) (*Dialer, error) {
+	return NewDialerWithContext(context.Background(), options)
+}
+
+// NewDialerWithContext returns a dialer closed once the context is done, as if Close was called
+func NewDialerWithContext(ctx context.Context, options Options) (*Dialer, error) {
 	var resolvers []string
 	// Add system resolvers as the first to be tried
 	if options.ResolversFile {
@@ -62,17 +84,29 @@ func NewDialer(options Options) (*Dialer, error) {
 		}
 	}
 
-	cacheOptions := getHMapConfiguration(options)
-	resolvers = append(resolvers, options.BaseResolvers...)
-	hm, err := hybrid.New(cacheOptions)
-	if err != nil {
-		return nil, err
+	resolvers = append(resolvers, normalizeResolvers(options.BaseResolvers)...)
+	hm := options.Cache
+	if hm == nil {
+		cacheOptions := getHMapConfiguration(options)
+		hybridMap, err := hybrid.New(cacheOptions)
+		if err != nil {
+			return nil, err
+		}
+		hm = &hybridCache{HybridMap: hybridMap}
+	}
+	if options.L1CacheSize > 0 {
+		hm = newL1Cache(hm, options.L1CacheSize)
 	}
+	var err error
 	var dialerHistory *hybrid.HybridMap
-	if options.WithDialerHistory {
+	if options.WithDialerHistory && !options.DisableDialHistory {
 		// we need to use disk to store all the dialed ips
 		dialerHistoryCacheOptions := hybrid.DefaultDiskOptions
 		dialerHistoryCacheOptions.DBType = getHMAPDBType(options)
+		dialerHistoryCacheOptions = storeOptions(options, dialerHistoryCacheOptions, "history")
+		if options.DialHistoryInMemory {
+			dialerHistoryCacheOptions = hybrid.DefaultMemoryOptions
+		}
 		dialerHistory, err = hybrid.New(dialerHistoryCacheOptions)
 		if err != nil {
 			return nil, err
@@ -80,7 +114,7 @@ func NewDialer(options Options) (*Dialer, error) {
 	}
 	var dialerTLSData *hybrid.HybridMap
 	if options.WithTLSData {
-		dialerTLSData, err = hybrid.New(hybrid.DefaultDiskOptions)
+		dialerTLSData, err = hybrid.New(storeOptions(options, hybrid.DefaultDiskOptions, "tlsdata"))
 		if err != nil {
 			return nil, err
 		}
@@ -91,20 +125,83 @@ func NewDialer(options Options) (*Dialer, error) {
 		dialer = options.Dialer
 	} else {
 		dialer = &net.Dialer{
-			Timeout:   options.DialerTimeout,
-			KeepAlive: options.DialerKeepAlive,
-			DualStack: true,
+			Timeout:       options.DialerTimeout,
+			KeepAlive:     options.DialerKeepAlive,
+			DualStack:     true,
+			FallbackDelay: options.FallbackDelay,
 		}
 	}
+	// the control hook runs on the sockets of direct dials only, proxies open their own,
+	// and the socket options it sets are os specific
+	if options.Control != nil {
+		// work on a copy to leave the provided dialer untouched
+		dialerCopy := *dialer
+		dialerCopy.Control = chainControl(dialer.Control, options.Control)
+		dialer = &dialerCopy
+	}
+	if options.SendProxyProtocol < 0 || options.SendProxyProtocol > 2 {
+		return nil, fmt.Errorf("%w: version %d", InvalidProxyProtocolError, options.SendProxyProtocol)
+	}
+	if err := validateFingerprints(options.ProxyTLSFallbackFingerprints); err != nil {
+		return nil, err
+	}
+	if options.SourcePortRange != [2]int{} && !validSourcePortRange(options.SourcePortRange) {
+		return nil, InvalidSourcePortRangeError
+	}
+	bind, err := parseBindAddresses(options.BindAddresses)
+	if err != nil {
+		return nil, err
+	}
+	if options.SourcePortRange != [2]int{} || bind != nil {
+		// work on a copy to leave the provided dialer untouched
+		dialerCopy := *dialer
+		dialerCopy.Control = sourcePortControl(options.SourcePortRange, bind, dialer.Control)
+		dialer = &dialerCopy
+	}
 
 	// load hardcoded values from host file
 	if options.HostsFile {
 		// nolint:errcheck // if they cannot be loaded it's not a hard failure
 		loadHostsFile(hm)
 	}
-	dnsclient, err := retryabledns.New(resolvers, options.MaxRetries)
-	if err != nil {
-		return nil, err
+	if options.DNSForceTCP {
+		resolvers = forceTCPResolvers(resolvers)
+	}
+	dotResolvers := options.DoTResolvers
+	var encrypted []dnsResolver
+	if len(options.BootstrapResolvers) > 0 {
+		if resolvers, dotResolvers, encrypted, err = bootstrapResolvers(options, resolvers); err != nil {
+			return nil, err
+		}
+	}
+	var dnsclient dnsResolver
+	if len(resolvers) > 0 || len(dotResolvers)+len(encrypted) == 0 {
+		if options.SortResolversByRTT {
+			dnsclient, err = newRTTResolvers(resolvers, options.MaxRetries)
+		} else {
+			dnsclient, err = newDNSClient(resolvers, options.MaxRetries)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(dotResolvers) > 0 {
+		dotclient, err := newDoTResolvers(dotResolvers, options.DoTServerName, options.MaxRetries)
+		if err != nil {
+			return nil, err
+		}
+		encrypted = append(encrypted, dotclient)
+	}
+	// the plain resolvers, if any, are queried once the encrypted ones failed
+	switch {
+	case len(encrypted) == 1 && dnsclient == nil:
+		dnsclient = encrypted[0]
+	case len(encrypted) > 0:
+		chain := chainedResolvers(encrypted)
+		if dnsclient != nil {
+			chain = append(chain, dnsclient)
+		}
+		dnsclient = chain
 	}
 
 	var npOptions networkpolicy.Options
@@ -112,13 +209,59 @@ func NewDialer(options Options) (*Dialer, error) {
 	npOptions.DenyList = append(npOptions.DenyList, options.Deny...)
 	// Populate allow list if necessary
 	npOptions.AllowList = append(npOptions.AllowList, options.Allow...)
+	if options.DenyFile != "" {
+		denyList, err := loadPolicyFile(options.DenyFile, options.warnf)
+		if err != nil {
+			return nil, err
+		}
+		npOptions.DenyList = append(npOptions.DenyList, denyList...)
+	}
+	if options.AllowFile != "" {
+		allowList, err := loadPolicyFile(options.AllowFile, options.warnf)
+		if err != nil {
+			return nil, err
+		}
+		npOptions.AllowList = append(npOptions.AllowList, allowList...)
+	}
 
 	np, err := networkpolicy.New(npOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Dialer{dnsclient: dnsclient, hm: hm, dialerHistory: dialerHistory, dialerTLSData: dialerTLSData, dialer: dialer, proxyDialer: options.ProxyDialer, options: &options, networkpolicy: np}, nil
+	services, err := parseServiceMap(options.ServiceMap)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessionCache tls.ClientSessionCache
+	if options.TLSSessionCacheSize > 0 {
+		sessionCache = tls.NewLRUClientSessionCache(options.TLSSessionCacheSize)
+	}
+
+	proxyDialer := options.ProxyDialer
+	if len(options.ProxyChain) > 0 {
+		var forward proxy.Dialer = dialer
+		if proxyDialer != nil {
+			forward = *proxyDialer
+		}
+		chain, err := newProxyChain(options.ProxyChain, forward)
+		if err != nil {
+			return nil, err
+		}
+		proxyDialer = &chain
+		if resolvesRemotely(options.ProxyChain) {
+			options.ProxyDNS = true
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	d := &Dialer{dnsclient: dnsclient, resolvers: resolvers, services: services, hm: hm, dialerHistory: dialerHistory, dialerTLSData: dialerTLSData, dialer: dialer, proxyDialer: proxyDialer, options: &options, networkpolicy: np, policyRules: npOptions, sessionCache: sessionCache, syscallResolver: net.DefaultResolver, ctx: ctx, cancel: cancel, closed: make(chan struct{})}
+	go func() {
+		<-ctx.Done()
+		d.Close()
+	}()
+	return d, nil
 }
 
 // Dial function compatible with net/http
@@ -127,12 +270,39 @@ func (d *Dialer) Dial(ctx context.Context, network, address string) (conn net.Co
 	return
 }
 
+// DialUDP resolves the address and connects a datagram socket to the first ip allowed by the network
+// policy. As udp is connectionless, connecting doesn't check the ip is reachable: errors are only
+// reported by reads and writes. Proxies don't support udp, so it fails if one is configured
+func (d *Dialer) DialUDP(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+	default:
+		return nil, fmt.Errorf("%w: %q", UnsupportedNetworkError, network)
+	}
+	if d.proxyDialer != nil {
+		return nil, UDPOverProxyError
+	}
+	return d.dial(ctx, network, address, false, false, nil, nil, impersonate.None, nil)
+}
+
 // DialTLS with encrypted connection
 func (d *Dialer) DialTLS(ctx context.Context, network, address string) (conn net.Conn, err error) {
+	if config := tlsConfigFromContext(ctx); config != nil {
+		return d.DialTLSWithConfig(ctx, network, address, config)
+	}
 	if d.options.WithZTLS {
 		return d.DialZTLSWithConfig(ctx, network, address, &ztls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS10})
 	}
-	return d.DialTLSWithConfig(ctx, network, address, &tls.Config{Renegotiation: tls.RenegotiateOnceAsClient, InsecureSkipVerify: true, MinVersion: tls.VersionTLS10})
+	return d.DialTLSWithConfig(ctx, network, address, d.defaultTLSConfig())
+}
+
+// defaultTLSConfig returns a copy of Options.DefaultTLSConfig, or the insecure tls config,
+// used when none is provided
+func (d *Dialer) defaultTLSConfig() *tls.Config {
+	if d.options.DefaultTLSConfig != nil {
+		return d.options.DefaultTLSConfig.Clone()
+	}
+	return &tls.Config{Renegotiation: d.options.Renegotiation, InsecureSkipVerify: true, MinVersion: tls.VersionTLS10}
 }
 
 // DialZTLS with encrypted connection using ztls
@@ -166,9 +336,63 @@ func (d *Dialer) DialZTLSWithConfig(ctx context.Context, network, address string
 	return d.dial(ctx, network, address, false, true, nil, config, impersonate.None, nil)
 }
 
+// DialWithIPs dials the hostname using the given ips in order, without resolving it.
+// The domain and network policies are still applied and the hostname is used as tls server name
+func (d *Dialer) DialWithIPs(ctx context.Context, network, hostname string, ips []string, port string, useTLS bool) (conn net.Conn, err error) {
+	if d.options.ResolveOnly {
+		return nil, ErrDialingDisabled
+	}
+	if len(ips) == 0 {
+		return nil, NoAddressFoundError
+	}
+	if network, err = normalizeNetwork(network, useTLS); err != nil {
+		return nil, err
+	}
+	hostname = asAscii(hostname)
+	if err := d.validateDomain(hostname); err != nil {
+		return nil, err
+	}
+	switch {
+	case !useTLS:
+		conn, err = d.dialIPs(ctx, network, hostname, port, ips, false, false, nil, nil, impersonate.None, nil)
+	case d.options.WithZTLS:
+		conn, err = d.dialIPs(ctx, network, hostname, port, ips, false, true, nil, &ztls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS10}, impersonate.None, nil)
+	default:
+		conn, err = d.dialIPs(ctx, network, hostname, port, ips, true, false, d.defaultTLSConfig(), nil, impersonate.None, nil)
+	}
+	return d.wrapConn(conn), err
+}
+
+// DialAny dials the hostnames in order on the same port and returns the first established
+// connection along with the hostname it was established to
+func (d *Dialer) DialAny(ctx context.Context, network string, hosts []string, port string) (net.Conn, string, error) {
+	if len(hosts) == 0 {
+		return nil, "", NoAddressFoundError
+	}
+	var errs []error
+	for _, host := range hosts {
+		conn, err := d.Dial(ctx, network, net.JoinHostPort(host, port))
+		if err == nil {
+			return conn, host, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, "", ctxErr
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", host, err))
+	}
+	return nil, "", errors.Join(errs...)
+}
+
 func (d *Dialer) dial(ctx context.Context, network, address string, shouldUseTLS, shouldUseZTLS bool, tlsconfig *tls.Config, ztlsconfig *ztls.Config, impersonateStrategy impersonate.Strategy, impersonateIdentity *impersonate.Identity) (conn net.Conn, err error) {
 	var hostname, port, fixedIP string
 
+	if d.options.ResolveOnly {
+		return nil, ErrDialingDisabled
+	}
+	if network, err = normalizeNetwork(network, shouldUseTLS || shouldUseZTLS); err != nil {
+		return nil, err
+	}
+
 	if strings.HasPrefix(address, "[") {
 		closeBracketIndex := strings.Index(address, "]")
 		if closeBracketIndex == -1 {
@@ -201,205 +425,429 @@ func (d *Dialer) dial(ctx context.Context, network, address string, shouldUseTLS
 		}
 	}
 
-	// check if data is in cache
+	if d.options.MaxDialDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.options.MaxDialDuration)
+		defer cancel()
+	}
+
 	hostname = asAscii(hostname)
-	data, err := d.GetDNSData(hostname)
+	// blocked domains are never resolved
+	if err := d.validateDomain(hostname); err != nil {
+		return nil, err
+	}
+	// pinned hostnames are dialed without resolving them, an ip given with the address wins
+	if service, ok := d.lookupService(hostname); ok && fixedIP == "" {
+		if service.port != "" {
+			port = service.port
+		}
+		ips, err := restrictIPs(ctx, network, []string{service.ip})
+		if err != nil {
+			return nil, err
+		}
+		conn, err = d.dialIPs(ctx, network, hostname, port, ips, shouldUseTLS, shouldUseZTLS, tlsconfig, ztlsconfig, impersonateStrategy, impersonateIdentity)
+		if ctxErr := contextErr(ctx); err != nil && ctxErr != nil {
+			return nil, ctxErr
+		}
+		return d.wrapConn(conn), err
+	}
+	// the proxy resolves the hostname, neither the dns cache nor the ip based policy apply
+	if d.options.ProxyDNS && d.proxyDialer != nil && fixedIP == "" && !iputil.IsIP(withoutZone(strings.Trim(hostname, "[]"))) {
+		conn, err = d.dialIPs(ctx, network, hostname, port, []string{hostname}, shouldUseTLS, shouldUseZTLS, tlsconfig, ztlsconfig, impersonateStrategy, impersonateIdentity)
+		if ctxErr := contextErr(ctx); err != nil && ctxErr != nil {
+			return nil, ctxErr
+		}
+		return d.wrapConn(conn), err
+	}
+	// check if data is in cache
+	data, err := d.getDNSData(ctx, hostname)
 	if err != nil {
-		// otherwise attempt to retrieve it
-		data, err = d.dnsclient.Resolve(hostname)
-
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
 	}
-	if data == nil {
-		return nil, ResolveHostError
+	if info := dialInfoFromContext(ctx); info != nil {
+		info.ARecords, info.AAAARecords = len(data.A), len(data.AAAA)
 	}
 
-	if err != nil || len(data.A)+len(data.AAAA) == 0 {
-		return nil, NoAddressFoundError
+	if len(data.A)+len(data.AAAA) == 0 {
+		return nil, newResolveError(hostname, data, NoAddressFoundError, nil)
 	}
 
-	var numInvalidIPS int
 	var IPS []string
 	// use fixed ip as first
 	if fixedIP != "" {
-		IPS = append(IPS, fixedIP)
+		IPS = append(IPS, unmapIPv4(fixedIP))
 	} else {
 		IPS = append(IPS, append(data.A, data.AAAA...)...)
 	}
+	if IPS, err = restrictIPs(ctx, network, IPS); err != nil {
+		return nil, err
+	}
+	conn, err = d.dialIPs(ctx, network, hostname, port, IPS, shouldUseTLS, shouldUseZTLS, tlsconfig, ztlsconfig, impersonateStrategy, impersonateIdentity)
+	if ctxErr := contextErr(ctx); err != nil && ctxErr != nil {
+		return nil, ctxErr
+	}
+	return d.wrapConn(conn), err
+}
 
-	// Dial to the IPs finally.
+// restrictIPs applies WithForcedIP and the family of tcp4 and tcp6 networks to the ips to dial
+func restrictIPs(ctx context.Context, network string, IPS []string) ([]string, error) {
+	if forcedIP, ok := ctx.Value(forcedIPKey{}).(string); ok {
+		forcedIP = unmapIPv4(forcedIP)
+		if !sliceutil.Contains(IPS, forcedIP) {
+			return nil, fmt.Errorf("%w: %s", ForcedIPNotResolvedError, forcedIP)
+		}
+		IPS = []string{forcedIP}
+	}
+	if IPS = filterNetworkFamily(network, IPS); len(IPS) == 0 {
+		return nil, fmt.Errorf("%w: %s", NoAddressFoundError, network)
+	}
+	return IPS, nil
+}
+
+// onDialed applies the socket options and records the established connection, failing
+// writes to the history and tls data stores are logged without failing the dial. The ip of
+// the connections to hostnames resolved by the proxy is unknown and left empty
+func (d *Dialer) onDialed(ctx context.Context, conn net.Conn, hostname, ip string, shouldUseTLS bool) error {
+	if err := d.tuneConn(conn); err != nil {
+		return err
+	}
+	proxyResolved := !iputil.IsIP(withoutZone(ip))
+	if proxyResolved {
+		ip = ""
+	}
+	if d.options.WithDialerHistory && d.dialerHistory != nil && !proxyResolved {
+		if err := d.recordDialedIP(hostname, ip); err != nil {
+			d.options.warnf("could not record dialed ip of %s: %s", hostname, err)
+		}
+	}
+	if d.options.OnDialCallback != nil {
+		d.options.OnDialCallback(hostname, ip)
+	}
+	info := dialInfoFromContext(ctx)
+	if info != nil {
+		info.Hostname = hostname
+		info.IP = ip
+		info.ProxyResolved = proxyResolved
+		info.LocalAddr = conn.LocalAddr()
+		info.tls = isTLSConn(conn)
+	}
+	if shouldUseTLS && (info != nil || d.options.WithCoalescingData) {
+		names := certificateNames(conn)
+		if info != nil {
+			info.SANs = names
+		}
+		// the proxy may resolve the hostnames sharing a certificate to distinct ips
+		if d.options.WithCoalescingData && !proxyResolved {
+			d.recordCoalesceData(hostname, ip, names)
+		}
+	}
+	if d.options.WithTLSData && shouldUseTLS {
+		if connTLS, ok := conn.(*tls.Conn); ok {
+			var data bytes.Buffer
+			connState := connTLS.ConnectionState()
+			if err := json.NewEncoder(&data).Encode(cryptoutil.TLSGrab(&connState)); err != nil {
+				return err
+			}
+			if err := d.dialerTLSData.Set(hostname, data.Bytes()); err != nil {
+				d.options.warnf("could not record tls data of %s: %s", hostname, err)
+			}
+		}
+	}
+	return nil
+}
+
+// dialIPs dials the ips allowed by the network policy through the dial strategy and returns the established connection
+func (d *Dialer) dialIPs(ctx context.Context, network, hostname, port string, IPS []string, shouldUseTLS, shouldUseZTLS bool, tlsconfig *tls.Config, ztlsconfig *ztls.Config, impersonateStrategy impersonate.Strategy, impersonateIdentity *impersonate.Identity) (net.Conn, error) {
+	var candidates, blockedIPS []string
+	// merged upstream answers may repeat addresses, avoid dialing them twice
+	IPS = sliceutil.Dedupe(IPS)
+	// rewritten addresses of the candidates
+	targets := make(map[string]dialTarget)
 	for _, ip := range IPS {
+		if d.options.NoIPv6 && iputil.IsIPv6(withoutZone(ip)) {
+			continue
+		}
 		// check if we have allow/deny list
-		if !d.networkpolicy.Validate(ip) {
-			numInvalidIPS++
+		if !d.policy().Validate(withoutZone(ip)) {
+			blockedIPS = append(blockedIPS, ip)
 			continue
 		}
-		hostPort := net.JoinHostPort(ip, port)
-		if shouldUseTLS {
-			tlsconfigCopy := tlsconfig.Clone()
-			switch {
-			case d.options.SNIName != "":
-				tlsconfigCopy.ServerName = d.options.SNIName
-			case ctx.Value(SniName) != nil:
-				sniName := ctx.Value(SniName).(string)
-				tlsconfigCopy.ServerName = sniName
-			case !iputil.IsIP(hostname):
-				tlsconfigCopy.ServerName = hostname
-			}
-			if impersonateStrategy == impersonate.None {
-				conn, err = tls.DialWithDialer(d.dialer, network, hostPort, tlsconfigCopy)
-			} else {
-				nativeConn, err := d.dialer.DialContext(ctx, network, hostPort)
-				if err != nil {
-					return nativeConn, err
-				}
-				// clone existing tls config
-				uTLSConfig := &utls.Config{
-					InsecureSkipVerify: tlsconfigCopy.InsecureSkipVerify,
-					ServerName:         tlsconfigCopy.ServerName,
-					MinVersion:         tlsconfigCopy.MinVersion,
-					MaxVersion:         tlsconfigCopy.MaxVersion,
-					CipherSuites:       tlsconfigCopy.CipherSuites,
-				}
-				var uTLSConn *utls.UConn
-				if impersonateStrategy == impersonate.Random {
-					uTLSConn = utls.UClient(nativeConn, uTLSConfig, utls.HelloRandomized)
-				} else if impersonateStrategy == impersonate.Custom {
-					uTLSConn = utls.UClient(nativeConn, uTLSConfig, utls.HelloCustom)
-					clientHelloSpec := utls.ClientHelloSpec(ptrutil.Safe(impersonateIdentity))
-					if err := uTLSConn.ApplyPreset(&clientHelloSpec); err != nil {
-						return nil, err
-					}
-				}
-				if err := uTLSConn.Handshake(); err != nil {
-					return nil, err
-				}
-				conn = uTLSConn
+		targetIP, targetPort, allowed := d.rewriteAddress(hostname, ip, port)
+		if !allowed {
+			blockedIPS = append(blockedIPS, ip)
+			continue
+		}
+		candidates = append(candidates, ip)
+		targets[ip] = dialTarget{ip: targetIP, port: targetPort}
+	}
+	if len(candidates) == 0 {
+		if len(blockedIPS) == len(IPS) {
+			return nil, &BlockedError{Hostname: hostname, IPs: blockedIPS}
+		}
+		return nil, CouldNotConnectError
+	}
+	// utls and ztls don't support ech
+	if len(d.options.ECHConfigList) > 0 && (shouldUseZTLS || (shouldUseTLS && impersonateStrategy != impersonate.None)) {
+		return nil, ECHNotSupportedError
+	}
+	// nor does ztls call the verification, the handshake can't silently skip it
+	if d.options.VerifyConnection != nil && shouldUseZTLS {
+		return nil, VerifyNotSupportedError
+	}
+
+	strategyCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		attempts []ConnectAttempt
+		// errors which aren't worth trying the other ips for
+		fatalErr error
+		dialed   = make(map[net.Conn]dialTarget)
+	)
+	dial := func(ctx context.Context, ip string) (net.Conn, error) {
+		target := targets[ip]
+		conn, err := d.dialAttempt(ctx, network, hostname, &target, shouldUseTLS, shouldUseZTLS, tlsconfig, ztlsconfig, impersonateStrategy, impersonateIdentity)
+		mu.Lock()
+		defer mu.Unlock()
+		var fatal *fatalDialError
+		switch {
+		case err == nil:
+			dialed[conn] = target
+		case errors.As(err, &fatal):
+			fatalErr = fatal.err
+			cancel()
+			return nil, fatal.err
+		default:
+			attempts = append(attempts, ConnectAttempt{Address: net.JoinHostPort(target.ip, target.port), Kind: target.kind, Err: err})
+		}
+		return conn, err
+	}
+	conn, err := d.dialStrategy(shouldUseTLS || shouldUseZTLS).Dial(strategyCtx, hostname, candidates, dial)
+
+	mu.Lock()
+	target, ok := dialed[conn]
+	failed, fatal := append([]ConnectAttempt(nil), attempts...), fatalErr
+	mu.Unlock()
+	switch {
+	case fatal != nil:
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, fatal
+	case err != nil || !ok:
+		if conn != nil {
+			conn.Close()
+		}
+		if len(failed) == 0 && err != nil {
+			return nil, fmt.Errorf("%w: %w", CouldNotConnectError, err)
+		}
+		return nil, newConnectError(hostname, failed)
+	}
+	if err := d.onDialed(ctx, conn, hostname, target.ip, shouldUseTLS); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	target.timings.record(ctx)
+	return conn, nil
+}
+
+// dialTarget is the address an ip is dialed at after the rewrites, along with the outcome of the dial
+type dialTarget struct {
+	ip, port string
+	timings  dialTimings
+	kind     ConnErrorKind
+}
+
+// fatalDialError stops the dial strategy, the other ips would fail the same way
+type fatalDialError struct {
+	err error
+}
+
+func (e *fatalDialError) Error() string {
+	return e.err.Error()
+}
+
+// dialAttempt establishes a single plain, tls or ztls connection to the target, falling back to ztls on tls handshake errors
+func (d *Dialer) dialAttempt(ctx context.Context, network, hostname string, target *dialTarget, shouldUseTLS, shouldUseZTLS bool, tlsconfig *tls.Config, ztlsconfig *ztls.Config, impersonateStrategy impersonate.Strategy, impersonateIdentity *impersonate.Identity) (conn net.Conn, err error) {
+	hostPort := net.JoinHostPort(target.ip, target.port)
+	if shouldUseTLS {
+		tlsconfigCopy := tlsconfig.Clone()
+		switch {
+		case tlsconfig.ServerName != "" && tlsconfig == tlsConfigFromContext(ctx):
+			// keep the server name of the per dial config
+		case d.options.SNIMap[hostname] != "":
+			tlsconfigCopy.ServerName = d.options.SNIMap[hostname]
+		case d.options.SNIName != "":
+			tlsconfigCopy.ServerName = d.options.SNIName
+		case ctx.Value(SniName) != nil:
+			sniName := ctx.Value(SniName).(string)
+			tlsconfigCopy.ServerName = sniName
+		case !iputil.IsIP(hostname):
+			tlsconfigCopy.ServerName = hostname
+		}
+		if err := d.applyTLSOptions(tlsconfigCopy); err != nil {
+			return nil, &fatalDialError{err: err}
+		}
+		switch {
+		case d.proxyDialer != nil:
+			conn, err = d.dialTLSOverProxy(ctx, network, hostPort, tlsconfigCopy, impersonateStrategy, impersonateIdentity, &target.timings)
+		case impersonateStrategy == impersonate.None:
+			// like tls.Dialer, ip addresses are verified against the dialed one
+			if tlsconfigCopy.ServerName == "" {
+				tlsconfigCopy.ServerName = withoutZone(target.ip)
 			}
-		} else if shouldUseZTLS {
-			ztlsconfigCopy := ztlsconfig.Clone()
-			switch {
-			case d.options.SNIName != "":
-				ztlsconfigCopy.ServerName = d.options.SNIName
-			case ctx.Value(SniName) != nil:
-				sniName := ctx.Value(SniName).(string)
-				ztlsconfigCopy.ServerName = sniName
-			case !iputil.IsIP(hostname):
-				ztlsconfigCopy.ServerName = hostname
+			conn, err = d.connectTLS(ctx, network, hostPort, tlsconfigCopy, impersonateStrategy, impersonateIdentity, &target.timings)
+		default:
+			conn, err = d.connectTLS(ctx, network, hostPort, tlsconfigCopy, impersonateStrategy, impersonateIdentity, &target.timings)
+			if err != nil {
+				return nil, &fatalDialError{err: err}
 			}
-			conn, err = ztls.DialWithDialer(d.dialer, network, hostPort, ztlsconfigCopy)
+		}
+	} else if shouldUseZTLS {
+		ztlsconfigCopy := ztlsconfig.Clone()
+		switch {
+		case d.options.SNIMap[hostname] != "":
+			ztlsconfigCopy.ServerName = d.options.SNIMap[hostname]
+		case d.options.SNIName != "":
+			ztlsconfigCopy.ServerName = d.options.SNIName
+		case ctx.Value(SniName) != nil:
+			sniName := ctx.Value(SniName).(string)
+			ztlsconfigCopy.ServerName = sniName
+		case !iputil.IsIP(hostname):
+			ztlsconfigCopy.ServerName = hostname
+		}
+		conn, err = d.withConnectRetries(ctx, func() (net.Conn, error) {
+			return d.dialZTLS(ctx, network, hostPort, ztlsconfigCopy)
+		})
+	} else {
+		start := time.Now()
+		if d.proxyDialer != nil {
+			conn, err = d.dialProxy(ctx, network, hostPort)
 		} else {
-			if d.proxyDialer != nil {
-				dialer := *d.proxyDialer
-				// timeout not working for socks5 proxy dialer
-				// tying to handle it here
-				connectionCh := make(chan net.Conn, 1)
-				errCh := make(chan error, 1)
-				go func() {
-					conn, err = dialer.Dial(network, hostPort)
-					if err != nil {
-						errCh <- err
-						return
-					}
-					connectionCh <- conn
-				}()
-				// using timer as time.After is not recovered gy GC
-				dialerTime := time.NewTimer(d.options.DialerTimeout)
-				defer dialerTime.Stop()
-				select {
-				case <-dialerTime.C:
-					return nil, fmt.Errorf("timeout after %v", d.options.DialerTimeout)
-				case conn = <-connectionCh:
-				case err = <-errCh:
-				}
-			} else {
-				conn, err = d.dialer.DialContext(ctx, network, hostPort)
-			}
+			conn, err = d.withConnectRetries(ctx, func() (net.Conn, error) {
+				return d.connect(ctx, network, hostPort)
+			})
 		}
-		// fallback to ztls  in case of handshake error with chrome ciphers
-		// ztls fallback can either be disabled by setting env variable DISABLE_ZTLS_FALLBACK=true or by setting DisableZtlsFallback=true in options
-		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) && !(d.options.DisableZtlsFallback && disableZTLSFallback) {
-			var ztlsconfigCopy *ztls.Config
-			if shouldUseZTLS {
-				ztlsconfigCopy = ztlsconfig.Clone()
-			} else {
-				if tlsconfig == nil {
-					tlsconfig = &tls.Config{
-						Renegotiation:      tls.RenegotiateOnceAsClient,
-						MinVersion:         tls.VersionTLS10,
-						InsecureSkipVerify: true,
-					}
-				}
-				ztlsconfigCopy, err = AsZTLSConfig(tlsconfig)
-				if err != nil {
-					return nil, errorutil.NewWithErr(err).Msgf("could not convert tls config to ztls config")
-				}
+		target.timings.connect = time.Since(start)
+	}
+	if err == nil {
+		return conn, nil
+	}
+	// classified before the fallback which hides the syscall error
+	target.kind = ClassifyConnError(err)
+	// fallback to ztls  in case of handshake error with chrome ciphers
+	// ztls fallback can either be disabled by setting env variable DISABLE_ZTLS_FALLBACK=true or by setting DisableZtlsFallback=true in options
+	if (shouldUseTLS || shouldUseZTLS) && contextErr(ctx) == nil && !errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(err, SourcePortExhaustedError) && !strings.HasPrefix(network, "udp") && d.allowZTLSFallback() {
+		var ztlsconfigCopy *ztls.Config
+		if shouldUseZTLS {
+			ztlsconfigCopy = ztlsconfig.Clone()
+		} else {
+			if tlsconfig == nil {
+				tlsconfig = d.defaultTLSConfig()
+			}
+			ztlsconfigCopy, err = AsZTLSConfig(tlsconfig)
+			if err != nil {
+				return nil, &fatalDialError{err: errorutil.NewWithErr(err).Msgf("could not convert tls config to ztls config")}
 			}
-			ztlsconfigCopy.CipherSuites = ztls.ChromeCiphers
-			conn, err = ztls.DialWithDialer(d.dialer, network, hostPort, ztlsconfigCopy)
-			err = errorutil.WrapfWithNil(err, "ztls fallback failed")
 		}
+		ztlsconfigCopy.CipherSuites = ztls.ChromeCiphers
+		// the ztls dials aren't timed
+		target.timings = dialTimings{}
+		conn, err = d.dialZTLS(ctx, network, hostPort, ztlsconfigCopy)
 		if err == nil {
-			if d.options.WithDialerHistory && d.dialerHistory != nil {
-				setErr := d.dialerHistory.Set(hostname, []byte(ip))
-				if setErr != nil {
-					return nil, setErr
-				}
-			}
-			if d.options.OnDialCallback != nil {
-				d.options.OnDialCallback(hostname, ip)
-			}
-			if d.options.WithTLSData && shouldUseTLS {
-				if connTLS, ok := conn.(*tls.Conn); ok {
-					var data bytes.Buffer
-					connState := connTLS.ConnectionState()
-					err := json.NewEncoder(&data).Encode(cryptoutil.TLSGrab(&connState))
-					if err != nil {
-						return nil, err
-					}
-					setErr := d.dialerTLSData.Set(hostname, data.Bytes())
-					if setErr != nil {
-						return nil, setErr
-					}
-				}
-			}
-			break
+			return conn, nil
 		}
+		err = errorutil.WrapfWithNil(err, "ztls fallback failed")
 	}
+	// failed tls dials may return a typed nil connection
+	return nil, err
+}
 
-	if conn == nil {
-		if numInvalidIPS == len(IPS) {
-			return nil, NoAddressAllowedError
-		}
-		return nil, CouldNotConnectError
+// rewriteAddress applies Options.AddressRewriter to the candidate ip and port, the rewritten
+// ip must be allowed by the network policy as well
+func (d *Dialer) rewriteAddress(hostname, ip, port string) (string, string, bool) {
+	if d.options.AddressRewriter == nil {
+		return ip, port, true
 	}
-
-	if err != nil {
-		return nil, err
+	newIP, newPort := d.options.AddressRewriter(hostname, ip, port)
+	if newIP == "" {
+		newIP = ip
+	}
+	if newPort == "" {
+		newPort = port
+	}
+	if newIP != ip && !d.policy().Validate(withoutZone(newIP)) {
+		return "", "", false
 	}
+	return newIP, newPort, true
+}
 
-	return
+// contextDialer returns the dialer bounded by the deadline of the context, for the ztls dials
+// covering both the connection and the handshake without taking a context
+func (d *Dialer) contextDialer(ctx context.Context) *net.Dialer {
+	deadline, ok := ctx.Deadline()
+	if !ok || !d.dialer.Deadline.IsZero() && d.dialer.Deadline.Before(deadline) {
+		return d.dialer
+	}
+	dialer := *d.dialer
+	dialer.Deadline = deadline
+	return &dialer
 }
 
-// Close instance and cleanups
-func (d *Dialer) Close() {
-	if d.hm != nil {
-		d.hm.Close()
+// contextErr returns the error of the context, including when its deadline passed but the
+// context isn't done yet as the ztls dials time out on their own timers
+func contextErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	if d.options.WithDialerHistory && d.dialerHistory != nil {
-		d.dialerHistory.Close()
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
 	}
-	if d.options.WithTLSData {
-		d.dialerTLSData.Close()
+	return nil
+}
+
+// allowZTLSFallback checks if the ztls fallback can be attempted. It's always skipped
+// with ech or connection verification as ztls implements neither, and with proxies
+// as the fallback connects directly
+func (d *Dialer) allowZTLSFallback() bool {
+	if d.options.DisableZtlsFallback && disableZTLSFallback || d.proxyDialer != nil {
+		return false
 	}
+	return len(d.options.ECHConfigList) == 0 && d.options.VerifyConnection == nil
+}
+
+// Close instance and cleanups
+func (d *Dialer) Close() {
+	d.closeOnce.Do(func() {
+		d.cancel()
+		// a provided cache is owned by the caller
+		if d.hm != nil && d.options.Cache == nil {
+			d.hm.Close()
+		}
+		if d.options.WithDialerHistory && d.dialerHistory != nil {
+			d.dialerHistory.Close()
+		}
+		if d.options.WithTLSData {
+			d.dialerTLSData.Close()
+		}
+		close(d.closed)
+	})
 }
 
 // GetDialedIP returns the ip dialed by the HTTP client
 func (d *Dialer) GetDialedIP(hostname string) string {
+	return d.lastDialedIP(asAscii(hostname))
+}
+
+// lastDialedIP returns the ip stored in the dialer history for the key
+func (d *Dialer) lastDialedIP(key string) string {
 	if !d.options.WithDialerHistory || d.dialerHistory == nil {
 		return ""
 	}
-	hostname = asAscii(hostname)
-	v, ok := d.dialerHistory.Get(hostname)
+	v, ok := d.dialerHistory.Get(key)
 	if ok {
 		return string(v)
 	}
@@ -427,67 +875,103 @@ func (d *Dialer) GetTLSData(hostname string) (*cryptoutil.TLSData, error) {
 	return &tlsData, nil
 }
 
-// GetDNSDataFromCache cached by the resolver
+// GetDNSDataFromCache cached by the resolver, in the Options.CacheNamespace namespace
 func (d *Dialer) GetDNSDataFromCache(hostname string) (*retryabledns.DNSData, error) {
 	hostname = asAscii(hostname)
-	var data retryabledns.DNSData
-	dataBytes, ok := d.hm.Get(hostname)
-	if !ok {
-		return nil, NoDNSDataError
+	return d.cachedDNSData(d.cacheKey(context.Background(), hostname))
+}
+
+// GetDNSData for the given hostname. A single resolution returns the A, AAAA and CNAME
+// records together and caches them as one entry, shared with the dials to the hostname
+func (d *Dialer) GetDNSData(hostname string) (*retryabledns.DNSData, error) {
+	return d.GetDNSDataContext(context.Background(), hostname)
+}
+
+// GetDNSDataContext is GetDNSData abandoning the resolution once the context is done,
+// the cache is bypassed for contexts returned by WithNoCache
+func (d *Dialer) GetDNSDataContext(ctx context.Context, hostname string) (*retryabledns.DNSData, error) {
+	data, err := d.getDNSData(ctx, hostname)
+	if err != nil || !d.options.FilterBlockedIPs {
+		return data, err
 	}
+	return d.filterBlockedIPs(data)
+}
 
-	err := data.Unmarshal(dataBytes)
-	return &data, err
+// getDNSData for the given hostname, the resolution is abandoned once the context is done
+func (d *Dialer) getDNSData(ctx context.Context, hostname string) (*retryabledns.DNSData, error) {
+	data, err := d.lookupDNSData(ctx, hostname)
+	if err != nil || !d.options.NoIPv6 || len(data.AAAA) == 0 {
+		return data, err
+	}
+	// the cached entry is kept complete
+	dataCopy := *data
+	dataCopy.AAAA = nil
+	return &dataCopy, nil
 }
 
-// GetDNSData for the given hostname
-func (d *Dialer) GetDNSData(hostname string) (*retryabledns.DNSData, error) {
+// lookupDNSData returns the literal ip, the cached data or a fresh resolution of the hostname
+func (d *Dialer) lookupDNSData(ctx context.Context, hostname string) (*retryabledns.DNSData, error) {
+	// ip literals are classified without looking up the cache
+	if data, ok := d.literalDNSData(hostname); ok {
+		return data, nil
+	}
 	hostname = asAscii(hostname)
-	// support http://[::1] http://[::1]:8080
-	// https://datatracker.ietf.org/doc/html/rfc2732
-	// It defines a syntax
-	// for IPv6 addresses and allows the use of "[" and "]" within a URI
-	// explicitly for this reserved purpose.
-	if strings.HasPrefix(hostname, "[") && strings.HasSuffix(hostname, "]") {
-		ipv6host := hostname[1:strings.LastIndex(hostname, "]")]
-		if ip := net.ParseIP(ipv6host); ip != nil {
-			if ip.To16() != nil {
-				return &retryabledns.DNSData{AAAA: []string{ip.To16().String()}}, nil
+	if err := d.validateDomain(hostname); err != nil {
+		return nil, err
+	}
+	// localhost names always resolve to loopback (rfc 6761)
+	if d.options.ResolveLocalhost && isLocalhost(hostname) {
+		return &retryabledns.DNSData{Host: hostname, A: []string{"127.0.0.1"}, AAAA: []string{"::1"}}, nil
+	}
+	info := dialInfoFromContext(ctx)
+	noCache := noCacheFromContext(ctx)
+	key := d.cacheKey(ctx, hostname)
+	var cached *retryabledns.DNSData
+	var err error
+	if !noCache {
+		cached, err = d.cachedDNSData(key)
+		if err != nil && key != hostname {
+			// the hosts file entries are loaded outside of any namespace
+			if global, globalErr := d.cachedDNSData(hostname); globalErr == nil && global.HostsFile {
+				cached, err = global, nil
 			}
 		}
 	}
-	if ip := net.ParseIP(hostname); ip != nil {
-		if ip.To4() != nil {
-			return &retryabledns.DNSData{A: []string{hostname}}, nil
-		}
-		if ip.To16() != nil {
-			return &retryabledns.DNSData{AAAA: []string{hostname}}, nil
+	if !noCache && err == nil && !d.shouldRefresh(cached) {
+		if info != nil {
+			info.FromCache = true
 		}
+		return cached, nil
 	}
-	var (
-		data *retryabledns.DNSData
-		err  error
-	)
-	data, err = d.GetDNSDataFromCache(hostname)
+	data, err := d.resolveWithContext(ctx, hostname)
 	if err != nil {
-		data, err = d.dnsclient.Resolve(hostname)
-		if err != nil && d.options.EnableFallback {
-			data, err = d.dnsclient.ResolveWithSyscall(hostname)
-		}
-		if err != nil {
-			return nil, err
-		}
-		if data == nil {
-			return nil, ResolveHostError
+		// the last known addresses are better than failing while the resolvers are unreachable
+		if cached != nil && d.options.ServeStaleOnError && ctx.Err() == nil {
+			if info != nil {
+				info.FromCache = true
+				info.Stale = true
+			}
+			return cached, nil
 		}
-		if len(data.A)+len(data.AAAA) > 0 {
-			b, _ := data.Marshal()
-			err = d.hm.Set(hostname, b)
+		return nil, newResolveError(hostname, data, ResolveHostError, err)
+	}
+	if data == nil {
+		return nil, newResolveError(hostname, nil, ResolveHostError, nil)
+	}
+	if d.options.OnDNSResolve != nil {
+		d.options.OnDNSResolve(hostname, len(data.A), len(data.AAAA))
+	}
+	if cached != nil && d.options.OnDNSChange != nil {
+		oldIPs, newIPs := addresses(cached), addresses(data)
+		if !sameAddresses(oldIPs, newIPs) {
+			d.options.OnDNSChange(hostname, oldIPs, newIPs)
 		}
-		if err != nil {
+	}
+	if len(data.A)+len(data.AAAA) > 0 && !noCache {
+		b, _ := data.Marshal()
+		if err := d.hm.Set(key, b); err != nil {
 			return nil, err
 		}
-		return data, nil
 	}
 	return data, nil
 }
@@ -516,6 +1000,19 @@ func getHMapConfiguration(options Options) hybrid.Options {
 	return cacheOptions
 }
 
+// storeOptions returns Options.HybridOptions for the dialer history and tls data stores, or the
+// defaults if unset. A custom path gets a subdirectory per store as their databases can't share one
+func storeOptions(options Options, defaults hybrid.Options, name string) hybrid.Options {
+	if options.HybridOptions == nil {
+		return defaults
+	}
+	hybridOptions := *options.HybridOptions
+	if hybridOptions.Path != "" {
+		hybridOptions.Path = filepath.Join(hybridOptions.Path, name)
+	}
+	return hybridOptions
+}
+
 func getHMAPDBType(options Options) hybrid.DBType {
 	switch options.DiskDbType {
 	case Pogreb:
@@ -2,7 +2,19 @@ package fastdialer
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/boss-net/fastdialer/fastdialer/ja3/impersonate"
+	retryabledns "github.com/boss-net/retryabledns"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
 )
 
 func TestDialer(t *testing.T) {
@@ -91,3 +103,554 @@ func testDialerIpv6(t *testing.T, options Options) {
 	// cleanup
 	fd.Close()
 }
+
+func TestDialWithIPs(t *testing.T) {
+	resolver := &mockResolver{}
+	options := DefaultOptions
+	options.Deny = []string{"10.0.0.1"}
+	fd := newTestDialer(t, options, resolver)
+
+	_, port, _ := net.SplitHostPort(newTestTCPServer(t))
+	conn, err := fd.DialWithIPs(context.Background(), "tcp", "example.com", []string{"10.0.0.1", "127.0.0.1"}, port, false)
+	require.Nil(t, err)
+	require.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String())
+	conn.Close()
+
+	serverNames := make(chan string, 1)
+	_, tlsPort, _ := net.SplitHostPort(newTestTLSServer(t, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverNames <- hello.ServerName
+			return nil, nil
+		},
+	}))
+	conn, err = fd.DialWithIPs(context.Background(), "tcp", "example.com", []string{"127.0.0.1"}, tlsPort, true)
+	require.Nil(t, err)
+	conn.Close()
+	require.Equal(t, "example.com", <-serverNames)
+
+	_, err = fd.DialWithIPs(context.Background(), "tcp", "example.com", []string{"10.0.0.1"}, port, false)
+	require.ErrorIs(t, err, NoAddressAllowedError)
+
+	require.Zero(t, atomic.LoadInt32(&resolver.resolveCalls))
+	require.Zero(t, atomic.LoadInt32(&resolver.syscallCalls))
+}
+
+func TestResolveLocalhost(t *testing.T) {
+	_, port, _ := net.SplitHostPort(newTestTCPServer(t))
+
+	t.Run("allowed", func(t *testing.T) {
+		resolver := &mockResolver{}
+		options := DefaultOptions
+		options.ResolveLocalhost = true
+		fd := newTestDialer(t, options, resolver)
+
+		data, err := fd.GetDNSData("app.localhost")
+		require.Nil(t, err)
+		require.Equal(t, []string{"127.0.0.1"}, data.A)
+		require.Equal(t, []string{"::1"}, data.AAAA)
+
+		conn, err := fd.Dial(context.Background(), "tcp", net.JoinHostPort("localhost", port))
+		require.Nil(t, err)
+		conn.Close()
+		require.Zero(t, atomic.LoadInt32(&resolver.resolveCalls))
+	})
+
+	t.Run("denied by policy", func(t *testing.T) {
+		resolver := &mockResolver{}
+		options := DefaultOptions
+		options.ResolveLocalhost = true
+		options.Deny = []string{"127.0.0.0/8", "::1/128"}
+		fd := newTestDialer(t, options, resolver)
+
+		_, err := fd.Dial(context.Background(), "tcp", net.JoinHostPort("localhost", port))
+		require.ErrorIs(t, err, NoAddressAllowedError)
+		require.Zero(t, atomic.LoadInt32(&resolver.resolveCalls))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		resolver := &mockResolver{}
+		fd := newTestDialer(t, DefaultOptions, resolver)
+
+		_, err := fd.Dial(context.Background(), "tcp", net.JoinHostPort("localhost", port))
+		require.NotNil(t, err)
+		require.Equal(t, int32(1), atomic.LoadInt32(&resolver.resolveCalls))
+	})
+}
+
+func TestDialAllBlocked(t *testing.T) {
+	options := DefaultOptions
+	options.Deny = []string{"10.0.0.0/8"}
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"10.0.0.1", "10.0.0.2"}, nil)})
+
+	_, err := fd.Dial(context.Background(), "tcp", "internal.example.com:80")
+	require.ErrorIs(t, err, ErrAllBlocked)
+	require.ErrorIs(t, err, NoAddressAllowedError)
+	var blockedErr *BlockedError
+	require.ErrorAs(t, err, &blockedErr)
+	require.Equal(t, "internal.example.com", blockedErr.Hostname)
+	require.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, blockedErr.IPs)
+
+	// no records is not reported as blocked
+	fd = newTestDialer(t, options, &mockResolver{})
+	_, err = fd.Dial(context.Background(), "tcp", "internal.example.com:80")
+	require.ErrorIs(t, err, NoAddressFoundError)
+	require.NotErrorIs(t, err, ErrAllBlocked)
+}
+
+func TestMaxDialDuration(t *testing.T) {
+	hung := make(chan struct{})
+	t.Cleanup(func() { close(hung) })
+	resolver := &mockResolver{resolve: func(host string) (*retryabledns.DNSData, error) {
+		<-hung
+		return nil, errors.New("resolver hung")
+	}}
+	options := DefaultOptions
+	options.MaxDialDuration = 2 * time.Second
+	fd := newTestDialer(t, options, resolver)
+
+	start := time.Now()
+	_, err := fd.Dial(context.Background(), "tcp", "hung.example.com:80")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	elapsed := time.Since(start)
+	require.GreaterOrEqual(t, elapsed, options.MaxDialDuration)
+	require.Less(t, elapsed, options.MaxDialDuration+time.Second)
+}
+
+// recordingProxy is a proxy dialer failing every connection and recording the attempted addresses
+type recordingProxy struct {
+	mu        sync.Mutex
+	addresses []string
+}
+
+func (p *recordingProxy) Dial(network, address string) (net.Conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.addresses = append(p.addresses, address)
+	return nil, errors.New("connection refused")
+}
+
+func TestDialDedupesIPs(t *testing.T) {
+	attempts := &recordingProxy{}
+	var tunnelDialer proxy.Dialer = attempts
+	options := DefaultOptions
+	options.ProxyDialer = &tunnelDialer
+	resolver := &mockResolver{resolve: staticAnswer([]string{"10.0.0.1", "10.0.0.2", "10.0.0.1"}, []string{"fd00::1", "fd00::1"})}
+	fd := newTestDialer(t, options, resolver)
+
+	_, err := fd.Dial(context.Background(), "tcp", "example.com:80")
+	require.ErrorIs(t, err, CouldNotConnectError)
+	require.Equal(t, []string{"10.0.0.1:80", "10.0.0.2:80", "[fd00::1]:80"}, attempts.addresses)
+}
+
+func TestDialNetworkFamily(t *testing.T) {
+	resolver := &mockResolver{resolve: staticAnswer([]string{"10.0.0.1", "10.0.0.2"}, []string{"fd00::1"})}
+	tests := []struct {
+		network string
+		want    []string
+	}{
+		{network: "tcp", want: []string{"10.0.0.1:80", "10.0.0.2:80", "[fd00::1]:80"}},
+		{network: "tcp4", want: []string{"10.0.0.1:80", "10.0.0.2:80"}},
+		{network: "tcp6", want: []string{"[fd00::1]:80"}},
+	}
+	for _, tt := range tests {
+		attempts := &recordingProxy{}
+		var tunnelDialer proxy.Dialer = attempts
+		options := DefaultOptions
+		options.ProxyDialer = &tunnelDialer
+		fd := newTestDialer(t, options, resolver)
+
+		_, err := fd.Dial(context.Background(), tt.network, "example.com:80")
+		require.ErrorIs(t, err, CouldNotConnectError, tt.network)
+		require.Equal(t, tt.want, attempts.addresses, tt.network)
+	}
+
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{resolve: staticAnswer(nil, []string{"fd00::1"})})
+	_, err := fd.Dial(context.Background(), "tcp4", "example.com:80")
+	require.ErrorIs(t, err, NoAddressFoundError)
+}
+
+func TestDialIPv4Mapped(t *testing.T) {
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{resolve: staticAnswer(nil, []string{"::ffff:10.0.0.1", "fd00::1"})})
+	for _, hostname := range []string{"::ffff:10.0.0.2", "[::ffff:10.0.0.2]"} {
+		data, err := fd.GetDNSData(hostname)
+		require.Nil(t, err, hostname)
+		require.Equal(t, []string{"10.0.0.2"}, data.A, hostname)
+		require.Empty(t, data.AAAA, hostname)
+	}
+
+	attempts := &recordingProxy{}
+	var tunnelDialer proxy.Dialer = attempts
+	options := DefaultOptions
+	options.ProxyDialer = &tunnelDialer
+	fd = newTestDialer(t, options, &mockResolver{resolve: staticAnswer(nil, []string{"::ffff:10.0.0.1", "fd00::1"})})
+	_, err := fd.Dial(context.Background(), "tcp4", "example.com:80")
+	require.ErrorIs(t, err, CouldNotConnectError)
+	_, err = fd.Dial(context.Background(), "tcp4", "[::ffff:10.0.0.2]:80")
+	require.ErrorIs(t, err, CouldNotConnectError)
+	require.Equal(t, []string{"10.0.0.1:80", "10.0.0.2:80"}, attempts.addresses)
+}
+
+func TestDialWithInfo(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+	address := net.JoinHostPort("example.com", port)
+
+	conn, info, err := fd.DialWithInfo(context.Background(), "tcp", address)
+	require.Nil(t, err)
+	conn.Close()
+	require.Positive(t, info.ConnectDuration)
+	require.Zero(t, info.HandshakeDuration)
+	require.Equal(t, conn.LocalAddr(), info.LocalAddr)
+	info.ConnectDuration, info.LocalAddr = 0, nil
+	require.Equal(t, &DialInfo{Hostname: "example.com", IP: "127.0.0.1", FromCache: false, ARecords: 1}, info)
+
+	conn, info, err = fd.DialWithInfo(context.Background(), "tcp", address)
+	require.Nil(t, err)
+	conn.Close()
+	require.True(t, info.FromCache)
+}
+
+func TestDialResolveFailure(t *testing.T) {
+	resolver := &mockResolver{resolve: failingAnswer}
+	fd := newTestDialer(t, DefaultOptions, resolver)
+
+	// the failed resolution is returned without resolving the hostname again
+	_, err := fd.Dial(context.Background(), "tcp", "example.com:80")
+	require.ErrorIs(t, err, ResolveHostError)
+	var resolveErr *ResolveError
+	require.ErrorAs(t, err, &resolveErr)
+	require.Equal(t, "example.com", resolveErr.Hostname)
+	require.Equal(t, int32(1), atomic.LoadInt32(&resolver.resolveCalls))
+}
+
+func TestDNSRecordCounts(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	type counts struct{ a, aaaa int }
+	var resolved []counts
+	options := DefaultOptions
+	options.OnDNSResolve = func(hostname string, a, aaaa int) {
+		require.Equal(t, "example.com", hostname)
+		resolved = append(resolved, counts{a, aaaa})
+	}
+	answer := staticAnswer([]string{"127.0.0.1", "127.0.0.2"}, []string{"fd00::1", "fd00::2", "fd00::3"})
+	fd := newTestDialer(t, options, &mockResolver{resolve: answer})
+
+	for i := 0; i < 2; i++ {
+		conn, info, err := fd.DialWithInfo(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+		require.Nil(t, err)
+		conn.Close()
+		require.Equal(t, 2, info.ARecords)
+		require.Equal(t, 3, info.AAAARecords)
+	}
+	// the cached dns data of the second dial isn't reported
+	require.Equal(t, []counts{{2, 3}}, resolved)
+}
+
+func TestNoIPv6(t *testing.T) {
+	attempts := &recordingProxy{}
+	var tunnelDialer proxy.Dialer = attempts
+	options := DefaultOptions
+	options.NoIPv6 = true
+	options.ProxyDialer = &tunnelDialer
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"10.0.0.1"}, []string{"fd00::1"})})
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, data.A)
+	require.Empty(t, data.AAAA)
+
+	_, err = fd.Dial(context.Background(), "tcp", "example.com:80")
+	require.NotNil(t, err)
+	_, err = fd.Dial(context.Background(), "tcp", "[fd00::2]:80")
+	require.NotNil(t, err)
+	require.Equal(t, []string{"10.0.0.1:80"}, attempts.addresses)
+}
+
+func TestWithForcedIP(t *testing.T) {
+	attempts := &recordingProxy{}
+	var tunnelDialer proxy.Dialer = attempts
+	options := DefaultOptions
+	options.ProxyDialer = &tunnelDialer
+	options.Deny = []string{"10.0.0.3"}
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, nil)})
+
+	_, err := fd.Dial(WithForcedIP(context.Background(), "10.0.0.2"), "tcp", "example.com:80")
+	require.ErrorIs(t, err, CouldNotConnectError)
+	require.Equal(t, []string{"10.0.0.2:80"}, attempts.addresses)
+
+	_, err = fd.Dial(WithForcedIP(context.Background(), "10.0.0.4"), "tcp", "example.com:80")
+	require.ErrorIs(t, err, ForcedIPNotResolvedError)
+	_, err = fd.Dial(WithForcedIP(context.Background(), "10.0.0.3"), "tcp", "example.com:80")
+	require.ErrorIs(t, err, ErrAllBlocked)
+	require.Len(t, attempts.addresses, 1)
+}
+
+func TestDialInfoMetadata(t *testing.T) {
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{})
+
+	conn, info, err := fd.DialWithInfo(context.Background(), "tcp", newTestTCPServer(t))
+	require.Nil(t, err)
+	conn.Close()
+	require.Equal(t, "ip4", info.Family())
+	require.False(t, info.IsTLS())
+
+	conn, info, err = fd.DialTLSWithInfo(context.Background(), "tcp", newTestTLSServer(t, &tls.Config{}))
+	require.Nil(t, err)
+	conn.Close()
+	require.Equal(t, "ip4", info.Family())
+	require.True(t, info.IsTLS())
+
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("ipv6 loopback not available")
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, info, err = fd.DialWithInfo(context.Background(), "tcp", listener.Addr().String())
+	require.Nil(t, err)
+	conn.Close()
+	require.Equal(t, "ip6", info.Family())
+	require.Equal(t, "::1", info.IP)
+}
+
+func TestDialAny(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	answers := map[string]string{"dead.example.com": "127.0.0.2", "mirror.example.com": "127.0.0.1"}
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{resolve: func(host string) (*retryabledns.DNSData, error) {
+		return &retryabledns.DNSData{Host: host, A: []string{answers[host]}}, nil
+	}})
+
+	conn, host, err := fd.DialAny(context.Background(), "tcp", []string{"dead.example.com", "mirror.example.com"}, port)
+	require.Nil(t, err)
+	conn.Close()
+	require.Equal(t, "mirror.example.com", host)
+
+	_, _, err = fd.DialAny(context.Background(), "tcp", []string{"dead.example.com"}, port)
+	require.ErrorIs(t, err, CouldNotConnectError)
+}
+
+func TestDialScopedIPv6(t *testing.T) {
+	attempts := &recordingProxy{}
+	var tunnelDialer proxy.Dialer = attempts
+	options := DefaultOptions
+	options.ProxyDialer = &tunnelDialer
+	options.Allow = []string{"fe80::/10"}
+	resolver := &mockResolver{}
+	fd := newTestDialer(t, options, resolver)
+
+	data, err := fd.GetDNSData("fe80::1%eth0")
+	require.Nil(t, err)
+	require.Equal(t, []string{"fe80::1%eth0"}, data.AAAA)
+
+	_, err = fd.Dial(context.Background(), "tcp", "[fe80::1%eth0]:80")
+	require.ErrorIs(t, err, CouldNotConnectError)
+	require.Equal(t, []string{"[fe80::1%eth0]:80"}, attempts.addresses)
+	require.Zero(t, atomic.LoadInt32(&resolver.resolveCalls))
+}
+
+func TestDialUDP(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { server.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := server.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = server.WriteTo(buf[:n], addr)
+		}
+	}()
+	_, port, err := net.SplitHostPort(server.LocalAddr().String())
+	require.Nil(t, err)
+
+	options := DefaultOptions
+	options.Deny = []string{"10.0.0.1"}
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"10.0.0.1", "127.0.0.1"}, nil)})
+	conn, err := fd.DialUDP(context.Background(), "udp", net.JoinHostPort("example.com", port))
+	require.Nil(t, err)
+	defer conn.Close()
+	require.Equal(t, server.LocalAddr().String(), conn.RemoteAddr().String())
+
+	_, err = conn.Write([]byte("ping"))
+	require.Nil(t, err)
+	require.Nil(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.Nil(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	_, err = fd.DialUDP(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+	require.NotNil(t, err)
+}
+
+func TestNewDialerWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	options := DefaultOptions
+	options.HostsFile = false
+	options.ResolversFile = false
+	options.CacheType = Memory
+	options.WithDialerHistory = true
+	fd, err := NewDialerWithContext(ctx, options)
+	require.Nil(t, err)
+
+	cancel()
+	select {
+	case <-fd.closed:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "dialer not closed after the context was canceled")
+	}
+	require.ErrorIs(t, fd.ctx.Err(), context.Canceled)
+	// closing again is a no-op
+	fd.Close()
+}
+
+func TestDialNetwork(t *testing.T) {
+	address := newTestTCPServer(t)
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{})
+
+	// an empty network defaults to tcp
+	conn, err := fd.Dial(context.Background(), "", address)
+	require.Nil(t, err)
+	require.Equal(t, "tcp", conn.RemoteAddr().Network())
+	conn.Close()
+
+	_, err = fd.Dial(context.Background(), "tcp7", address)
+	require.ErrorIs(t, err, UnsupportedNetworkError)
+	require.Contains(t, err.Error(), "tcp7")
+	_, err = fd.DialTLS(context.Background(), "udp", address)
+	require.ErrorIs(t, err, UnsupportedNetworkError)
+}
+
+// newStalledServer accepts connections and never answers them
+func newStalledServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestContextDeadline(t *testing.T) {
+	_, port, err := net.SplitHostPort(newStalledServer(t))
+	require.Nil(t, err)
+	address := net.JoinHostPort("example.com", port)
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+	slowResolver := &mockResolver{resolve: func(host string) (*retryabledns.DNSData, error) {
+		time.Sleep(3 * time.Second)
+		return &retryabledns.DNSData{Host: host, A: []string{"127.0.0.1"}}, nil
+	}}
+	slowFd := newTestDialer(t, DefaultOptions, slowResolver)
+
+	tests := map[string]func(ctx context.Context) (net.Conn, error){
+		"tls": func(ctx context.Context) (net.Conn, error) {
+			return fd.DialTLS(ctx, "tcp", address)
+		},
+		"impersonate": func(ctx context.Context) (net.Conn, error) {
+			return fd.DialTLSWithConfigImpersonate(ctx, "tcp", address, &tls.Config{InsecureSkipVerify: true}, impersonate.Chrome, nil)
+		},
+		"ztls": func(ctx context.Context) (net.Conn, error) {
+			return fd.DialZTLS(ctx, "tcp", address)
+		},
+		"resolution": func(ctx context.Context) (net.Conn, error) {
+			return slowFd.Dial(ctx, "tcp", address)
+		},
+	}
+	for name, dial := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		start := time.Now()
+		conn, err := dial(ctx)
+		cancel()
+		require.Nil(t, conn, name)
+		require.ErrorIs(t, err, context.DeadlineExceeded, name)
+		require.Less(t, time.Since(start), 1500*time.Millisecond, name)
+	}
+}
+
+func TestAddressRewriter(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	var rewritten []string
+	options := DefaultOptions
+	options.Deny = []string{"127.0.0.2"}
+	options.AddressRewriter = func(hostname, ip, oldPort string) (string, string) {
+		rewritten = append(rewritten, net.JoinHostPort(ip, oldPort))
+		if hostname == "blocked.example.com" {
+			return "127.0.0.2", ""
+		}
+		return "", port
+	}
+	resolver := &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)}
+
+	for _, delay := range []time.Duration{0, 50 * time.Millisecond} {
+		rewritten = nil
+		options.HappyEyeballsDelay = delay
+		fd := newTestDialer(t, options, resolver)
+
+		conn, info, err := fd.DialWithInfo(context.Background(), "tcp", "example.com:1")
+		require.Nil(t, err, delay)
+		require.Equal(t, net.JoinHostPort("127.0.0.1", port), conn.RemoteAddr().String(), delay)
+		conn.Close()
+		require.Equal(t, "127.0.0.1", info.IP, delay)
+		require.Equal(t, []string{"127.0.0.1:1"}, rewritten, delay)
+
+		// the rewritten ip is checked against the network policy
+		_, err = fd.Dial(context.Background(), "tcp", "blocked.example.com:80")
+		require.ErrorIs(t, err, ErrAllBlocked, delay)
+	}
+}
+
+func TestResolveOnly(t *testing.T) {
+	address := newTestTCPServer(t)
+	resolver := &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)}
+	options := DefaultOptions
+	options.ResolveOnly = true
+	fd := newTestDialer(t, options, resolver)
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1"}, data.A)
+
+	dials := map[string]func() (net.Conn, error){
+		"dial":     func() (net.Conn, error) { return fd.Dial(context.Background(), "tcp", address) },
+		"tls":      func() (net.Conn, error) { return fd.DialTLS(context.Background(), "tcp", address) },
+		"ztls":     func() (net.Conn, error) { return fd.DialZTLS(context.Background(), "tcp", address) },
+		"udp":      func() (net.Conn, error) { return fd.DialUDP(context.Background(), "udp", address) },
+		"hostname": func() (net.Conn, error) { return fd.Dial(context.Background(), "tcp", "example.org:80") },
+		"ips": func() (net.Conn, error) {
+			host, port, _ := net.SplitHostPort(address)
+			return fd.DialWithIPs(context.Background(), "tcp", "example.com", []string{host}, port, false)
+		},
+		"any": func() (net.Conn, error) {
+			conn, _, err := fd.DialAny(context.Background(), "tcp", []string{"example.com"}, "80")
+			return conn, err
+		},
+	}
+	for name, dial := range dials {
+		conn, err := dial()
+		require.Nil(t, conn, name)
+		require.ErrorIs(t, err, ErrDialingDisabled, name)
+	}
+	// the dials fail before resolving
+	require.Equal(t, int32(1), atomic.LoadInt32(&resolver.resolveCalls))
+}
@@ -0,0 +1,104 @@
+package fastdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	iputil "github.com/boss-net/goutils/ip"
+	utls "github.com/refraction-networking/utls"
+	ztls "github.com/zmap/zcrypto/tls"
+)
+
+// DialInfo contains details about how a connection was established. SANs are the sorted
+// dns and ip names of the peer certificate of tls connections
+type DialInfo struct {
+	Hostname  string
+	IP        string   // ip the connection was established to, empty when ProxyResolved
+	LocalAddr net.Addr // local address of the socket, the one connected to the proxy when proxied
+	FromCache bool     // the dns data was served from the cache instead of a fresh resolution
+	Stale     bool     // the expired cached dns data was used as the resolution failed
+	SANs      []string
+	tls       bool
+
+	ProxyResolved bool // the hostname was sent to the proxy to resolve, see Options.ProxyDNS
+
+	// A and AAAA records of the dns data of the hostname, those removed by NoIPv6 aren't counted
+	ARecords    int
+	AAAARecords int
+
+	// durations of the tcp connect, through the proxy if any, and of the tls handshake.
+	// Both are zero for ztls connections whose steps aren't timed separately
+	ConnectDuration   time.Duration
+	HandshakeDuration time.Duration
+}
+
+// dialTimings are the durations of the steps of a dial attempt
+type dialTimings struct {
+	connect   time.Duration
+	handshake time.Duration
+}
+
+// record sets the timings of the established connection in the dial info of the context
+func (t dialTimings) record(ctx context.Context) {
+	if info := dialInfoFromContext(ctx); info != nil {
+		info.ConnectDuration, info.HandshakeDuration = t.connect, t.handshake
+	}
+}
+
+// Family returns the address family of the dialed ip, "ip4" or "ip6"
+func (i *DialInfo) Family() string {
+	if iputil.IsIPv6(withoutZone(i.IP)) {
+		return "ip6"
+	}
+	return "ip4"
+}
+
+// IsTLS checks if the connection is wrapped by tls, ztls or utls
+func (i *DialInfo) IsTLS() bool {
+	return i.tls
+}
+
+// isTLSConn checks if the connection is one of the supported tls implementations
+func isTLSConn(conn net.Conn) bool {
+	switch conn.(type) {
+	case *tls.Conn, *ztls.Conn, *utls.UConn:
+		return true
+	default:
+		return false
+	}
+}
+
+type dialInfoKey struct{}
+
+// withDialInfo returns a context collecting the dial details into info
+func withDialInfo(ctx context.Context, info *DialInfo) context.Context {
+	return context.WithValue(ctx, dialInfoKey{}, info)
+}
+
+// dialInfoFromContext returns the dial details collected by the context, if any
+func dialInfoFromContext(ctx context.Context) *DialInfo {
+	info, _ := ctx.Value(dialInfoKey{}).(*DialInfo)
+	return info
+}
+
+// DialWithInfo dials the address like Dial and returns the details of the dial
+func (d *Dialer) DialWithInfo(ctx context.Context, network, address string) (net.Conn, *DialInfo, error) {
+	info := &DialInfo{}
+	conn, err := d.Dial(withDialInfo(ctx, info), network, address)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, info, nil
+}
+
+// DialTLSWithInfo dials the address like DialTLS and returns the details of the dial
+func (d *Dialer) DialTLSWithInfo(ctx context.Context, network, address string) (net.Conn, *DialInfo, error) {
+	info := &DialInfo{}
+	conn, err := d.DialTLS(withDialInfo(ctx, info), network, address)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, info, nil
+}
@@ -0,0 +1,70 @@
+package fastdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	retryabledns "github.com/boss-net/retryabledns"
+	"github.com/boss-net/retryabledns/doh"
+	"github.com/miekg/dns"
+)
+
+// dohResolvers sends the queries over https (rfc 8484) to the resolvers in turn
+type dohResolvers struct {
+	resolvers  []dohResolver
+	maxRetries int
+	next       atomic.Uint32
+}
+
+// dohResolver is the url of a dns over https resolver and the client connecting to it
+type dohResolver struct {
+	url    string
+	method doh.Method
+	client *doh.Client
+}
+
+// newPinnedDoHClient returns a dns over https client connecting to the address whatever the
+// host of the url. The host is still sent as server name and Host header and the certificates
+// are verified against it with the roots, the system ones if nil
+func newPinnedDoHClient(address string, roots *x509.CertPool) *doh.Client {
+	dialer := &net.Dialer{Timeout: doh.DefaultTimeout}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		},
+		TLSClientConfig:   &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+		ForceAttemptHTTP2: true,
+	}
+	return doh.NewWithOptions(doh.Options{HttpClient: &http.Client{Timeout: doh.DefaultTimeout, Transport: transport}})
+}
+
+// Resolve queries the A and AAAA records of the host, the error of a failed query is
+// returned along with the records of the other one
+func (p *dohResolvers) Resolve(host string) (*retryabledns.DNSData, error) {
+	return resolveAddresses(host, func(msg *dns.Msg) (*dns.Msg, string, error) {
+		resp, resolver, err := p.exchange(msg)
+		return resp, "doh:" + resolver, err
+	})
+}
+
+func (p *dohResolvers) Do(msg *dns.Msg) (*dns.Msg, error) {
+	resp, _, err := p.exchange(msg)
+	return resp, err
+}
+
+// exchange sends the message to the next resolvers until one answers, at most maxRetries times
+func (p *dohResolvers) exchange(msg *dns.Msg) (*dns.Msg, string, error) {
+	var err error
+	for attempt := 0; attempt < p.maxRetries || attempt == 0; attempt++ {
+		resolver := p.resolvers[int(p.next.Add(1)-1)%len(p.resolvers)]
+		var resp *dns.Msg
+		if resp, err = resolver.client.QueryWithDOHMsg(resolver.method, doh.Resolver{URL: resolver.url}, msg); err == nil {
+			return resp, resolver.url, nil
+		}
+	}
+	return nil, "", err
+}
@@ -0,0 +1,76 @@
+package fastdialer
+
+import (
+	"fmt"
+	"strings"
+
+	iputil "github.com/boss-net/goutils/ip"
+)
+
+// validateDomain checks the hostname is well formed then matches it against the domain allow and deny
+// lists, ip addresses are not matched. Entries starting with "*." match any subdomain, the other ones
+// only the exact domain
+func (d *Dialer) validateDomain(hostname string) error {
+	if iputil.IsIP(withoutZone(strings.Trim(hostname, "[]"))) {
+		return nil
+	}
+	if !isValidHostname(hostname) {
+		return fmt.Errorf("%w: %q", ErrInvalidHostname, hostname)
+	}
+	if len(d.options.DenyDomains)+len(d.options.AllowDomains) == 0 {
+		return nil
+	}
+	domain := strings.ToLower(strings.TrimSuffix(hostname, "."))
+	if matchDomains(domain, d.options.DenyDomains) {
+		return fmt.Errorf("%w: %s", ErrBlockedDomain, hostname)
+	}
+	if len(d.options.AllowDomains) > 0 && !matchDomains(domain, d.options.AllowDomains) {
+		return fmt.Errorf("%w: %s", ErrBlockedDomain, hostname)
+	}
+	return nil
+}
+
+// matchDomains checks if the lowercase domain matches any of the patterns
+func matchDomains(domain string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasSuffix(domain, suffix) {
+				return true
+			}
+			continue
+		}
+		if domain == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	maxHostnameLength = 253
+	maxLabelLength    = 63
+)
+
+// isValidHostname checks the ascii hostname against the length limits of rfc 1035, 253 characters
+// and 63 per label, and the characters allowed by rfc 1123. Underscores are accepted as they're
+// common in service names, a trailing dot is allowed
+func isValidHostname(hostname string) bool {
+	hostname = strings.TrimSuffix(hostname, ".")
+	if hostname == "" || len(hostname) > maxHostnameLength {
+		return false
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if label == "" || len(label) > maxLabelLength || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			switch c := label[i]; {
+			case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
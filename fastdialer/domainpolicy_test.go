@@ -0,0 +1,97 @@
+package fastdialer
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDenyDomains(t *testing.T) {
+	resolver := &mockResolver{resolve: staticAnswer([]string{"10.0.0.1"}, nil)}
+	options := DefaultOptions
+	options.DenyDomains = []string{"*.internal.corp", "metadata.example.com"}
+	fd := newTestDialer(t, options, resolver)
+
+	for _, hostname := range []string{"db.internal.corp", "a.b.internal.corp", "DB.Internal.Corp.", "metadata.example.com"} {
+		_, err := fd.GetDNSData(hostname)
+		require.ErrorIs(t, err, ErrBlockedDomain, hostname)
+		_, err = fd.Dial(context.Background(), "tcp", hostname+":80")
+		require.ErrorIs(t, err, ErrBlockedDomain, hostname)
+		_, err = fd.DialWithIPs(context.Background(), "tcp", hostname, []string{"127.0.0.1"}, "80", true)
+		require.ErrorIs(t, err, ErrBlockedDomain, hostname)
+	}
+	// no lookup is leaked for blocked domains
+	require.Zero(t, atomic.LoadInt32(&resolver.resolveCalls))
+
+	for _, hostname := range []string{"internal.corp", "notinternal.corp", "sub.metadata.example.com", "10.0.0.1"} {
+		_, err := fd.GetDNSData(hostname)
+		require.Nil(t, err, hostname)
+	}
+}
+
+func TestAllowDomains(t *testing.T) {
+	options := DefaultOptions
+	options.AllowDomains = []string{"*.example.com", "example.org"}
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"10.0.0.1"}, nil)})
+
+	for _, hostname := range []string{"www.example.com", "example.org"} {
+		_, err := fd.GetDNSData(hostname)
+		require.Nil(t, err, hostname)
+	}
+	for _, hostname := range []string{"example.com", "www.example.org", "example.net"} {
+		_, err := fd.GetDNSData(hostname)
+		require.ErrorIs(t, err, ErrBlockedDomain, hostname)
+		_, err = fd.DialWithIPs(context.Background(), "tcp", hostname, []string{"127.0.0.1"}, "80", false)
+		require.ErrorIs(t, err, ErrBlockedDomain, hostname)
+	}
+}
+
+func TestIsValidHostname(t *testing.T) {
+	longLabel := strings.Repeat("a", 63)
+	tests := []struct {
+		hostname string
+		valid    bool
+	}{
+		{hostname: "example.com", valid: true},
+		{hostname: "example.com.", valid: true},
+		{hostname: "localhost", valid: true},
+		{hostname: "_dmarc.example.com", valid: true},
+		{hostname: "xn--bcher-kva.example", valid: true},
+		{hostname: "a-b.example.com", valid: true},
+		{hostname: longLabel + ".example.com", valid: true},
+		{hostname: strings.Repeat(longLabel+".", 3) + strings.Repeat("a", 61), valid: true},
+		{hostname: ""},
+		{hostname: "."},
+		{hostname: "a..example.com"},
+		{hostname: ".example.com"},
+		{hostname: longLabel + "a.example.com"},
+		{hostname: strings.Repeat(longLabel+".", 3) + strings.Repeat("a", 62)},
+		{hostname: "-example.com"},
+		{hostname: "example-.com"},
+		{hostname: "exa mple.com"},
+		{hostname: "example.com/path"},
+		{hostname: "*.example.com"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.valid, isValidHostname(tt.hostname), tt.hostname)
+	}
+}
+
+func TestInvalidHostname(t *testing.T) {
+	resolver := &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)}
+	fd := newTestDialer(t, DefaultOptions, resolver)
+	cache := &countingCache{Cache: fd.hm}
+	fd.hm = cache
+
+	hostname := strings.Repeat("a", 64) + ".example.com"
+	_, err := fd.GetDNSData(hostname)
+	require.ErrorIs(t, err, ErrInvalidHostname)
+	_, err = fd.Dial(context.Background(), "tcp", net.JoinHostPort(hostname, "80"))
+	require.ErrorIs(t, err, ErrInvalidHostname)
+	require.Zero(t, atomic.LoadInt32(&resolver.resolveCalls))
+	require.Zero(t, atomic.LoadInt32(&cache.gets))
+}
@@ -0,0 +1,138 @@
+package fastdialer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	retryabledns "github.com/boss-net/retryabledns"
+	"github.com/miekg/dns"
+)
+
+const (
+	dotScheme      = "tls://"
+	dotDefaultPort = "853"
+)
+
+// dotResolvers sends the queries over tls (rfc 7858) to the resolvers in turn
+type dotResolvers struct {
+	resolvers  []dotResolver
+	maxRetries int
+	next       atomic.Uint32
+}
+
+// dotResolver is the host:port address of a dns over tls resolver and the client connecting to it
+type dotResolver struct {
+	address string
+	client  *dns.Client
+}
+
+// newDoTResolvers parses the tls://host[:port] resolvers. Their certificates are verified
+// against the server name, or not at all if it's empty
+func newDoTResolvers(resolvers []string, serverName string, maxRetries int) (*dotResolvers, error) {
+	pool := &dotResolvers{maxRetries: maxRetries}
+	client := newDoTClient(serverName, nil)
+	for _, resolver := range resolvers {
+		address, ok := strings.CutPrefix(resolver, dotScheme)
+		if !ok || address == "" {
+			return nil, fmt.Errorf("%w: %s", InvalidDoTResolverError, resolver)
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(strings.Trim(address, "[]"), dotDefaultPort)
+		}
+		pool.resolvers = append(pool.resolvers, dotResolver{address: address, client: client})
+	}
+	return pool, nil
+}
+
+// newDoTClient returns a dns over tls client verifying the certificates against the server
+// name with the roots, the system ones if nil. Nothing is verified if the name is empty
+func newDoTClient(serverName string, roots *x509.CertPool) *dns.Client {
+	return &dns.Client{
+		Net: "tcp-tls",
+		TLSConfig: &tls.Config{
+			ServerName:         serverName,
+			RootCAs:            roots,
+			InsecureSkipVerify: serverName == "",
+			MinVersion:         tls.VersionTLS12,
+		},
+	}
+}
+
+// Resolve queries the A and AAAA records of the host, the error of a failed query is
+// returned along with the records of the other one
+func (p *dotResolvers) Resolve(host string) (*retryabledns.DNSData, error) {
+	return resolveAddresses(host, func(msg *dns.Msg) (*dns.Msg, string, error) {
+		resp, resolver, err := p.exchange(msg)
+		return resp, dotScheme + resolver, err
+	})
+}
+
+func (p *dotResolvers) Do(msg *dns.Msg) (*dns.Msg, error) {
+	resp, _, err := p.exchange(msg)
+	return resp, err
+}
+
+// exchange sends the message to the next resolvers until one answers, at most maxRetries times
+func (p *dotResolvers) exchange(msg *dns.Msg) (*dns.Msg, string, error) {
+	var err error
+	for attempt := 0; attempt < p.maxRetries || attempt == 0; attempt++ {
+		resolver := p.resolvers[int(p.next.Add(1)-1)%len(p.resolvers)]
+		var resp *dns.Msg
+		if resp, _, err = resolver.client.Exchange(msg, resolver.address); err == nil {
+			return resp, resolver.address, nil
+		}
+	}
+	return nil, "", err
+}
+
+// resolveAddresses sends the A and AAAA queries of the host through exchange, which returns
+// the answer along with the resolver it came from. The error of a failed query is returned
+// along with the records of the other one
+func resolveAddresses(host string, exchange func(*dns.Msg) (*dns.Msg, string, error)) (*retryabledns.DNSData, error) {
+	data := &retryabledns.DNSData{Host: host}
+	var err error
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg := &dns.Msg{}
+		msg.SetQuestion(dns.Fqdn(host), qtype)
+		resp, resolver, queryErr := exchange(msg)
+		if queryErr != nil {
+			err = queryErr
+			continue
+		}
+		data.Resolver = append(data.Resolver, resolver)
+		data.StatusCode = dns.RcodeToString[resp.Rcode]
+		data.StatusCodeRaw = resp.Rcode
+		if parseErr := data.ParseFromMsg(resp); parseErr != nil {
+			err = parseErr
+		}
+	}
+	data.Timestamp = time.Now()
+	return data, err
+}
+
+// chainedResolvers sends each query to the resolvers in order, the next one being tried only
+// if the previous failed
+type chainedResolvers []dnsResolver
+
+func (c chainedResolvers) Resolve(host string) (data *retryabledns.DNSData, err error) {
+	for _, resolver := range c {
+		if data, err = resolver.Resolve(host); err == nil {
+			return data, nil
+		}
+	}
+	return data, err
+}
+
+func (c chainedResolvers) Do(msg *dns.Msg) (resp *dns.Msg, err error) {
+	for _, resolver := range c {
+		if resp, err = resolver.Do(msg); err == nil {
+			return resp, nil
+		}
+	}
+	return resp, err
+}
@@ -0,0 +1,76 @@
+package fastdialer
+
+import (
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDoTServer starts a dns over tls server and returns its address
+func newTestDoTServer(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{newTestCertificate(t, "dns.example.com")}})
+	require.Nil(t, err)
+	server := &dns.Server{Listener: listener, Net: "tcp-tls", Handler: handler}
+	go server.ActivateAndServe() //nolint:errcheck
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return listener.Addr().String()
+}
+
+func newDoTTestDialer(t *testing.T, options Options) *Dialer {
+	t.Helper()
+	options.HostsFile = false
+	options.ResolversFile = false
+	options.CacheType = Memory
+	fd, err := NewDialer(options)
+	require.Nil(t, err)
+	t.Cleanup(fd.Close)
+	return fd
+}
+
+func TestDoTResolvers(t *testing.T) {
+	var calls int32
+	address := newTestDoTServer(t, answerA(1, false, &calls))
+	options := DefaultOptions
+	options.BaseResolvers = nil
+	options.DoTResolvers = []string{"tls://" + address}
+	fd := newDoTTestDialer(t, options)
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, data.A)
+	require.Equal(t, []string{"tls://" + address}, data.Resolver[:1])
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// the certificate is verified against the server name, the test one isn't trusted
+	options.DoTServerName = "dns.example.com"
+	fd = newDoTTestDialer(t, options)
+	_, err = fd.GetDNSData("example.com")
+	require.NotNil(t, err)
+
+	_, err = NewDialer(Options{DoTResolvers: []string{"1.1.1.1:853"}})
+	require.ErrorIs(t, err, InvalidDoTResolverError)
+}
+
+func TestDoTPlainFallback(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	closed := listener.Addr().String()
+	listener.Close()
+
+	var calls int32
+	options := DefaultOptions
+	options.BaseResolvers = []string{newTestDNSServer(t, answerA(1, false, &calls), answerA(1, false, &calls))}
+	options.DoTResolvers = []string{"tls://" + closed}
+	options.MaxRetries = 1
+	fd := newDoTTestDialer(t, options)
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, data.A)
+	require.NotZero(t, atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,15 @@
+//go:build go1.23
+
+package fastdialer
+
+import "crypto/tls"
+
+// applyECHConfig enables encrypted client hello on the tls config.
+// ECH is only negotiated with tls 1.3 so lower versions are disabled
+func applyECHConfig(config *tls.Config, echConfigList []byte) error {
+	config.EncryptedClientHelloConfigList = echConfigList
+	if config.MinVersion < tls.VersionTLS13 {
+		config.MinVersion = tls.VersionTLS13
+	}
+	return nil
+}
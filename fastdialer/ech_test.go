@@ -0,0 +1,128 @@
+//go:build go1.24
+
+package fastdialer
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestECHConfig returns a single ECHConfig (x25519, hkdf-sha256, aes-128-gcm) and its private key
+func newTestECHConfig(t *testing.T, publicName string) ([]byte, []byte) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.Nil(t, err)
+
+	var contents bytes.Buffer
+	contents.WriteByte(1)                                         // config_id
+	_ = binary.Write(&contents, binary.BigEndian, uint16(0x0020)) // kem x25519
+	publicKey := key.PublicKey().Bytes()
+	_ = binary.Write(&contents, binary.BigEndian, uint16(len(publicKey)))
+	contents.Write(publicKey)
+	_ = binary.Write(&contents, binary.BigEndian, uint16(4))      // cipher suites length
+	_ = binary.Write(&contents, binary.BigEndian, uint16(0x0001)) // hkdf-sha256
+	_ = binary.Write(&contents, binary.BigEndian, uint16(0x0001)) // aes-128-gcm
+	contents.WriteByte(0)                                         // maximum_name_length
+	contents.WriteByte(byte(len(publicName)))
+	contents.WriteString(publicName)
+	_ = binary.Write(&contents, binary.BigEndian, uint16(0)) // extensions
+
+	var config bytes.Buffer
+	_ = binary.Write(&config, binary.BigEndian, uint16(0xfe0d))
+	_ = binary.Write(&config, binary.BigEndian, uint16(contents.Len()))
+	config.Write(contents.Bytes())
+	return config.Bytes(), key.Bytes()
+}
+
+// newRecordingRelay forwards connections to target recording the bytes sent by the client
+func newRecordingRelay(t *testing.T, target string) (string, *bytes.Buffer, *sync.Mutex) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	var (
+		recorded bytes.Buffer
+		mu       sync.Mutex
+	)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go func() {
+				defer upstream.Close()
+				buf := make([]byte, 4096)
+				for {
+					n, err := conn.Read(buf)
+					if n > 0 {
+						mu.Lock()
+						recorded.Write(buf[:n])
+						mu.Unlock()
+						_, _ = upstream.Write(buf[:n])
+					}
+					if err != nil {
+						return
+					}
+				}
+			}()
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return listener.Addr().String(), &recorded, &mu
+}
+
+func TestDialTLSWithECH(t *testing.T) {
+	const (
+		innerName  = "secret.example.com"
+		publicName = "public.example.com"
+	)
+	echConfig, echKey := newTestECHConfig(t, publicName)
+	serverNames := make(chan string, 1)
+	serverAddress := newTestTLSServer(t, &tls.Config{
+		Certificates:             []tls.Certificate{newTestCertificate(t, innerName, publicName)},
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{{Config: echConfig, PrivateKey: echKey}},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverNames <- hello.ServerName
+			return nil, nil
+		},
+	})
+	relayAddress, recorded, mu := newRecordingRelay(t, serverAddress)
+	_, port, _ := net.SplitHostPort(relayAddress)
+
+	echConfigList := binary.BigEndian.AppendUint16(nil, uint16(len(echConfig)))
+	echConfigList = append(echConfigList, echConfig...)
+	options := DefaultOptions
+	options.ECHConfigList = echConfigList
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+
+	conn, err := fd.DialTLS(context.Background(), "tcp", net.JoinHostPort(innerName, port))
+	require.Nil(t, err)
+	defer conn.Close()
+
+	tlsConn, ok := conn.(*tls.Conn)
+	require.True(t, ok)
+	require.True(t, tlsConn.ConnectionState().ECHAccepted)
+	require.Equal(t, innerName, <-serverNames)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.False(t, bytes.Contains(recorded.Bytes(), []byte(innerName)), "inner sni sent in clear")
+	require.True(t, bytes.Contains(recorded.Bytes(), []byte(publicName)))
+}
@@ -0,0 +1,10 @@
+//go:build !go1.23
+
+package fastdialer
+
+import "crypto/tls"
+
+// applyECHConfig fails as encrypted client hello requires go1.23 or later
+func applyECHConfig(config *tls.Config, echConfigList []byte) error {
+	return ECHNotSupportedError
+}
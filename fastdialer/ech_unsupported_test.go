@@ -0,0 +1,19 @@
+//go:build !go1.23
+
+package fastdialer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialTLSWithECHUnsupported(t *testing.T) {
+	options := DefaultOptions
+	options.ECHConfigList = []byte{0x00, 0x00}
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+
+	_, err := fd.DialTLS(context.Background(), "tcp", "example.com:443")
+	require.ErrorIs(t, err, ECHNotSupportedError)
+}
@@ -1,18 +1,124 @@
 package fastdialer
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	retryabledns "github.com/boss-net/retryabledns"
+	"github.com/miekg/dns"
 	"github.com/pkg/errors"
 )
 
 var (
-	CouldNotConnectError  = errors.New("could not connect to any address found for host")
-	NoAddressFoundError   = errors.New("no address found for host")
-	NoAddressAllowedError = errors.New("denied address found for host")
-	NoPortSpecifiedError  = errors.New("port was not specified")
-	MalformedIP6Error     = errors.New("malformed IPv6 address")
-	ResolveHostError      = errors.New("could not resolve host")
-	NoTLSHistoryError     = errors.New("no tls data history available")
-	NoTLSDataError        = errors.New("no tls data found for the key")
-	NoDNSDataError        = errors.New("no data found")
-	AsciiConversionError  = errors.New("could not convert hostname to ASCII")
+	// ErrNoResolution is wrapped by the errors of hostnames which couldn't be resolved
+	ErrNoResolution = errors.New("could not resolve")
+	// ErrNoAddressFound is wrapped by the errors of hostnames resolving to no address
+	ErrNoAddressFound = errors.New("no address found")
+)
+
+var (
+	CouldNotConnectError         = errors.New("could not connect to any address found for host")
+	NoAddressFoundError          = fmt.Errorf("%w for host", ErrNoAddressFound)
+	NoAddressAllowedError        = errors.New("denied address found for host")
+	NoPortSpecifiedError         = errors.New("port was not specified")
+	MalformedIP6Error            = errors.New("malformed IPv6 address")
+	ResolveHostError             = fmt.Errorf("%w host", ErrNoResolution)
+	NoTLSHistoryError            = errors.New("no tls data history available")
+	NoTLSDataError               = errors.New("no tls data found for the key")
+	NoDialHistoryError           = errors.New("no dialer history available")
+	NoDNSDataError               = errors.New("no data found")
+	CacheNotScannableError       = errors.New("cache entries can't be iterated")
+	AsciiConversionError         = errors.New("could not convert hostname to ASCII")
+	UnknownFingerprintError      = errors.New("unknown tls fingerprint")
+	InvalidFingerprintError      = errors.New("invalid proxy tls fallback fingerprint")
+	ECHNotSupportedError         = errors.New("encrypted client hello is not supported")
+	VerifyNotSupportedError      = errors.New("connection verification is not supported by ztls")
+	ErrBlockedDomain             = errors.New("domain blocked by policy")
+	ErrInvalidHostname           = errors.New("invalid hostname")
+	ErrDialingDisabled           = errors.New("dialing is disabled in resolve only mode")
+	ErrAllBlocked                = errors.New("all addresses blocked by network policy")
+	InvalidSourcePortRangeError  = errors.New("invalid source port range")
+	InvalidBindAddressError      = errors.New("invalid bind address")
+	InvalidProxyProtocolError    = errors.New("invalid proxy protocol")
+	SourcePortExhaustedError     = errors.New("no free source port in range")
+	ForcedIPNotResolvedError     = errors.New("forced ip is not a resolved address of the host")
+	InvalidProxyChainError       = errors.New("invalid proxy in chain")
+	UDPOverProxyError            = errors.New("udp is not supported through proxies")
+	ConnLimitExceededError       = errors.New("connection limit exceeded")
+	UnsupportedNetworkError      = errors.New("unsupported network")
+	QueryMismatchError           = errors.New("dns response doesn't match the query")
+	InvalidDoTResolverError      = errors.New("invalid dns over tls resolver")
+	InvalidResolverError         = errors.New("invalid resolver")
+	InvalidPolicyEntryError      = errors.New("invalid network policy entry")
+	InvalidServiceAddressError   = errors.New("invalid service map address")
+	UnreachableResolverError     = errors.New("resolver is unreachable")
+	UnreachableProxyError        = errors.New("proxy is unreachable")
+	BootstrapResolverError       = errors.New("bootstrap resolvers must be plain udp or tcp ones")
+	BootstrapFailedError         = errors.New("could not resolve the resolver hostname")
+	TLSSessionCacheDisabledError = errors.New("tls session cache is not enabled")
+	NoCoalesceDataError          = errors.New("no tls connection recorded for the host")
+	CertificateNameMismatchError = errors.New("certificate is not valid for the host")
 )
+
+// BlockedError is returned when the host resolved but every address was denied by the network policy
+type BlockedError struct {
+	Hostname string
+	IPs      []string
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("%s: %s [%s]", ErrAllBlocked, e.Hostname, strings.Join(e.IPs, ","))
+}
+
+// Is allows matching with errors.Is against ErrAllBlocked and NoAddressAllowedError
+func (e *BlockedError) Is(target error) bool {
+	return target == ErrAllBlocked || target == NoAddressAllowedError
+}
+
+// ResolveError is returned when the host couldn't be resolved to any address
+type ResolveError struct {
+	Hostname string
+	Rcode    int // response code of the answer, -1 if none was received
+	Err      error
+}
+
+// newResolveError wraps the cause of the resolution failure with the sentinel error
+func newResolveError(hostname string, data *retryabledns.DNSData, sentinel, cause error) *ResolveError {
+	e := &ResolveError{Hostname: hostname, Rcode: -1, Err: sentinel}
+	if data != nil && data.StatusCode != "" {
+		e.Rcode = data.StatusCodeRaw
+	}
+	if cause != nil && cause != sentinel {
+		e.Err = fmt.Errorf("%w: %w", sentinel, cause)
+	}
+	return e
+}
+
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Hostname, e.Err)
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary checks if the resolution is worth retrying: timeouts, network errors, server
+// failures and REFUSED answers are transient while missing domains or records and blocked or
+// invalid hostnames are permanent
+func (e *ResolveError) Temporary() bool {
+	var netErr net.Error
+	switch {
+	case e.Rcode == dns.RcodeServerFailure || e.Rcode == dns.RcodeRefused:
+		return true
+	case errors.As(e.Err, &netErr):
+		return true
+	case e.Rcode >= 0:
+		return false
+	case errors.Is(e.Err, context.Canceled), errors.Is(e.Err, ErrBlockedDomain), errors.Is(e.Err, ErrInvalidHostname), errors.Is(e.Err, AsciiConversionError):
+		return false
+	default:
+		return true
+	}
+}
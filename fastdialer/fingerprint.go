@@ -0,0 +1,54 @@
+package fastdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/boss-net/fastdialer/fastdialer/ja3"
+	"github.com/boss-net/fastdialer/fastdialer/ja3/impersonate"
+)
+
+// DialTLSFingerprint dials tls mimicking the client hello of the given fingerprint.
+// The fingerprint can be one of chrome, firefox, random or a full ja3 string
+func (d *Dialer) DialTLSFingerprint(ctx context.Context, network, address, fingerprint string) (net.Conn, error) {
+	strategy, identity, err := parseFingerprint(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS10}
+	return d.DialTLSWithConfigImpersonate(ctx, network, address, config, strategy, identity)
+}
+
+// parseFingerprint maps a fingerprint name or ja3 string to the impersonation strategy
+func parseFingerprint(fingerprint string) (impersonate.Strategy, *impersonate.Identity, error) {
+	switch strings.ToLower(strings.TrimSpace(fingerprint)) {
+	case "chrome":
+		return impersonate.Chrome, nil, nil
+	case "firefox":
+		return impersonate.Firefox, nil, nil
+	case "random", "randomized":
+		return impersonate.Random, nil, nil
+	}
+	if strings.Count(fingerprint, ",") != 4 {
+		return impersonate.None, nil, UnknownFingerprintError
+	}
+	spec, err := ja3.ParseWithJa3(fingerprint)
+	if err != nil {
+		return impersonate.None, nil, err
+	}
+	return impersonate.Custom, (*impersonate.Identity)(spec), nil
+}
+
+// validateFingerprints checks that every fingerprint of Options.ProxyTLSFallbackFingerprints
+// can be parsed
+func validateFingerprints(fingerprints []string) error {
+	for _, fingerprint := range fingerprints {
+		if _, _, err := parseFingerprint(fingerprint); err != nil {
+			return fmt.Errorf("%w: %s: %w", InvalidFingerprintError, fingerprint, err)
+		}
+	}
+	return nil
+}
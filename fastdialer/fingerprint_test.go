@@ -0,0 +1,61 @@
+package fastdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+
+	utls "github.com/refraction-networking/utls"
+	"github.com/stretchr/testify/require"
+)
+
+// normalizeGREASE replaces randomized grease values with the utls placeholder
+func normalizeGREASE(values []uint16) []uint16 {
+	normalized := make([]uint16, len(values))
+	for i, value := range values {
+		if value&0x0f0f == 0x0a0a {
+			value = utls.GREASE_PLACEHOLDER
+		}
+		normalized[i] = value
+	}
+	return normalized
+}
+
+func TestDialTLSFingerprint(t *testing.T) {
+	tests := []struct {
+		fingerprint string
+		helloID     utls.ClientHelloID
+	}{
+		{fingerprint: "chrome", helloID: utls.HelloChrome_Auto},
+		{fingerprint: "firefox", helloID: utls.HelloFirefox_Auto},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fingerprint, func(t *testing.T) {
+			hellos := make(chan *tls.ClientHelloInfo, 1)
+			address := newTestTLSServer(t, &tls.Config{
+				GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+					hellos <- hello
+					return nil, nil
+				},
+			})
+			resolver := &mockResolver{}
+			fd := newTestDialer(t, DefaultOptions, resolver)
+
+			conn, err := fd.DialTLSFingerprint(context.Background(), "tcp", address, tt.fingerprint)
+			require.Nil(t, err)
+			defer conn.Close()
+
+			expected, err := utls.UTLSIdToSpec(tt.helloID)
+			require.Nil(t, err)
+			hello := <-hellos
+			require.Equal(t, normalizeGREASE(expected.CipherSuites), normalizeGREASE(hello.CipherSuites))
+		})
+	}
+}
+
+func TestDialTLSFingerprintUnknown(t *testing.T) {
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{})
+	_, err := fd.DialTLSFingerprint(context.Background(), "tcp", "127.0.0.1:443", "netscape")
+	require.ErrorIs(t, err, UnknownFingerprintError)
+}
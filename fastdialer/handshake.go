@@ -0,0 +1,134 @@
+package fastdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/boss-net/fastdialer/fastdialer/ja3/impersonate"
+	ptrutil "github.com/boss-net/goutils/ptr"
+	utls "github.com/refraction-networking/utls"
+)
+
+// handshakeTLS performs the tls handshake over an established connection,
+// using utls when an impersonation strategy is set
+func handshakeTLS(ctx context.Context, conn net.Conn, tlsconfig *tls.Config, impersonateStrategy impersonate.Strategy, impersonateIdentity *impersonate.Identity) (net.Conn, error) {
+	if impersonateStrategy == impersonate.None {
+		tlsConn := tls.Client(conn, tlsconfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+	return handshakeUTLS(ctx, conn, tlsconfig, impersonateStrategy, impersonateIdentity)
+}
+
+// handshakeUTLS performs the tls handshake over an established connection with the client hello
+// of the impersonation strategy
+func handshakeUTLS(ctx context.Context, conn net.Conn, tlsconfig *tls.Config, impersonateStrategy impersonate.Strategy, impersonateIdentity *impersonate.Identity) (net.Conn, error) {
+	// clone existing tls config
+	uTLSConfig := &utls.Config{
+		InsecureSkipVerify: tlsconfig.InsecureSkipVerify,
+		ServerName:         tlsconfig.ServerName,
+		MinVersion:         tlsconfig.MinVersion,
+		MaxVersion:         tlsconfig.MaxVersion,
+		CipherSuites:       tlsconfig.CipherSuites,
+	}
+	var uTLSConn *utls.UConn
+	switch impersonateStrategy {
+	case impersonate.Random:
+		uTLSConn = utls.UClient(conn, uTLSConfig, utls.HelloRandomized)
+	case impersonate.Chrome:
+		uTLSConn = utls.UClient(conn, uTLSConfig, utls.HelloChrome_Auto)
+	case impersonate.Firefox:
+		uTLSConn = utls.UClient(conn, uTLSConfig, utls.HelloFirefox_Auto)
+	case impersonate.Custom:
+		uTLSConn = utls.UClient(conn, uTLSConfig, utls.HelloCustom)
+		clientHelloSpec := utls.ClientHelloSpec(ptrutil.Safe(impersonateIdentity))
+		if err := uTLSConn.ApplyPreset(&clientHelloSpec); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, UnknownFingerprintError
+	}
+	if err := uTLSConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	if verifyConnection := tlsconfig.VerifyConnection; verifyConnection != nil {
+		if err := verifyConnection(asTLSConnectionState(uTLSConn.ConnectionState())); err != nil {
+			return nil, err
+		}
+	}
+	return uTLSConn, nil
+}
+
+// applyTLSOptions sets the session cache, Options.VerifyConnection and Options.ECHConfigList
+// on the tls config, those already set by the config are kept
+func (d *Dialer) applyTLSOptions(tlsconfig *tls.Config) error {
+	if d.sessionCache != nil && tlsconfig.ClientSessionCache == nil {
+		tlsconfig.ClientSessionCache = d.sessionCache
+	}
+	if d.options.VerifyConnection != nil && tlsconfig.VerifyConnection == nil {
+		tlsconfig.VerifyConnection = d.options.VerifyConnection
+	}
+	if len(d.options.ECHConfigList) > 0 {
+		return applyECHConfig(tlsconfig, d.options.ECHConfigList)
+	}
+	return nil
+}
+
+// UpgradeTLS performs the tls handshake over an established connection, for example one
+// obtained through a tunnel, without resolving or dialing anything. The config defaults to the
+// one of DialTLS and the tls options of the dialer apply to it, a non empty server name replaces
+// the one of the config. The connection is closed if the handshake fails, bounded by the dialer timeout
+func (d *Dialer) UpgradeTLS(ctx context.Context, conn net.Conn, serverName string, config *tls.Config) (net.Conn, error) {
+	tlsconfig := d.defaultTLSConfig()
+	if config != nil {
+		tlsconfig = config.Clone()
+	}
+	if serverName != "" {
+		tlsconfig.ServerName = serverName
+	}
+	if err := d.applyTLSOptions(tlsconfig); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if d.dialer.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.dialer.Timeout)
+		defer cancel()
+	}
+	tlsConn, err := handshakeTLS(ctx, conn, tlsconfig, impersonate.None, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// connectTLS establishes the connection then performs the handshake, timing both steps. Like
+// tls.Dialer, the handshake is bounded by the dialer timeout as well
+func (d *Dialer) connectTLS(ctx context.Context, network, address string, tlsconfig *tls.Config, impersonateStrategy impersonate.Strategy, impersonateIdentity *impersonate.Identity, timings *dialTimings) (net.Conn, error) {
+	start := time.Now()
+	rawConn, err := d.withConnectRetries(ctx, func() (net.Conn, error) {
+		return d.connect(ctx, network, address)
+	})
+	if err != nil {
+		return nil, err
+	}
+	timings.connect = time.Since(start)
+	if d.dialer.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.dialer.Timeout)
+		defer cancel()
+	}
+	start = time.Now()
+	conn, err := handshakeTLS(ctx, rawConn, tlsconfig, impersonateStrategy, impersonateIdentity)
+	if err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	timings.handshake = time.Since(start)
+	return conn, nil
+}
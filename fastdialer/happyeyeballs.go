@@ -0,0 +1,116 @@
+package fastdialer
+
+import (
+	"context"
+	"net"
+	"time"
+
+	iputil "github.com/boss-net/goutils/ip"
+)
+
+// ParallelStrategy races the dials to the ips (rfc 8305 happy eyeballs), alternating the address
+// families starting with the family of the first ip. A new attempt is started every Delay or as
+// soon as the latest one failed, all of them at once with a zero Delay. The first established
+// connection is returned, the other attempts are canceled and their connections closed.
+// Options.FallbackDelay doesn't affect these dials, as net.Dialer only races the address
+// families of the hostnames it resolves itself
+type ParallelStrategy struct {
+	Delay time.Duration
+}
+
+// Dial implements DialStrategy
+func (s ParallelStrategy) Dial(ctx context.Context, hostname string, ips []string, dial DialFunc) (net.Conn, error) {
+	if len(ips) == 0 {
+		return nil, CouldNotConnectError
+	}
+	ips = interleaveFamilies(ips)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type attempt struct {
+		conn net.Conn
+		err  error
+	}
+	attempts := make(chan attempt, len(ips))
+	next, pending := 0, 0
+	startNext := func() {
+		ip := ips[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dial(ctx, ip)
+			attempts <- attempt{conn: conn, err: err}
+		}()
+	}
+
+	startNext()
+	for s.Delay <= 0 && next < len(ips) {
+		startNext()
+	}
+	// the timer never fires once all the attempts are started
+	timer := time.NewTimer(s.Delay)
+	defer timer.Stop()
+	var err error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if next < len(ips) {
+				startNext()
+				timer.Reset(s.Delay)
+			}
+		case result := <-attempts:
+			pending--
+			if result.err == nil {
+				// close the connections established by the losing attempts
+				go func(pending int) {
+					for i := 0; i < pending; i++ {
+						if late := <-attempts; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return result.conn, nil
+			}
+			err = result.err
+			if ctx.Err() != nil {
+				continue
+			}
+			if next < len(ips) {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				startNext()
+				timer.Reset(s.Delay)
+			}
+		}
+	}
+	return nil, err
+}
+
+// interleaveFamilies alternates ipv4 and ipv6 addresses keeping their relative order,
+// starting with the family of the first address
+func interleaveFamilies(ips []string) []string {
+	var first, second []string
+	firstIsIPv6 := iputil.IsIPv6(withoutZone(ips[0]))
+	for _, ip := range ips {
+		if iputil.IsIPv6(withoutZone(ip)) == firstIsIPv6 {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	interleaved := make([]string, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			interleaved = append(interleaved, first[i])
+		}
+		if i < len(second) {
+			interleaved = append(interleaved, second[i])
+		}
+	}
+	return interleaved
+}
@@ -0,0 +1,55 @@
+package fastdialer
+
+import (
+	"context"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFallbackDelay(t *testing.T) {
+	options := DefaultOptions
+	options.FallbackDelay = 42 * time.Millisecond
+	fd := newTestDialer(t, options, &mockResolver{})
+	require.Equal(t, options.FallbackDelay, fd.dialer.FallbackDelay)
+}
+
+func TestHappyEyeballs(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	// connections to the first address hang
+	slowDialer := &net.Dialer{Control: func(network, address string, c syscall.RawConn) error {
+		if strings.HasPrefix(address, "127.0.0.2:") {
+			time.Sleep(2 * time.Second)
+		}
+		return nil
+	}}
+	resolver := &mockResolver{resolve: staticAnswer([]string{"127.0.0.2", "127.0.0.1"}, nil)}
+
+	options := DefaultOptions
+	options.Dialer = slowDialer
+	options.HappyEyeballsDelay = 50 * time.Millisecond
+	fd := newTestDialer(t, options, resolver)
+
+	start := time.Now()
+	conn, info, err := fd.DialWithInfo(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+	require.Nil(t, err)
+	conn.Close()
+	require.Equal(t, "127.0.0.1", info.IP)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestInterleaveFamilies(t *testing.T) {
+	require.Equal(t,
+		[]string{"10.0.0.1", "fd00::1", "10.0.0.2", "fd00::2", "fd00::3"},
+		interleaveFamilies([]string{"10.0.0.1", "10.0.0.2", "fd00::1", "fd00::2", "fd00::3"}),
+	)
+	require.Equal(t,
+		[]string{"fd00::1", "10.0.0.1", "fd00::2"},
+		interleaveFamilies([]string{"fd00::1", "fd00::2", "10.0.0.1"}),
+	)
+}
@@ -0,0 +1,161 @@
+package fastdialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/proxy"
+)
+
+// Validate checks the options without any network access: resolvers, policy entries and
+// files, proxy urls, the service map, the source ports and addresses and the proxy protocol
+// version. All the problems found are joined
+func (o *Options) Validate() error {
+	var errs []error
+	for _, resolver := range normalizeResolvers(o.BaseResolvers) {
+		if !isValidResolver(resolver) {
+			errs = append(errs, fmt.Errorf("%w: %s", InvalidResolverError, resolver))
+		}
+	}
+	if _, err := newDoTResolvers(o.DoTResolvers, o.DoTServerName, o.MaxRetries); err != nil {
+		errs = append(errs, err)
+	}
+	for _, resolver := range normalizeResolvers(o.BootstrapResolvers) {
+		if !isValidResolver(resolver) || !isPlainResolver(resolver) {
+			errs = append(errs, fmt.Errorf("%w: %s", BootstrapResolverError, resolver))
+		}
+	}
+	for _, list := range [][]string{o.Allow, o.Deny} {
+		for _, entry := range list {
+			if !isValidPolicyEntry(entry) {
+				errs = append(errs, fmt.Errorf("%w: %s", InvalidPolicyEntryError, entry))
+			}
+		}
+	}
+	for _, path := range []string{o.AllowFile, o.DenyFile} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, proxyURL := range o.ProxyChain {
+		if _, err := newProxyChain([]string{proxyURL}, proxy.Direct); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := parseServiceMap(o.ServiceMap); err != nil {
+		errs = append(errs, err)
+	}
+	if o.SourcePortRange != [2]int{} && !validSourcePortRange(o.SourcePortRange) {
+		errs = append(errs, InvalidSourcePortRangeError)
+	}
+	if _, err := parseBindAddresses(o.BindAddresses); err != nil {
+		errs = append(errs, err)
+	}
+	if o.SendProxyProtocol < 0 || o.SendProxyProtocol > 2 {
+		errs = append(errs, fmt.Errorf("%w: version %d", InvalidProxyProtocolError, o.SendProxyProtocol))
+	}
+	if err := validateFingerprints(o.ProxyTLSFallbackFingerprints); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// isValidResolver checks a normalized [protocol:]host:port resolver, doh ones must be urls
+func isValidResolver(resolver string) bool {
+	if address, ok := strings.CutPrefix(resolver, "doh:"); ok {
+		u, err := url.Parse(address)
+		return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	}
+	for _, protocol := range []string{"udp:", "tcp:", "dot:"} {
+		resolver = strings.TrimPrefix(resolver, protocol)
+	}
+	host, port, err := net.SplitHostPort(resolver)
+	if err != nil || host == "" {
+		return false
+	}
+	if portNumber, err := strconv.Atoi(port); err != nil || portNumber < 1 || portNumber > 65535 {
+		return false
+	}
+	return net.ParseIP(host) != nil || isValidHostname(host)
+}
+
+// Healthcheck queries every resolver and connects to the first proxy of the chain, all the
+// unreachable ones are joined in the error. Proxies set with Options.ProxyDialer are opaque
+// dialers and can't be checked without a target
+func (d *Dialer) Healthcheck(ctx context.Context) error {
+	var errs []error
+	for _, resolver := range d.resolvers {
+		client, err := newDNSClient([]string{resolver}, 1)
+		if err == nil {
+			err = queryResolver(ctx, client)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: %s: %w", UnreachableResolverError, resolver, err))
+		}
+	}
+	for _, resolver := range d.options.DoTResolvers {
+		client, err := newDoTResolvers([]string{resolver}, d.options.DoTServerName, 1)
+		if err == nil {
+			err = queryResolver(ctx, client)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: %s: %w", UnreachableResolverError, resolver, err))
+		}
+	}
+	if len(d.options.ProxyChain) > 0 && d.options.ProxyDialer == nil {
+		if err := d.checkProxy(ctx, d.options.ProxyChain[0]); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %s: %w", UnreachableProxyError, d.options.ProxyChain[0], err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// queryResolver sends a query for the root name servers, any answer means the resolver is reachable
+func queryResolver(ctx context.Context, resolver dnsResolver) error {
+	msg := &dns.Msg{}
+	msg.SetQuestion(".", dns.TypeNS)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := resolver.Do(msg)
+		errCh <- err
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
+// checkProxy connects to the proxy of the url with the dialer, without going through it
+func (d *Dialer) checkProxy(ctx context.Context, proxyURL string) error {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return err
+	}
+	address := u.Host
+	if u.Port() == "" {
+		port := "1080"
+		if u.Scheme == "http" {
+			port = "80"
+		} else if u.Scheme == "https" {
+			port = "443"
+		}
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+	conn, err := d.dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
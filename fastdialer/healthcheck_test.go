@@ -0,0 +1,85 @@
+package fastdialer
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	options := DefaultOptions
+	require.Nil(t, options.Validate())
+
+	tests := []struct {
+		name   string
+		modify func(*Options)
+		want   error
+	}{
+		{"resolver port", func(o *Options) { o.BaseResolvers = []string{"1.1.1.1:dns"} }, InvalidResolverError},
+		{"resolver host", func(o *Options) { o.BaseResolvers = []string{"udp:bad_host-:53"} }, InvalidResolverError},
+		{"doh resolver", func(o *Options) { o.BaseResolvers = []string{"doh:dns.google/dns-query"} }, InvalidResolverError},
+		{"dot resolver", func(o *Options) { o.DoTResolvers = []string{"1.1.1.1"} }, InvalidDoTResolverError},
+		{"allow cidr", func(o *Options) { o.Allow = []string{"10.0.0.0/33"} }, InvalidPolicyEntryError},
+		{"deny pattern", func(o *Options) { o.Deny = []string{"[a-"} }, InvalidPolicyEntryError},
+		{"proxy scheme", func(o *Options) { o.ProxyChain = []string{"gopher://127.0.0.1:1080"} }, InvalidProxyChainError},
+		{"source ports", func(o *Options) { o.SourcePortRange = [2]int{2000, 1000} }, InvalidSourcePortRangeError},
+		{"fallback fingerprint", func(o *Options) { o.ProxyTLSFallbackFingerprints = []string{"chrome", "chrom"} }, InvalidFingerprintError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := DefaultOptions
+			tt.modify(&options)
+			require.ErrorIs(t, options.Validate(), tt.want)
+		})
+	}
+
+	// every problem is reported
+	options.BaseResolvers = []string{"1.1.1.1:dns"}
+	options.Deny = []string{"10.0.0.0/33"}
+	options.AllowFile = filepath.Join(t.TempDir(), "missing.txt")
+	err := options.Validate()
+	require.ErrorIs(t, err, InvalidResolverError)
+	require.ErrorIs(t, err, InvalidPolicyEntryError)
+	require.Contains(t, err.Error(), "missing.txt")
+}
+
+func TestHealthcheck(t *testing.T) {
+	var calls int32
+	resolver := newTestDNSServer(t, answerA(1, false, &calls), answerA(1, false, &calls))
+	fd := newResolverTestDialer(t, DefaultOptions, resolver)
+	require.Nil(t, fd.Healthcheck(context.Background()))
+
+	// nothing listens on the port once the listener is closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	closed := listener.Addr().String()
+	listener.Close()
+
+	options := DefaultOptions
+	options.DNSForceTCP = true
+	options.ProxyChain = []string{"socks5://" + closed}
+	fd = newResolverTestDialer(t, options, closed)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = fd.Healthcheck(ctx)
+	require.ErrorIs(t, err, UnreachableResolverError)
+	require.ErrorIs(t, err, UnreachableProxyError)
+	require.Contains(t, err.Error(), closed)
+}
+
+func TestHealthcheckContext(t *testing.T) {
+	// the server never answers
+	stalled := newTestDNSServer(t, func(dns.ResponseWriter, *dns.Msg) {}, func(dns.ResponseWriter, *dns.Msg) {})
+	fd := newResolverTestDialer(t, DefaultOptions, stalled)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := fd.Healthcheck(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,121 @@
+package fastdialer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCertificate returns a self signed certificate valid for localhost and 127.0.0.1
+func newTestCertificate(t *testing.T, dnsNames ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "fastdialer test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              append([]string{"localhost"}, dnsNames...),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// newTestTLSServer starts a tls listener completing handshakes with the given config
+// and returns its address
+func newTestTLSServer(t *testing.T, config *tls.Config) string {
+	t.Helper()
+	if len(config.Certificates) == 0 && config.GetCertificate == nil {
+		config.Certificates = []tls.Certificate{newTestCertificate(t)}
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.(*tls.Conn).Handshake()
+				// keep the connection open until the client closes it
+				buf := make([]byte, 1)
+				_, _ = conn.Read(buf)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// newTestTCPServer starts a tcp listener accepting connections and returns its address
+func newTestTCPServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1)
+				_, _ = conn.Read(buf)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// newTestDNSServer serves the handlers over udp and tcp on the same port and returns its address
+func newTestDNSServer(t *testing.T, udpHandler, tcpHandler dns.HandlerFunc) string {
+	t.Helper()
+	var (
+		packetConn net.PacketConn
+		listener   net.Listener
+		err        error
+	)
+	// the tcp port matching the random udp one may be taken, try a few times
+	for i := 0; i < 10; i++ {
+		packetConn, err = net.ListenPacket("udp", "127.0.0.1:0")
+		require.Nil(t, err)
+		listener, err = net.Listen("tcp", packetConn.LocalAddr().String())
+		if err == nil {
+			break
+		}
+		packetConn.Close()
+	}
+	require.Nil(t, err, "could not listen on matching udp and tcp ports")
+	udpServer := &dns.Server{PacketConn: packetConn, Handler: udpHandler}
+	tcpServer := &dns.Server{Listener: listener, Handler: tcpHandler}
+	go udpServer.ActivateAndServe() //nolint:errcheck
+	go tcpServer.ActivateAndServe() //nolint:errcheck
+	t.Cleanup(func() {
+		_ = udpServer.Shutdown()
+		_ = tcpServer.Shutdown()
+	})
+	return packetConn.LocalAddr().String()
+}
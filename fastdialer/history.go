@@ -0,0 +1,68 @@
+package fastdialer
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+
+	iputil "github.com/boss-net/goutils/ip"
+)
+
+// familyHistorySeparator separates the hostname and the address family in the keys of the
+// per family history, it can't appear in hostnames
+const familyHistorySeparator = "\x00"
+
+// recordDialedIP stores the ip as the last one dialed for the hostname and for its address family
+func (d *Dialer) recordDialedIP(hostname, ip string) error {
+	if err := d.dialerHistory.Set(hostname, []byte(ip)); err != nil {
+		return err
+	}
+	family := "ip4"
+	if iputil.IsIPv6(withoutZone(ip)) {
+		family = "ip6"
+	}
+	return d.dialerHistory.Set(hostname+familyHistorySeparator+family, []byte(ip))
+}
+
+// GetDialedIPv4 returns the last ipv4 address dialed for the hostname
+func (d *Dialer) GetDialedIPv4(hostname string) string {
+	return d.lastDialedIP(asAscii(hostname) + familyHistorySeparator + "ip4")
+}
+
+// GetDialedIPv6 returns the last ipv6 address dialed for the hostname
+func (d *Dialer) GetDialedIPv6(hostname string) string {
+	return d.lastDialedIP(asAscii(hostname) + familyHistorySeparator + "ip6")
+}
+
+// DialHistoryEntry is a line of the dialer history export
+type DialHistoryEntry struct {
+	Hostname string `json:"hostname"`
+	IP       string `json:"ip"`
+}
+
+// ExportDialHistory writes the last ip dialed for each hostname as json lines sorted by hostname,
+// it requires Options.WithDialerHistory
+func (d *Dialer) ExportDialHistory(w io.Writer) error {
+	if !d.options.WithDialerHistory || d.dialerHistory == nil {
+		return NoDialHistoryError
+	}
+	var entries []DialHistoryEntry
+	d.dialerHistory.Scan(func(k, v []byte) error {
+		if strings.Contains(string(k), familyHistorySeparator) {
+			return nil
+		}
+		entries = append(entries, DialHistoryEntry{Hostname: string(k), IP: string(v)})
+		return nil
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Hostname < entries[j].Hostname
+	})
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
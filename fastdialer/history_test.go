@@ -0,0 +1,165 @@
+package fastdialer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boss-net/hmap/store/hybrid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportDialHistory(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	options := DefaultOptions
+	options.WithDialerHistory = true
+	resolver := &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)}
+	fd := newTestDialer(t, options, resolver)
+
+	for _, host := range []string{"b.example.com", "a.example.com"} {
+		conn, err := fd.Dial(context.Background(), "tcp", net.JoinHostPort(host, port))
+		require.Nil(t, err)
+		conn.Close()
+	}
+
+	var buf bytes.Buffer
+	require.Nil(t, fd.ExportDialHistory(&buf))
+	var entries []DialHistoryEntry
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var entry DialHistoryEntry
+		require.Nil(t, decoder.Decode(&entry))
+		entries = append(entries, entry)
+	}
+	require.Equal(t, []DialHistoryEntry{
+		{Hostname: "a.example.com", IP: "127.0.0.1"},
+		{Hostname: "b.example.com", IP: "127.0.0.1"},
+	}, entries)
+
+	fd = newTestDialer(t, DefaultOptions, resolver)
+	require.ErrorIs(t, fd.ExportDialHistory(&buf), NoDialHistoryError)
+}
+
+func TestDialedIPByFamily(t *testing.T) {
+	// dual stack listener
+	listener, err := net.Listen("tcp", "[::]:0")
+	if err != nil {
+		t.Skip("ipv6 not available")
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.Nil(t, err)
+	options := DefaultOptions
+	options.WithDialerHistory = true
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, []string{"::1"})})
+	address := net.JoinHostPort("example.com", port)
+
+	conn, err := fd.Dial(context.Background(), "tcp6", address)
+	if err != nil {
+		t.Skip("ipv6 loopback not available")
+	}
+	conn.Close()
+	conn, err = fd.Dial(context.Background(), "tcp4", address)
+	require.Nil(t, err)
+	conn.Close()
+
+	require.Equal(t, "127.0.0.1", fd.GetDialedIP("example.com"))
+	require.Equal(t, "127.0.0.1", fd.GetDialedIPv4("example.com"))
+	require.Equal(t, "::1", fd.GetDialedIPv6("example.com"))
+	require.Empty(t, fd.GetDialedIPv6("unknown.example.com"))
+
+	// the per family history isn't exported
+	var buf bytes.Buffer
+	require.Nil(t, fd.ExportDialHistory(&buf))
+	require.Equal(t, 1, bytes.Count(buf.Bytes(), []byte("\n")))
+}
+
+func TestDialHistoryStorage(t *testing.T) {
+	address := newTestTCPServer(t)
+	for _, tt := range []struct {
+		name     string
+		options  func(*Options)
+		recorded bool
+	}{
+		{name: "in memory", options: func(o *Options) { o.DialHistoryInMemory = true }, recorded: true},
+		{name: "disabled", options: func(o *Options) { o.DisableDialHistory = true }},
+	} {
+		// disk backed maps are created in the temporary directory
+		tmpDir := t.TempDir()
+		t.Setenv("TMPDIR", tmpDir)
+		options := DefaultOptions
+		options.WithDialerHistory = true
+		tt.options(&options)
+		fd := newTestDialer(t, options, &mockResolver{})
+
+		conn, err := fd.Dial(context.Background(), "tcp", address)
+		require.Nil(t, err, tt.name)
+		conn.Close()
+		host, _, _ := net.SplitHostPort(address)
+		if tt.recorded {
+			require.Equal(t, host, fd.GetDialedIP(host), tt.name)
+		} else {
+			require.Empty(t, fd.GetDialedIP(host), tt.name)
+			require.ErrorIs(t, fd.ExportDialHistory(&bytes.Buffer{}), NoDialHistoryError, tt.name)
+		}
+		entries, err := os.ReadDir(tmpDir)
+		require.Nil(t, err)
+		require.Empty(t, entries, tt.name)
+	}
+}
+
+func TestHybridOptions(t *testing.T) {
+	address := newTestTLSServer(t, &tls.Config{})
+	dir := t.TempDir()
+	options := DefaultOptions
+	options.WithDialerHistory = true
+	options.WithTLSData = true
+	options.HybridOptions = &hybrid.Options{Type: hybrid.Disk, DBType: hybrid.LevelDB, Path: dir}
+	fd := newTestDialer(t, options, &mockResolver{})
+
+	conn, err := fd.DialTLS(context.Background(), "tcp", address)
+	require.Nil(t, err)
+	conn.Close()
+	host, _, _ := net.SplitHostPort(address)
+	require.Equal(t, host, fd.GetDialedIP(host))
+	_, err = fd.GetTLSData(host)
+	require.Nil(t, err)
+
+	// each store has its own database in the custom directory, kept without cleanup
+	fd.Close()
+	for _, name := range []string{"history", "tlsdata"} {
+		entries, err := os.ReadDir(filepath.Join(dir, name))
+		require.Nil(t, err, name)
+		require.NotEmpty(t, entries, name)
+	}
+}
+
+func TestDialHistoryWriteFailure(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	options := DefaultOptions
+	options.WithDialerHistory = true
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+	// writes to the closed disk store fail
+	require.Nil(t, fd.dialerHistory.Close())
+	require.NotNil(t, fd.recordDialedIP("example.com", "127.0.0.1"))
+
+	conn, err := fd.Dial(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+	require.Nil(t, err)
+	conn.Close()
+}
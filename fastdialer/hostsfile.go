@@ -8,11 +8,10 @@ import (
 	"strings"
 
 	"github.com/dimchansky/utfbom"
-	"github.com/boss-net/hmap/store/hybrid"
 	"github.com/boss-net/retryabledns"
 )
 
-func loadHostsFile(hm *hybrid.HybridMap) error {
+func loadHostsFile(hm Cache) error {
 	osHostsFilePath := os.ExpandEnv(filepath.FromSlash(HostsFilePath))
 
 	if env, isset := os.LookupEnv("HOSTS_PATH"); isset && len(env) > 0 {
@@ -33,13 +32,15 @@ func loadHostsFile(hm *hybrid.HybridMap) error {
 			continue
 		}
 		netIP := net.ParseIP(ip)
+		// ipv4-mapped addresses are stored in their ipv4 form
+		ip = unmapIPv4(ip)
 		isIPv4 := netIP.To4() != nil
 		isIPv6 := netIP.To16() != nil
 
 		for _, host := range hosts {
 			dnsdata, ok := dnsDatas[host]
 			if !ok {
-				dnsdata = retryabledns.DNSData{Host: host}
+				dnsdata = retryabledns.DNSData{Host: host, HostsFile: true}
 			}
 			if isIPv4 {
 				dnsdata.A = append(dnsdata.A, ip)
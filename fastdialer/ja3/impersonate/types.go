@@ -14,6 +14,10 @@ const (
 	Random
 	// JA3 or Raw is the strategy which parses a client hello spec from ja3 full string
 	Custom
+	// Chrome is the strategy which use the latest chrome client hello spec
+	Chrome
+	// Firefox is the strategy which use the latest firefox client hello spec
+	Firefox
 )
 
 // Identity contains the structured client hello spec
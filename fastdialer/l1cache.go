@@ -0,0 +1,111 @@
+package fastdialer
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// l1CacheTTL bounds how long an entry is served from memory without reading the underlying cache
+const l1CacheTTL = time.Minute
+
+// l1Entry is an element of the l1 cache
+type l1Entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// l1Cache is a small in-memory lru in front of a slower cache, usually the hybrid map
+// which may hit disk. Entries are populated on reads and writes, and deletions and
+// writes go through it so both levels stay consistent.
+type l1Cache struct {
+	Cache
+
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newL1Cache(cache Cache, size int) *l1Cache {
+	return &l1Cache{Cache: cache, size: size, ttl: l1CacheTTL, order: list.New(), entries: make(map[string]*list.Element, size)}
+}
+
+func (c *l1Cache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*l1Entry)
+		if time.Now().Before(entry.expires) {
+			c.order.MoveToFront(elem)
+			c.mu.Unlock()
+			return entry.value, true
+		}
+		c.remove(elem)
+	}
+	c.mu.Unlock()
+
+	value, ok := c.Cache.Get(key)
+	if ok {
+		c.add(key, value)
+	}
+	return value, ok
+}
+
+func (c *l1Cache) Set(key string, value []byte) error {
+	if err := c.Cache.Set(key, value); err != nil {
+		c.Evict(key)
+		return err
+	}
+	c.add(key, value)
+	return nil
+}
+
+func (c *l1Cache) Delete(key string) error {
+	c.Evict(key)
+	return c.Cache.Delete(key)
+}
+
+func (c *l1Cache) Close() error {
+	c.Flush()
+	return c.Cache.Close()
+}
+
+// Evict drops the key from memory only, the next read goes to the underlying cache
+func (c *l1Cache) Evict(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// Flush drops every entry held in memory
+func (c *l1Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element, c.size)
+}
+
+func (c *l1Cache) add(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*l1Entry)
+		entry.value, entry.expires = value, expires
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&l1Entry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *l1Cache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*l1Entry).key)
+}
@@ -0,0 +1,104 @@
+package fastdialer
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/boss-net/hmap/store/hybrid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestL1Cache(t *testing.T) {
+	inner := &countingCache{Cache: &mapCache{items: make(map[string][]byte)}}
+	cache := newL1Cache(inner, 2)
+
+	// writes populate the l1 so the following read doesn't touch the inner cache
+	require.Nil(t, cache.Set("a", []byte("1")))
+	value, ok := cache.Get("a")
+	require.True(t, ok)
+	require.Equal(t, []byte("1"), value)
+	require.Zero(t, atomic.LoadInt32(&inner.gets))
+
+	// reads of missing keys populate it once
+	require.Nil(t, inner.Set("b", []byte("2")))
+	for i := 0; i < 2; i++ {
+		value, ok = cache.Get("b")
+		require.True(t, ok)
+		require.Equal(t, []byte("2"), value)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&inner.gets))
+
+	// the least recently used key is evicted
+	require.Nil(t, cache.Set("c", []byte("3")))
+	_, ok = cache.Get("a")
+	require.True(t, ok)
+	require.Equal(t, int32(2), atomic.LoadInt32(&inner.gets))
+
+	// deletions go through both levels
+	require.Nil(t, cache.Delete("c"))
+	_, ok = cache.Get("c")
+	require.False(t, ok)
+	_, ok = inner.Get("c")
+	require.False(t, ok)
+
+	// flushed and expired entries are read again from the inner cache
+	gets := atomic.LoadInt32(&inner.gets)
+	cache.Flush()
+	_, ok = cache.Get("a")
+	require.True(t, ok)
+	require.Equal(t, gets+1, atomic.LoadInt32(&inner.gets))
+	cache.ttl = 0
+	require.Nil(t, cache.Set("a", []byte("4")))
+	value, ok = cache.Get("a")
+	require.True(t, ok)
+	require.Equal(t, []byte("4"), value)
+	require.Equal(t, gets+2, atomic.LoadInt32(&inner.gets))
+}
+
+func TestL1CacheSize(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	inner := &countingCache{Cache: &mapCache{items: make(map[string][]byte)}}
+	options := DefaultOptions
+	options.Cache = inner
+	options.L1CacheSize = 16
+	resolver := &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)}
+	fd := newTestDialer(t, options, resolver)
+
+	for i := 0; i < 3; i++ {
+		conn, err := fd.Dial(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+		require.Nil(t, err)
+		conn.Close()
+	}
+	// a single miss before the resolution, the following dials are served by the l1
+	require.Equal(t, int32(1), atomic.LoadInt32(&inner.gets))
+	require.Equal(t, int32(1), atomic.LoadInt32(&resolver.resolveCalls))
+}
+
+func BenchmarkL1Cache(b *testing.B) {
+	hm, err := hybrid.New(hybrid.DefaultDiskOptions)
+	require.Nil(b, err)
+	defer hm.Close()
+	inner := &hybridCache{HybridMap: hm}
+	for i := 0; i < 64; i++ {
+		require.Nil(b, inner.Set("host"+strconv.Itoa(i), []byte("data")))
+	}
+
+	for _, size := range []int{0, 128} {
+		cache := Cache(inner)
+		if size > 0 {
+			cache = newL1Cache(inner, size)
+		}
+		b.Run("size="+strconv.Itoa(size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, ok := cache.Get("host" + strconv.Itoa(i%64)); !ok {
+					b.Fatal("missing entry")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package fastdialer
+
+import (
+	"net/netip"
+	"strings"
+
+	retryabledns "github.com/boss-net/retryabledns"
+)
+
+// maxLiterals bounds the memoized ip literals, the following ones are parsed on each lookup
+const maxLiterals = 4096
+
+// literalIP is the memoized classification of an ip literal
+type literalIP struct {
+	ip   string
+	ipv6 bool
+}
+
+// literalDNSData returns the records of the hostname if it's an ip literal. Literals are
+// bare or bracketed (rfc 2732, http://[::1]:8080), link-local ipv6 addresses keep their zone
+// as it's required to dial them and ipv4-mapped addresses are classified as ipv4
+func (d *Dialer) literalDNSData(hostname string) (*retryabledns.DNSData, bool) {
+	if !maybeLiteral(hostname) {
+		return nil, false
+	}
+	var literal literalIP
+	if value, ok := d.literals.Load(hostname); ok {
+		literal = value.(literalIP)
+	} else if literal, ok = parseLiteral(hostname); !ok {
+		return nil, false
+	} else if d.literalsCount.Load() < maxLiterals {
+		if _, loaded := d.literals.LoadOrStore(hostname, literal); !loaded {
+			d.literalsCount.Add(1)
+		}
+	}
+	if literal.ipv6 {
+		return &retryabledns.DNSData{AAAA: []string{literal.ip}}, true
+	}
+	return &retryabledns.DNSData{A: []string{literal.ip}}, true
+}
+
+// maybeLiteral cheaply discards the hostnames which can't be ip literals
+func maybeLiteral(hostname string) bool {
+	if hostname == "" {
+		return false
+	}
+	c := hostname[0]
+	return c == '[' || c == ':' || '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// parseLiteral classifies the ip literal, the %25 zone separator used by urls is accepted
+func parseLiteral(hostname string) (literalIP, bool) {
+	if strings.HasPrefix(hostname, "[") && strings.HasSuffix(hostname, "]") {
+		hostname = hostname[1 : len(hostname)-1]
+	}
+	hostname = strings.Replace(hostname, "%25", "%", 1)
+	addr, err := netip.ParseAddr(hostname)
+	if err != nil {
+		return literalIP{}, false
+	}
+	if addr.Zone() == "" {
+		addr = addr.Unmap()
+	}
+	return literalIP{ip: addr.String(), ipv6: addr.Is6()}, true
+}
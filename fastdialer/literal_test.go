@@ -0,0 +1,87 @@
+package fastdialer
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+func TestParseLiteral(t *testing.T) {
+	tests := []struct {
+		hostname string
+		want     literalIP
+		ok       bool
+	}{
+		{hostname: "10.0.0.1", want: literalIP{ip: "10.0.0.1"}, ok: true},
+		{hostname: "[10.0.0.1]", want: literalIP{ip: "10.0.0.1"}, ok: true},
+		{hostname: "::ffff:10.0.0.1", want: literalIP{ip: "10.0.0.1"}, ok: true},
+		{hostname: "::1", want: literalIP{ip: "::1", ipv6: true}, ok: true},
+		{hostname: "[::1]", want: literalIP{ip: "::1", ipv6: true}, ok: true},
+		{hostname: "fe80::1%eth0", want: literalIP{ip: "fe80::1%eth0", ipv6: true}, ok: true},
+		{hostname: "[fe80::1%eth0]", want: literalIP{ip: "fe80::1%eth0", ipv6: true}, ok: true},
+		{hostname: "[fe80::1%25en0]", want: literalIP{ip: "fe80::1%en0", ipv6: true}, ok: true},
+		{hostname: "example.com"},
+		{hostname: "[example.com]"},
+		{hostname: "10.0.0"},
+	}
+	for _, tt := range tests {
+		literal, ok := parseLiteral(tt.hostname)
+		require.Equal(t, tt.ok, ok, tt.hostname)
+		require.Equal(t, tt.want, literal, tt.hostname)
+	}
+	require.False(t, maybeLiteral("www.example.com"))
+	require.False(t, maybeLiteral(""))
+}
+
+// countingCache counts the lookups of the wrapped cache
+type countingCache struct {
+	Cache
+	gets int32
+}
+
+func (c *countingCache) Get(key string) ([]byte, bool) {
+	atomic.AddInt32(&c.gets, 1)
+	return c.Cache.Get(key)
+}
+
+func TestLiteralSkipsCache(t *testing.T) {
+	attempts := &recordingProxy{}
+	var tunnelDialer proxy.Dialer = attempts
+	options := DefaultOptions
+	options.ProxyDialer = &tunnelDialer
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"10.0.0.1"}, nil)})
+	cache := &countingCache{Cache: fd.hm}
+	fd.hm = cache
+
+	for i := 0; i < 2; i++ {
+		for _, address := range []string{"10.0.0.2:80", "[fd00::2]:80"} {
+			_, err := fd.Dial(context.Background(), "tcp", address)
+			require.ErrorIs(t, err, CouldNotConnectError, address)
+		}
+		data, err := fd.GetDNSData("10.0.0.2")
+		require.Nil(t, err)
+		require.Equal(t, []string{"10.0.0.2"}, data.A)
+	}
+	require.Zero(t, atomic.LoadInt32(&cache.gets))
+	require.Equal(t, []string{"10.0.0.2:80", "[fd00::2]:80", "10.0.0.2:80", "[fd00::2]:80"}, attempts.addresses)
+
+	_, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&cache.gets))
+}
+
+func BenchmarkLiteralDNSData(b *testing.B) {
+	fd, err := NewDialer(DefaultOptions)
+	require.Nil(b, err)
+	defer fd.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := fd.GetDNSData("192.168.1.1"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package fastdialer
+
+import (
+	"context"
+	"net"
+)
+
+// ocspResponder is implemented by tls, ztls and utls connections
+type ocspResponder interface {
+	OCSPResponse() []byte
+}
+
+// GetOCSPResponse performs a tls handshake with the address and returns the
+// raw stapled ocsp response, nil if the server didn't staple any
+func (d *Dialer) GetOCSPResponse(ctx context.Context, address string) ([]byte, error) {
+	conn, err := d.DialTLS(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return getOCSPResponse(conn), nil
+}
+
+// getOCSPResponse returns the stapled ocsp response of a tls connection
+func getOCSPResponse(conn net.Conn) []byte {
+	if responder, ok := dialedConn(conn).(ocspResponder); ok {
+		return responder.OCSPResponse()
+	}
+	return nil
+}
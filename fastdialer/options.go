@@ -1,9 +1,15 @@
 package fastdialer
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
 	"net"
+	"syscall"
 	"time"
 
+	"github.com/boss-net/hmap/store/hybrid"
+	"github.com/miekg/dns"
 	"golang.org/x/net/proxy"
 )
 
@@ -30,28 +36,118 @@ const (
 	Pogreb
 )
 
+// FallbackCondition defines when the syscall resolver is used as fallback
+type FallbackCondition uint8
+
+const (
+	// FallbackOnError falls back when the primary resolver returns an error (default)
+	FallbackOnError FallbackCondition = 1 << iota
+	// FallbackOnEmpty falls back when the primary resolver returns no A/AAAA records
+	FallbackOnEmpty
+)
+
+// FallbackRecordType restricts which records are taken from the syscall fallback
+type FallbackRecordType uint8
+
+const (
+	// FallbackAll keeps both the A and AAAA records of the syscall fallback (default)
+	FallbackAll FallbackRecordType = iota
+	// FallbackA keeps only the A records of the syscall fallback
+	FallbackA
+	// FallbackAAAA keeps only the AAAA records of the syscall fallback
+	FallbackAAAA
+)
+
 type Options struct {
-	BaseResolvers       []string
-	MaxRetries          int
-	HostsFile           bool
-	ResolversFile       bool
-	EnableFallback      bool
-	Allow               []string
-	Deny                []string
-	CacheType           CacheType
-	CacheMemoryMaxItems int // used by Memory cache type
-	DiskDbType          DiskDBType
-	WithDialerHistory   bool
-	WithCleanup         bool
-	WithTLSData         bool
-	DialerTimeout       time.Duration
-	DialerKeepAlive     time.Duration
-	Dialer              *net.Dialer
-	ProxyDialer         *proxy.Dialer
-	WithZTLS            bool
-	SNIName             string
-	OnDialCallback      func(hostname, IP string)
-	DisableZtlsFallback bool
+	BaseResolvers                []string
+	MaxRetries                   int
+	HostsFile                    bool
+	ResolveLocalhost             bool // resolve localhost and *.localhost to loopback without querying
+	ResolveOnly                  bool // the dialer is only used to resolve, dials fail with ErrDialingDisabled
+	NoIPv6                       bool // drop AAAA records and never connect to ipv6 addresses
+	FilterBlockedIPs             bool // GetDNSData drops the addresses denied by the current network policy, cached entries included
+	MaxRecords                   int  // caps the A and AAAA records kept from an answer, A first, unlimited when zero
+	SortIPs                      bool // sorts the resolved A and AAAA records numerically for a deterministic dial order
+	ResolversFile                bool
+	SortResolversByRTT           bool           // queries the resolvers by increasing round trip time, see ResolverStats
+	DNSForceTCP                  bool           // query udp resolvers over tcp, truncated udp responses are always retried over tcp
+	DoTResolvers                 []string       // dns over tls resolvers as tls://host[:port], the plain ones are queried if they fail
+	DoTServerName                string         // verifies the certificates of DoTResolvers against the name, unverified when empty
+	BootstrapResolvers           []string       // plain resolvers only used to resolve the hostnames of the doh and dot resolvers
+	BootstrapRootCAs             *x509.CertPool // verifies the bootstrapped resolvers against their hostname, the system roots when nil
+	DNSQueryHook                 func(*dns.Msg) // inspects or modifies the A and AAAA queries before they are sent
+	EDNSPadding                  bool           // pads the dns queries to a block boundary (rfc 8467), meant for encrypted resolvers
+	RandomizeQueries             bool           // randomizes the query name case (0x20) and rejects answers not matching it
+	ConcurrentFamilyResolve      bool           // sends the A and AAAA queries concurrently, the records of the one answering are kept if the other fails
+	EnableFallback               bool
+	FallbackCondition            FallbackCondition  // defaults to FallbackOnError
+	FallbackRecordType           FallbackRecordType // defaults to FallbackAll
+	ConcurrentSyscallFallback    bool               // races the syscall resolver with the primary one instead of waiting for it to fail
+	Allow                        []string
+	Deny                         []string
+	AllowFile                    string   // file with one allowed ip, cidr or hostname per line
+	DenyFile                     string   // file with one denied ip, cidr or hostname per line
+	AllowDomains                 []string // only these domains are resolved, *.example.com matches subdomains
+	DenyDomains                  []string // domains never resolved, *.example.com matches subdomains
+	Cache                        Cache    // replaces the hybrid map cache, it's not closed with the dialer
+	CacheType                    CacheType
+	RespectTTL                   bool          // cached dns data is resolved again once the record ttl elapsed
+	ServeStaleOnError            bool          // expired cached data is used when the resolution fails
+	MinCacheTTL                  time.Duration // with RespectTTL, lower bound of the cached dns data lifetime
+	MaxCacheTTL                  time.Duration // upper bound of the cached dns data lifetime, zero keeps the record ttl. Applies without RespectTTL too
+	CacheMemoryMaxItems          int           // used by Memory cache type
+	L1CacheSize                  int           // entries of an in-memory lru consulted before the cache, zero disables it
+	CacheNamespace               string        // default namespace of the cache entries, see WithCacheNamespace
+	DiskDbType                   DiskDBType
+	HybridOptions                *hybrid.Options
+	WithDialerHistory            bool
+	DialHistoryInMemory          bool // keeps the dialer history in memory instead of on disk
+	DisableDialHistory           bool // overrides WithDialerHistory, the history getters return nothing
+	WithCleanup                  bool
+	WithTLSData                  bool
+	WithCoalescingData           bool // records the ip and certificate names of tls dials for CoalesceKey
+	DialerTimeout                time.Duration
+	DialerKeepAlive              time.Duration
+	MaxDialDuration              time.Duration // bounds resolution and connection of a whole dial
+	MaxConnDuration              time.Duration // closes the returned connections once elapsed, zero disables it
+	MaxConnBytes                 int64         // bytes read and written on a returned connection before it's closed, zero disables it
+	FallbackDelay                time.Duration // used by the default net.Dialer, only for the hostnames it resolves itself
+	HappyEyeballsDelay           time.Duration // races plain dials to the resolved ips when not proxied, zero dials them in order
+	DialStrategy                 DialStrategy  // orders and races the dials to the resolved ips, overrides HappyEyeballsDelay
+	AdaptiveSelection            bool          // dials first the ips succeeding the most, the 10000 last dialed are tracked, see AdaptiveStrategy. Overrides HappyEyeballsDelay
+	ConnectRetries               int           // additional connection attempts to an ip failing to connect, not applied to proxies
+	ConnectBackoff               time.Duration // waited before the first retry, doubled before each following one
+	RetryJitter                  float64       // fraction of the backoffs randomly added or removed, 0 disables it
+	ResolveRetryOnTimeout        int           // additional resolutions after the resolvers timed out, on top of the MaxRetries of each
+	Dialer                       *net.Dialer
+	Control                      func(network, address string, c syscall.RawConn) error // runs after the source port and bind address controls, not applied to ztls connections
+	ConnWrappers                 []func(net.Conn) net.Conn                              // applied in order to the returned connections, wrapped tls ones keep ConnectionState
+	AddressRewriter              func(hostname, ip, port string) (newIP, newPort string)
+	ServiceMap                   map[string]string // hostnames dialed to an ip[:port] without resolving them, WithForcedIP and tcp4/tcp6 still apply
+	SourcePortRange              [2]int            // inclusive range of local ports to dial from, disabled when zero
+	BindAddresses                []string          // local ips the sockets are bound to in turn, per address family
+	TCPNoDelay                   *bool             // overrides the go default (enabled), not applied to ztls connections
+	ReadBufferSize               int               // socket receive buffer size, not applied to ztls connections
+	WriteBufferSize              int               // socket send buffer size, not applied to ztls connections
+	SendProxyProtocol            int               // PROXY protocol header version, 1 or 2, sent on direct tcp connections before any data
+	ProxyDialer                  *proxy.Dialer
+	ProxyChain                   []string // proxy urls tunneled through in order, the first one is reached via ProxyDialer if set
+	ProxyTLSFallbackFingerprints []string // attempted in order when the tls handshake through the proxy fails
+	ProxyDNS                     bool     // proxied dials send the hostname to the proxy to resolve, ip based policies can't apply. Set by socks5h chains
+	WithZTLS                     bool
+	SNIName                      string
+	SNIMap                       map[string]string                        // server names by dialed hostname, take precedence over SNIName
+	Renegotiation                tls.RenegotiationSupport                 // used by the tls configs built by the dialer, provided ones keep their own. RenegotiateNever by default
+	DefaultTLSConfig             *tls.Config                              // replaces the config built by the dialer for DialTLS, the server name is still set per host
+	ECHConfigList                []byte                                   // used by standard tls only, requires go1.23+
+	VerifyConnection             func(tls.ConnectionState) error          // used by standard tls and utls, ztls dials fail with VerifyNotSupportedError
+	TLSSessionCacheSize          int                                      // enables standard tls session resumption when positive
+	WarmTLSConcurrency           int                                      // parallel handshakes of WarmTLS, defaults to 10
+	OnDialCallback               func(hostname, IP string)                // the ip is empty for hostnames resolved by the proxy
+	OnDNSChange                  func(hostname string, old, new []string) // refreshed entries resolving to a different address set, see RespectTTL
+	OnDNSResolve                 func(hostname string, a, aaaa int)       // records of each fresh resolution, cache hits aren't reported
+	DisableZtlsFallback          bool
+	Logger                       *log.Logger // receives the warnings of the dialer, nothing is logged when nil
 }
 
 // DefaultOptions of the cache
@@ -63,4 +159,12 @@ var DefaultOptions = Options{
 	CacheType:       Disk,
 	DialerTimeout:   10 * time.Second,
 	DialerKeepAlive: 10 * time.Second,
+	RetryJitter:     0.2,
+}
+
+// warnf writes a warning to the logger, if any
+func (o *Options) warnf(format string, args ...interface{}) {
+	if o.Logger != nil {
+		o.Logger.Printf("[WRN] fastdialer: "+format, args...)
+	}
 }
@@ -0,0 +1,79 @@
+package fastdialer
+
+import (
+	"fmt"
+
+	retryabledns "github.com/boss-net/retryabledns"
+	"github.com/projectdiscovery/networkpolicy"
+)
+
+// IsAllowed checks if the network policy allows dialing the ip, without performing any dial
+func (d *Dialer) IsAllowed(ip string) bool {
+	return d.policy().Validate(withoutZone(unmapIPv4(ip)))
+}
+
+// PolicyRules returns the allow and deny lists of the network policy, including the
+// entries loaded from Options.AllowFile and Options.DenyFile
+func (d *Dialer) PolicyRules() (allow, deny []string) {
+	d.policyMu.RLock()
+	defer d.policyMu.RUnlock()
+	allow = append(allow, d.policyRules.AllowList...)
+	deny = append(deny, d.policyRules.DenyList...)
+	return allow, deny
+}
+
+// SetPolicyRules replaces the allow and deny lists of the network policy, including the entries
+// loaded from the policy files. The following dials and, with Options.FilterBlockedIPs, the
+// dns data returned by GetDNSData follow the new rules
+func (d *Dialer) SetPolicyRules(allow, deny []string) error {
+	for _, entry := range append(append([]string(nil), allow...), deny...) {
+		if !isValidPolicyEntry(entry) {
+			return fmt.Errorf("%w: %s", InvalidPolicyEntryError, entry)
+		}
+	}
+	d.policyMu.Lock()
+	defer d.policyMu.Unlock()
+	rules := d.policyRules
+	rules.AllowList = append([]string(nil), allow...)
+	rules.DenyList = append([]string(nil), deny...)
+	np, err := networkpolicy.New(rules)
+	if err != nil {
+		return err
+	}
+	d.networkpolicy, d.policyRules = np, rules
+	return nil
+}
+
+// policy returns the current network policy
+func (d *Dialer) policy() *networkpolicy.NetworkPolicy {
+	d.policyMu.RLock()
+	defer d.policyMu.RUnlock()
+	return d.networkpolicy
+}
+
+// filterBlockedIPs drops the addresses denied by the network policy from a copy of the dns data,
+// the cached entry keeps them so that it follows later policy changes
+func (d *Dialer) filterBlockedIPs(data *retryabledns.DNSData) (*retryabledns.DNSData, error) {
+	np := d.policy()
+	var blocked []string
+	filter := func(ips []string) []string {
+		var allowed []string
+		for _, ip := range ips {
+			if np.Validate(withoutZone(unmapIPv4(ip))) {
+				allowed = append(allowed, ip)
+			} else {
+				blocked = append(blocked, ip)
+			}
+		}
+		return allowed
+	}
+	dataCopy := *data
+	dataCopy.A, dataCopy.AAAA = filter(data.A), filter(data.AAAA)
+	switch {
+	case len(blocked) == 0:
+		return data, nil
+	case len(dataCopy.A)+len(dataCopy.AAAA) == 0:
+		return nil, &BlockedError{Hostname: data.Host, IPs: blocked}
+	}
+	return &dataCopy, nil
+}
@@ -0,0 +1,71 @@
+package fastdialer
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsAllowed(t *testing.T) {
+	options := DefaultOptions
+	options.Deny = []string{"10.0.0.0/8", "192.168.1.1"}
+	options.DenyFile = writePolicyFile(t, "172.16.0.0/12\n")
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer(nil, nil)})
+
+	require.False(t, fd.IsAllowed("10.1.2.3"))
+	require.False(t, fd.IsAllowed("::ffff:10.1.2.3"))
+	require.False(t, fd.IsAllowed("192.168.1.1"))
+	require.False(t, fd.IsAllowed("172.16.0.1"))
+	require.True(t, fd.IsAllowed("192.168.1.2"))
+
+	allow, deny := fd.PolicyRules()
+	require.Empty(t, allow)
+	require.Equal(t, []string{"10.0.0.0/8", "192.168.1.1", "172.16.0.0/12"}, deny)
+
+	options = DefaultOptions
+	options.Allow = []string{"127.0.0.0/8"}
+	fd = newTestDialer(t, options, &mockResolver{resolve: staticAnswer(nil, nil)})
+
+	require.True(t, fd.IsAllowed("127.0.0.1"))
+	require.False(t, fd.IsAllowed("8.8.8.8"))
+
+	allow, deny = fd.PolicyRules()
+	require.Equal(t, []string{"127.0.0.0/8"}, allow)
+	require.Empty(t, deny)
+}
+
+func TestFilterBlockedIPs(t *testing.T) {
+	resolver := &mockResolver{resolve: staticAnswer([]string{"10.0.0.1", "192.168.1.1"}, []string{"fd00::1"})}
+	options := DefaultOptions
+	options.FilterBlockedIPs = true
+	fd := newTestDialer(t, options, resolver)
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1", "192.168.1.1"}, data.A)
+
+	// the cached entry follows the tightened policy without being resolved again
+	require.Nil(t, fd.SetPolicyRules(nil, []string{"10.0.0.0/8", "fd00::/8"}))
+	allow, deny := fd.PolicyRules()
+	require.Empty(t, allow)
+	require.Equal(t, []string{"10.0.0.0/8", "fd00::/8"}, deny)
+	data, err = fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"192.168.1.1"}, data.A)
+	require.Empty(t, data.AAAA)
+	require.Equal(t, int32(1), atomic.LoadInt32(&resolver.resolveCalls))
+	cached, err := fd.GetDNSDataFromCache("example.com")
+	require.Nil(t, err)
+	require.Len(t, cached.A, 2)
+
+	// hosts with no allowed address left are blocked
+	require.Nil(t, fd.SetPolicyRules(nil, []string{"0.0.0.0/0", "::/0"}))
+	_, err = fd.GetDNSData("example.com")
+	require.ErrorIs(t, err, ErrAllBlocked)
+	_, err = fd.Dial(context.Background(), "tcp", "example.com:80")
+	require.ErrorIs(t, err, ErrAllBlocked)
+
+	require.ErrorIs(t, fd.SetPolicyRules([]string{"10.0.0.0/33"}, nil), InvalidPolicyEntryError)
+}
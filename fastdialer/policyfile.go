@@ -0,0 +1,56 @@
+package fastdialer
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/dimchansky/utfbom"
+)
+
+// loadPolicyFile reads one ip, cidr or hostname pattern per line. Text after # is ignored
+// and malformed entries are skipped with a warning
+func loadPolicyFile(path string, warnf func(format string, args ...interface{})) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(utfbom.SkipOnly(file))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		entry := scanner.Text()
+		if index := strings.Index(entry, commentChar); index >= 0 {
+			entry = entry[:index]
+		}
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !isValidPolicyEntry(entry) {
+			warnf("skipping malformed entry %q at %s:%d", entry, path, lineNumber)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// isValidPolicyEntry checks if the entry is accepted by the network policy
+func isValidPolicyEntry(entry string) bool {
+	if net.ParseIP(entry) != nil {
+		return true
+	}
+	if _, _, err := net.ParseCIDR(entry); err == nil {
+		return true
+	}
+	// looks like a cidr but isn't a valid one
+	if strings.Contains(entry, "/") {
+		return false
+	}
+	_, err := regexp.Compile(entry)
+	return err == nil
+}
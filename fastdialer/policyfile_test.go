@@ -0,0 +1,52 @@
+package fastdialer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writePolicyFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.txt")
+	require.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestDenyFile(t *testing.T) {
+	options := DefaultOptions
+	options.DenyFile = writePolicyFile(t, `# internal ranges
+10.0.0.0/8
+192.168.1.1 # router
+
+.*\.internal\.example\.com
+300.0.0.0/8
+(unclosed
+`)
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"10.1.2.3"}, nil)})
+
+	require.False(t, fd.networkpolicy.Validate("10.1.2.3"))
+	require.False(t, fd.networkpolicy.Validate("192.168.1.1"))
+	require.False(t, fd.networkpolicy.Validate("db.internal.example.com"))
+	require.True(t, fd.networkpolicy.Validate("192.168.1.2"))
+	require.True(t, fd.networkpolicy.Validate("example.com"))
+
+	_, err := fd.Dial(context.Background(), "tcp", "example.com:80")
+	require.ErrorIs(t, err, ErrAllBlocked)
+}
+
+func TestAllowFile(t *testing.T) {
+	options := DefaultOptions
+	options.AllowFile = writePolicyFile(t, "127.0.0.1\n")
+	fd := newTestDialer(t, options, &mockResolver{})
+
+	require.True(t, fd.networkpolicy.Validate("127.0.0.1"))
+	require.False(t, fd.networkpolicy.Validate("10.0.0.1"))
+
+	options.AllowFile = filepath.Join(t.TempDir(), "missing.txt")
+	_, err := NewDialer(options)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,58 @@
+package fastdialer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"strings"
+
+	retryabledns "github.com/boss-net/retryabledns"
+	"github.com/dimchansky/utfbom"
+)
+
+// PreloadCacheFile seeds the dns cache from a file with one "hostname ip[,ip...]" entry per line.
+// Text after # is ignored, invalid ips and lines without a valid one are skipped. The entries are
+// stored in the Options.CacheNamespace namespace
+func (d *Dialer) PreloadCacheFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(utfbom.SkipOnly(file))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if index := strings.Index(line, commentChar); index >= 0 {
+			line = line[:index]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		hostname := asAscii(fields[0])
+		data := &retryabledns.DNSData{Host: hostname}
+		for _, ip := range strings.Split(fields[1], ",") {
+			parsed := net.ParseIP(ip)
+			switch {
+			case parsed == nil:
+			case parsed.To4() != nil:
+				data.A = append(data.A, unmapIPv4(ip))
+			default:
+				data.AAAA = append(data.AAAA, ip)
+			}
+		}
+		if len(data.A)+len(data.AAAA) == 0 {
+			continue
+		}
+		b, err := data.Marshal()
+		if err != nil {
+			return err
+		}
+		if err := d.hm.Set(d.cacheKey(context.Background(), hostname), b); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
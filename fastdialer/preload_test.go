@@ -0,0 +1,44 @@
+package fastdialer
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreloadCacheFile(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "cache.txt")
+	content := `# exported from a previous run
+app.example.com 127.0.0.1,::1
+mixed.example.com 10.0.0.1,invalid,fd00::1 # trailing comment
+invalid.example.com not-an-ip
+missing-ips.example.com
+`
+	require.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	resolver := &mockResolver{}
+	fd := newTestDialer(t, DefaultOptions, resolver)
+	require.Nil(t, fd.PreloadCacheFile(path))
+
+	conn, info, err := fd.DialWithInfo(context.Background(), "tcp", net.JoinHostPort("app.example.com", port))
+	require.Nil(t, err)
+	conn.Close()
+	require.True(t, info.FromCache)
+	require.Equal(t, "127.0.0.1", info.IP)
+
+	data, err := fd.GetDNSDataFromCache("mixed.example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, data.A)
+	require.Equal(t, []string{"fd00::1"}, data.AAAA)
+	for _, hostname := range []string{"invalid.example.com", "missing-ips.example.com"} {
+		_, err := fd.GetDNSDataFromCache(hostname)
+		require.ErrorIs(t, err, NoDNSDataError)
+	}
+	require.Zero(t, atomic.LoadInt32(&resolver.resolveCalls))
+}
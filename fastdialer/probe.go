@@ -0,0 +1,120 @@
+package fastdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// TLSProbeResult contains the parameters negotiated during a tls handshake
+type TLSProbeResult struct {
+	NegotiatedProtocol string
+	Version            uint16
+	CipherSuite        uint16
+	PeerCertificates   []*x509.Certificate
+}
+
+// ProbeTLS performs a tls handshake with the address offering the given alpn protocols
+// and returns the negotiated parameters, the connection is closed without sending data
+func (d *Dialer) ProbeTLS(ctx context.Context, address string, nextProtos []string) (*TLSProbeResult, error) {
+	config := &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS10, NextProtos: nextProtos}
+	conn, err := d.DialTLSWithConfig(ctx, "tcp", address, config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	state, ok := connectionState(conn)
+	if !ok {
+		return nil, NoTLSDataError
+	}
+	return &TLSProbeResult{
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		Version:            state.Version,
+		CipherSuite:        state.CipherSuite,
+		PeerCertificates:   state.PeerCertificates,
+	}, nil
+}
+
+// DialTLSState dials the address like DialTLSWithConfig, or DialTLS if config is nil, and returns
+// the established connection along with a copy of its negotiated state. Connections established
+// by the ztls fallback are closed as their state can't be converted
+func (d *Dialer) DialTLSState(ctx context.Context, network, address string, config *tls.Config) (net.Conn, *tls.ConnectionState, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if config == nil {
+		conn, err = d.DialTLS(ctx, network, address)
+	} else {
+		conn, err = d.DialTLSWithConfig(ctx, network, address, config)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	state, ok := connectionState(conn)
+	if !ok {
+		conn.Close()
+		return nil, nil, NoTLSDataError
+	}
+	return conn, &state, nil
+}
+
+// connectionState returns the negotiated state of a tls or utls connection
+func connectionState(conn net.Conn) (tls.ConnectionState, bool) {
+	switch tlsConn := dialedConn(conn).(type) {
+	case *tls.Conn:
+		return tlsConn.ConnectionState(), true
+	case *utls.UConn:
+		return asTLSConnectionState(tlsConn.ConnectionState()), true
+	default:
+		return tls.ConnectionState{}, false
+	}
+}
+
+// TLSVerifyResult is the negotiated state of a connection established without verification,
+// along with the outcome of the verification of its peer certificate chain
+type TLSVerifyResult struct {
+	State          *tls.ConnectionState
+	Valid          bool
+	VerifyError    error // why the chain isn't valid
+	VerifiedChains [][]*x509.Certificate
+}
+
+// DialTLSVerify dials the address like DialTLSState with certificate verification disabled,
+// then verifies the peer chain against the roots, the system ones if nil, and the server name.
+// The connection is returned whether the chain is valid or not
+func (d *Dialer) DialTLSVerify(ctx context.Context, network, address string, roots *x509.CertPool) (net.Conn, *TLSVerifyResult, error) {
+	config := d.defaultTLSConfig()
+	config.InsecureSkipVerify = true
+	conn, state, err := d.DialTLSState(ctx, network, address, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	serverName := state.ServerName
+	if serverName == "" {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			serverName = withoutZone(host)
+		}
+	}
+	result := &TLSVerifyResult{State: state}
+	result.VerifiedChains, result.VerifyError = verifyChain(state.PeerCertificates, serverName, roots)
+	result.Valid = result.VerifyError == nil
+	return conn, result, nil
+}
+
+// verifyChain verifies the leaf certificate for the server name, the following ones being
+// used as intermediates
+func verifyChain(certificates []*x509.Certificate, serverName string, roots *x509.CertPool) ([][]*x509.Certificate, error) {
+	if len(certificates) == 0 {
+		return nil, NoTLSDataError
+	}
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	return certificates[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, DNSName: serverName})
+}
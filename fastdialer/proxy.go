@@ -0,0 +1,130 @@
+package fastdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/boss-net/fastdialer/fastdialer/ja3/impersonate"
+	"golang.org/x/net/proxy"
+)
+
+// newProxyChain returns a dialer tunneling through the proxy urls in order, the first
+// hop is reached through forward and the last one connects to the target
+func newProxyChain(proxyURLs []string, forward proxy.Dialer) (proxy.Dialer, error) {
+	dialer := forward
+	for _, proxyURL := range proxyURLs {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", InvalidProxyChainError, proxyURL, err)
+		}
+		dialer, err = proxy.FromURL(u, dialer)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", InvalidProxyChainError, proxyURL, err)
+		}
+	}
+	return dialer, nil
+}
+
+// resolvesRemotely checks if the last proxy of the chain resolves the hostnames (socks5h)
+func resolvesRemotely(proxyURLs []string) bool {
+	u, err := url.Parse(proxyURLs[len(proxyURLs)-1])
+	return err == nil && u.Scheme == "socks5h"
+}
+
+// dialProxy connects to the address through the proxy dialer
+func (d *Dialer) dialProxy(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := *d.proxyDialer
+	// timeout not working for socks5 proxy dialer
+	// tying to handle it here
+	connectionCh := make(chan net.Conn, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := dialer.Dial(network, address)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		connectionCh <- conn
+	}()
+	// using timer as time.After is not recovered gy GC
+	dialerTime := time.NewTimer(d.options.DialerTimeout)
+	defer dialerTime.Stop()
+	select {
+	case <-dialerTime.C:
+		return nil, fmt.Errorf("timeout after %v", d.options.DialerTimeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case conn := <-connectionCh:
+		return conn, nil
+	case err := <-errCh:
+		return nil, err
+	}
+}
+
+// proxyTunnel is a connection through the proxy keeping track of data sent on it
+type proxyTunnel struct {
+	net.Conn
+	written int32
+}
+
+func (t *proxyTunnel) Write(b []byte) (int, error) {
+	atomic.StoreInt32(&t.written, 1)
+	return t.Conn.Write(b)
+}
+
+// reusable checks if nothing was sent yet, so that a new handshake can be attempted
+func (t *proxyTunnel) reusable() bool {
+	return atomic.LoadInt32(&t.written) == 0
+}
+
+// dialTLSOverProxy performs the tls handshake through a proxy tunnel. If the handshake fails
+// the fallback fingerprints are attempted in order, reusing the tunnel as long as the failed
+// handshake didn't send anything on it, otherwise a new tunnel is established
+func (d *Dialer) dialTLSOverProxy(ctx context.Context, network, address string, tlsconfig *tls.Config, impersonateStrategy impersonate.Strategy, impersonateIdentity *impersonate.Identity, timings *dialTimings) (net.Conn, error) {
+	start := time.Now()
+	conn, err := d.dialProxy(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	timings.connect = time.Since(start)
+	tunnel := &proxyTunnel{Conn: conn}
+	start = time.Now()
+	tlsConn, err := handshakeTLS(ctx, tunnel, tlsconfig, impersonateStrategy, impersonateIdentity)
+	// utls doesn't support ech, so fallbacks would leak the server name
+	if err != nil && len(d.options.ECHConfigList) == 0 {
+		for _, fingerprint := range d.options.ProxyTLSFallbackFingerprints {
+			// validated by NewDialer
+			strategy, identity, parseErr := parseFingerprint(fingerprint)
+			if parseErr != nil {
+				err = parseErr
+				break
+			}
+			if !tunnel.reusable() {
+				tunnel.Close()
+				start = time.Now()
+				conn, err = d.dialProxy(ctx, network, address)
+				if err != nil {
+					return nil, err
+				}
+				timings.connect = time.Since(start)
+				tunnel = &proxyTunnel{Conn: conn}
+			}
+			start = time.Now()
+			tlsConn, err = handshakeTLS(ctx, tunnel, tlsconfig, strategy, identity)
+			if err == nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		tunnel.Close()
+		return nil, err
+	}
+	timings.handshake = time.Since(start)
+	return tlsConn, nil
+}
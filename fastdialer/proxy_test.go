@@ -0,0 +1,239 @@
+package fastdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+// countingProxy is a proxy dialer connecting directly and counting the established tunnels
+type countingProxy struct {
+	tunnels int32
+}
+
+func (p *countingProxy) Dial(network, address string) (net.Conn, error) {
+	atomic.AddInt32(&p.tunnels, 1)
+	return net.Dial(network, address)
+}
+
+func newProxiedTestDialer(t *testing.T, options Options, tunnelDialer proxy.Dialer) *Dialer {
+	options.ProxyDialer = &tunnelDialer
+	return newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+}
+
+func TestDialTLSOverProxyFallback(t *testing.T) {
+	t.Run("tunnel reused after local handshake failure", func(t *testing.T) {
+		address := newTestTLSServer(t, &tls.Config{})
+		tunnels := &countingProxy{}
+		options := DefaultOptions
+		options.ProxyTLSFallbackFingerprints = []string{"chrome"}
+		fd := newProxiedTestDialer(t, options, tunnels)
+
+		// no version satisfies the config, the handshake fails before sending the client hello
+		invalid := &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS12}
+		conn, err := fd.DialTLSWithConfig(context.Background(), "tcp", address, invalid)
+		require.Nil(t, err)
+		conn.Close()
+		require.Equal(t, int32(1), atomic.LoadInt32(&tunnels.tunnels))
+	})
+
+	t.Run("new tunnel after server rejection", func(t *testing.T) {
+		address := newTestTLSServer(t, &tls.Config{MinVersion: tls.VersionTLS13})
+		tunnels := &countingProxy{}
+		options := DefaultOptions
+		options.ProxyTLSFallbackFingerprints = []string{"chrome"}
+		fd := newProxiedTestDialer(t, options, tunnels)
+
+		legacy := &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12}
+		conn, err := fd.DialTLSWithConfig(context.Background(), "tcp", address, legacy)
+		require.Nil(t, err)
+		conn.Close()
+		require.Equal(t, int32(2), atomic.LoadInt32(&tunnels.tunnels))
+	})
+
+	t.Run("invalid fallback", func(t *testing.T) {
+		options := DefaultOptions
+		options.ProxyTLSFallbackFingerprints = []string{"chrom"}
+		_, err := NewDialer(options)
+		require.ErrorIs(t, err, InvalidFingerprintError)
+		require.ErrorIs(t, err, UnknownFingerprintError)
+	})
+
+	t.Run("no fallbacks", func(t *testing.T) {
+		address := newTestTLSServer(t, &tls.Config{MinVersion: tls.VersionTLS13})
+		tunnels := &countingProxy{}
+		fd := newProxiedTestDialer(t, DefaultOptions, tunnels)
+
+		legacy := &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12}
+		_, err := fd.DialTLSWithConfig(context.Background(), "tcp", address, legacy)
+		require.NotNil(t, err)
+		require.Equal(t, int32(1), atomic.LoadInt32(&tunnels.tunnels))
+	})
+}
+
+// socks5Server is a minimal unauthenticated socks5 proxy recording the requested targets
+type socks5Server struct {
+	address string
+	mu      sync.Mutex
+	targets []string
+}
+
+func newSocks5Server(t *testing.T) *socks5Server {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	server := &socks5Server{address: listener.Addr().String()}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handle(conn)
+		}
+	}()
+	return server
+}
+
+func (s *socks5Server) handle(conn net.Conn) {
+	defer conn.Close()
+	// greeting: version, methods count, methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return
+	}
+	// connect request: version, command, reserved, address type
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return
+		}
+		host = string(domain)
+	case 4:
+		ip := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	s.mu.Lock()
+	s.targets = append(s.targets, target)
+	s.mu.Unlock()
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return
+	}
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		upstream.Close()
+	}()
+	_, _ = io.Copy(conn, upstream)
+}
+
+func (s *socks5Server) requested() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.targets...)
+}
+
+func TestProxyChain(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTLSServer(t, &tls.Config{}))
+	require.Nil(t, err)
+	first, second := newSocks5Server(t), newSocks5Server(t)
+	options := DefaultOptions
+	options.ProxyChain = []string{"socks5://" + first.address, "socks5://" + second.address}
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+
+	conn, err := fd.DialTLS(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+	require.Nil(t, err)
+	conn.Close()
+	// the first hop opens the tunnel to the second one, which connects to the resolved target
+	require.Equal(t, []string{second.address}, first.requested())
+	require.Equal(t, []string{net.JoinHostPort("127.0.0.1", port)}, second.requested())
+
+	// the network policy applies to the resolved target
+	options.Deny = []string{"127.0.0.1"}
+	fd = newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+	_, err = fd.Dial(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+	require.ErrorIs(t, err, ErrAllBlocked)
+	require.Len(t, first.requested(), 1)
+
+	options.ProxyChain = []string{"unknown://127.0.0.1:1080"}
+	_, err = NewDialer(options)
+	require.ErrorIs(t, err, InvalidProxyChainError)
+}
+
+func TestProxyDNS(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	target := net.JoinHostPort("localhost", port)
+
+	for _, scheme := range []string{"socks5h", "socks5"} {
+		server := newSocks5Server(t)
+		options := DefaultOptions
+		options.ResolveLocalhost = false
+		options.ProxyChain = []string{scheme + "://" + server.address}
+		options.ProxyDNS = scheme == "socks5"
+		// ip based policies are bypassed
+		options.Deny = []string{"127.0.0.1"}
+		var dialedIPs []string
+		options.OnDialCallback = func(hostname, ip string) { dialedIPs = append(dialedIPs, ip) }
+		resolver := &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)}
+		fd := newTestDialer(t, options, resolver)
+
+		conn, info, err := fd.DialWithInfo(context.Background(), "tcp", target)
+		require.Nil(t, err, scheme)
+		conn.Close()
+		require.Equal(t, []string{target}, server.requested(), scheme)
+		require.Zero(t, atomic.LoadInt32(&resolver.resolveCalls), scheme)
+		// the ip the proxy connected to is unknown
+		require.Empty(t, info.IP, scheme)
+		require.True(t, info.ProxyResolved, scheme)
+		require.Equal(t, []string{""}, dialedIPs, scheme)
+	}
+}
@@ -0,0 +1,105 @@
+package fastdialer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	ztls "github.com/zmap/zcrypto/tls"
+)
+
+// proxyProtocolSignature starts the v2 headers
+var proxyProtocolSignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// connect establishes the tcp or udp connection to the address, followed by the PROXY protocol
+// header of Options.SendProxyProtocol on tcp connections
+func (d *Dialer) connect(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, address)
+	if err != nil || d.options.SendProxyProtocol == 0 || !strings.HasPrefix(network, "tcp") {
+		return conn, err
+	}
+	if err := writeProxyHeader(conn, d.options.SendProxyProtocol); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialZTLS establishes a ztls connection, like ztls.DialWithDialer the handshake is bounded by
+// the dialer timeout and the context deadline
+func (d *Dialer) dialZTLS(ctx context.Context, network, address string, config *ztls.Config) (net.Conn, error) {
+	dialer := d.contextDialer(ctx)
+	if d.options.SendProxyProtocol == 0 {
+		conn, err := ztls.DialWithDialer(dialer, network, address, config)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+	deadline := dialer.Deadline
+	if dialer.Timeout > 0 {
+		if timeout := time.Now().Add(dialer.Timeout); deadline.IsZero() || timeout.Before(deadline) {
+			deadline = timeout
+		}
+	}
+	rawConn, err := d.connect(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if config.ServerName == "" {
+		configCopy := config.Clone()
+		configCopy.ServerName, _, _ = net.SplitHostPort(address)
+		config = configCopy
+	}
+	conn := ztls.Client(rawConn, config)
+	_ = rawConn.SetDeadline(deadline)
+	if err := conn.Handshake(); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	_ = rawConn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// writeProxyHeader sends the PROXY protocol header of the version with the local and remote
+// addresses of the connection
+func writeProxyHeader(conn net.Conn, version int) error {
+	local, ok := conn.LocalAddr().(*net.TCPAddr)
+	remote, ok2 := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !ok2 {
+		return fmt.Errorf("%w: not a tcp connection", InvalidProxyProtocolError)
+	}
+	localIP, remoteIP := local.IP.To4(), remote.IP.To4()
+	family := "TCP4"
+	if localIP == nil || remoteIP == nil {
+		localIP, remoteIP, family = local.IP.To16(), remote.IP.To16(), "TCP6"
+	}
+
+	var header bytes.Buffer
+	switch version {
+	case 1:
+		fmt.Fprintf(&header, "PROXY %s %s %s %d %d\r\n", family, localIP, remoteIP, local.Port, remote.Port)
+	case 2:
+		header.Write(proxyProtocolSignature)
+		// version 2, PROXY command
+		header.WriteByte(0x21)
+		if family == "TCP4" {
+			header.WriteByte(0x11)
+		} else {
+			header.WriteByte(0x21)
+		}
+		_ = binary.Write(&header, binary.BigEndian, uint16(2*len(localIP)+4))
+		header.Write(localIP)
+		header.Write(remoteIP)
+		_ = binary.Write(&header, binary.BigEndian, uint16(local.Port))
+		_ = binary.Write(&header, binary.BigEndian, uint16(remote.Port))
+	default:
+		return fmt.Errorf("%w: version %d", InvalidProxyProtocolError, version)
+	}
+	_, err := conn.Write(header.Bytes())
+	return err
+}
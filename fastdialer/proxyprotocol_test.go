@@ -0,0 +1,157 @@
+package fastdialer
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// proxyHeader is the source and destination read from a PROXY protocol header
+type proxyHeader struct {
+	version  int
+	src, dst string
+}
+
+// readProxyHeader parses a v1 or v2 PROXY protocol header
+func readProxyHeader(r *bufio.Reader) (proxyHeader, error) {
+	signature, err := r.Peek(len(proxyProtocolSignature))
+	if err != nil {
+		return proxyHeader{}, err
+	}
+	if string(signature) == string(proxyProtocolSignature) {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return proxyHeader{}, err
+		}
+		addresses := make([]byte, binary.BigEndian.Uint16(header[14:]))
+		if _, err := io.ReadFull(r, addresses); err != nil {
+			return proxyHeader{}, err
+		}
+		size := 4
+		if header[13] == 0x21 {
+			size = 16
+		}
+		srcPort := binary.BigEndian.Uint16(addresses[2*size:])
+		dstPort := binary.BigEndian.Uint16(addresses[2*size+2:])
+		return proxyHeader{
+			version: 2,
+			src:     net.JoinHostPort(net.IP(addresses[:size]).String(), strconv.Itoa(int(srcPort))),
+			dst:     net.JoinHostPort(net.IP(addresses[size:2*size]).String(), strconv.Itoa(int(dstPort))),
+		}, nil
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return proxyHeader{}, err
+	}
+	// PROXY TCP4 src dst sport dport
+	fields := strings.Fields(line)
+	if len(fields) != 6 || fields[0] != "PROXY" {
+		return proxyHeader{}, io.ErrUnexpectedEOF
+	}
+	return proxyHeader{version: 1, src: net.JoinHostPort(fields[2], fields[4]), dst: net.JoinHostPort(fields[3], fields[5])}, nil
+}
+
+// newProxyProtocolServer accepts connections starting with a PROXY protocol header and sends
+// back the parsed headers, with tls after the header when the config is set
+func newProxyProtocolServer(t *testing.T, config *tls.Config) (string, <-chan proxyHeader) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	headers := make(chan proxyHeader, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				header, err := readProxyHeader(reader)
+				if err != nil {
+					return
+				}
+				headers <- header
+				var stream io.ReadWriter = struct {
+					io.Reader
+					io.Writer
+				}{reader, conn}
+				if config != nil {
+					tlsConn := tls.Server(&bufferedConn{Conn: conn, reader: reader}, config)
+					if tlsConn.Handshake() != nil {
+						return
+					}
+					stream = tlsConn
+				}
+				_, _ = io.Copy(stream, stream)
+			}()
+		}
+	}()
+	return listener.Addr().String(), headers
+}
+
+// bufferedConn reads the connection through the reader holding the bytes after the header
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func TestSendProxyProtocol(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		t.Run("v"+strconv.Itoa(version), func(t *testing.T) {
+			address, headers := newProxyProtocolServer(t, nil)
+			options := DefaultOptions
+			options.SendProxyProtocol = version
+			fd := newTestDialer(t, options, &mockResolver{})
+
+			conn, err := fd.Dial(context.Background(), "tcp", address)
+			require.Nil(t, err)
+			defer conn.Close()
+			header := <-headers
+			require.Equal(t, proxyHeader{version: version, src: conn.LocalAddr().String(), dst: address}, header)
+
+			// the application data follows the header
+			_, err = conn.Write([]byte("hello"))
+			require.Nil(t, err)
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(conn, buf)
+			require.Nil(t, err)
+			require.Equal(t, "hello", string(buf))
+		})
+	}
+}
+
+func TestSendProxyProtocolTLS(t *testing.T) {
+	address, headers := newProxyProtocolServer(t, &tls.Config{Certificates: []tls.Certificate{newTestCertificate(t)}})
+	options := DefaultOptions
+	options.SendProxyProtocol = 2
+	fd := newTestDialer(t, options, &mockResolver{})
+
+	// the header precedes the tls handshake
+	conn, err := fd.DialTLS(context.Background(), "tcp", address)
+	require.Nil(t, err)
+	defer conn.Close()
+	require.Equal(t, conn.LocalAddr().String(), (<-headers).src)
+	conn, err = fd.DialZTLS(context.Background(), "tcp", address)
+	require.Nil(t, err)
+	defer conn.Close()
+	require.Equal(t, conn.LocalAddr().String(), (<-headers).src)
+
+	options.SendProxyProtocol = 3
+	require.ErrorIs(t, options.Validate(), InvalidProxyProtocolError)
+	_, err = NewDialer(options)
+	require.ErrorIs(t, err, InvalidProxyProtocolError)
+}
@@ -0,0 +1,575 @@
+package fastdialer
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	retryabledns "github.com/boss-net/retryabledns"
+	"github.com/miekg/dns"
+)
+
+// dnsResolver is the subset of the retryabledns client used by the dialer
+type dnsResolver interface {
+	Resolve(host string) (*retryabledns.DNSData, error)
+	Do(msg *dns.Msg) (*dns.Msg, error)
+}
+
+// ipAddrResolver is satisfied by *net.Resolver and used for the syscall fallback
+type ipAddrResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// ResolveResponse contains the resolved records along with the response metadata
+type ResolveResponse struct {
+	*retryabledns.DNSData
+	Rcode         int
+	RcodeName     string
+	Authoritative bool
+}
+
+// IsNXDomain checks if the domain doesn't exist
+func (r *ResolveResponse) IsNXDomain() bool {
+	return r.Rcode == dns.RcodeNameError
+}
+
+// IsServFail checks if the resolver failed to answer, which is worth retrying
+func (r *ResolveResponse) IsServFail() bool {
+	return r.Rcode == dns.RcodeServerFailure
+}
+
+// Resolve queries the A and AAAA records of the hostname, bypassing the cache, and returns
+// them with the response code and authoritative flag. Only successful answers with records are cached,
+// in the Options.CacheNamespace namespace
+func (d *Dialer) Resolve(hostname string) (*ResolveResponse, error) {
+	hostname = asAscii(hostname)
+	if err := d.validateDomain(hostname); err != nil {
+		return nil, err
+	}
+	response, err := d.query(hostname)
+	if err != nil {
+		return nil, newResolveError(hostname, nil, ResolveHostError, err)
+	}
+	d.limitRecords(d.sortRecords(unmapRecords(response.DNSData)))
+	if response.Rcode == dns.RcodeSuccess && len(response.A)+len(response.AAAA) > 0 {
+		b, _ := response.DNSData.Marshal()
+		if err := d.hm.Set(d.cacheKey(context.Background(), hostname), b); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// query sends the A and AAAA queries of the hostname, modified by Options.DNSQueryHook if set
+// and padded with Options.EDNSPadding, and merges the answers. With Options.RandomizeQueries
+// the case of the query name is randomized and answers not echoing it are discarded. With
+// Options.ConcurrentFamilyResolve both queries are sent at once, the failure of one isn't fatal
+func (d *Dialer) query(hostname string) (*ResolveResponse, error) {
+	response := &ResolveResponse{DNSData: &retryabledns.DNSData{Host: hostname}, Rcode: -1}
+	var msgs []*dns.Msg
+	for _, requestType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg := &dns.Msg{}
+		msg.SetQuestion(dns.Fqdn(hostname), requestType)
+		if d.options.RandomizeQueries {
+			msg.Question[0].Name = randomizeCase(msg.Question[0].Name)
+		}
+		msg.SetEdns0(4096, false)
+		if d.options.DNSQueryHook != nil {
+			d.options.DNSQueryHook(msg)
+		}
+		if d.options.EDNSPadding {
+			padQuery(msg)
+		}
+		msgs = append(msgs, msg)
+	}
+	resps, errs := d.exchange(msgs)
+	var lastErr error
+	for i, msg := range msgs {
+		resp, err := resps[i], errs[i]
+		if resp == nil {
+			lastErr = err
+			continue
+		}
+		// spoofed responses don't know the case of the query name
+		if d.options.RandomizeQueries && (len(resp.Question) != 1 || resp.Question[0].Name != msg.Question[0].Name) {
+			lastErr = QueryMismatchError
+			continue
+		}
+		// a successful answer takes precedence over failures of the other query
+		if response.Rcode == -1 || (response.Rcode != dns.RcodeSuccess && resp.Rcode == dns.RcodeSuccess) {
+			response.Rcode = resp.Rcode
+			response.Authoritative = resp.Authoritative
+		}
+		if resp.Rcode == dns.RcodeSuccess {
+			_ = response.ParseFromMsg(resp)
+		}
+	}
+	if response.Rcode == -1 {
+		if lastErr == nil {
+			lastErr = ResolveHostError
+		}
+		return nil, lastErr
+	}
+	response.Timestamp = time.Now()
+	response.RcodeName = dns.RcodeToString[response.Rcode]
+	response.StatusCode = response.RcodeName
+	response.StatusCodeRaw = response.Rcode
+	return response, nil
+}
+
+// exchange sends the queries with the dns client, in order or concurrently with
+// Options.ConcurrentFamilyResolve
+func (d *Dialer) exchange(msgs []*dns.Msg) ([]*dns.Msg, []error) {
+	resps := make([]*dns.Msg, len(msgs))
+	errs := make([]error, len(msgs))
+	if !d.options.ConcurrentFamilyResolve {
+		for i, msg := range msgs {
+			resps[i], errs[i] = d.dnsclient.Do(msg)
+		}
+		return resps, errs
+	}
+	var wg sync.WaitGroup
+	for i, msg := range msgs {
+		wg.Add(1)
+		go func(i int, msg *dns.Msg) {
+			defer wg.Done()
+			resps[i], errs[i] = d.dnsclient.Do(msg)
+		}(i, msg)
+	}
+	wg.Wait()
+	return resps, errs
+}
+
+// resolve queries the primary resolver and, if configured and not forbidden by the context, the
+// syscall fallback
+func (d *Dialer) resolve(ctx context.Context, hostname string) (*retryabledns.DNSData, error) {
+	noFallback := noSyscallFallbackFromContext(ctx)
+	if d.options.ConcurrentSyscallFallback && !noFallback {
+		data, err := d.raceSyscall(ctx, hostname)
+		return d.limitRecords(d.sortRecords(unmapRecords(data))), err
+	}
+	data, err := d.resolvePrimary(hostname)
+	if noFallback || !d.shouldFallback(data, err) {
+		return d.limitRecords(d.sortRecords(unmapRecords(data))), err
+	}
+	data, err = d.resolveFallback(ctx, hostname)
+	if err != nil {
+		return nil, err
+	}
+	return d.limitRecords(d.sortRecords(unmapRecords(data))), nil
+}
+
+// resolvePrimary resolves the hostname with the dns client, following cname only answers
+func (d *Dialer) resolvePrimary(hostname string) (*retryabledns.DNSData, error) {
+	var (
+		data *retryabledns.DNSData
+		err  error
+	)
+	if d.options.DNSQueryHook != nil || d.options.EDNSPadding || d.options.RandomizeQueries || d.options.ConcurrentFamilyResolve {
+		// the client builds its own queries, send ours to let them be modified
+		var response *ResolveResponse
+		if response, err = d.query(hostname); err == nil {
+			data = response.DNSData
+		}
+	} else {
+		data, err = d.dnsclient.Resolve(hostname)
+		err = d.partialResolveError(hostname, data, err)
+	}
+	if err == nil && isCNAMEOnly(data) {
+		data, err = d.followCNAME(data)
+	}
+	return data, err
+}
+
+// resolveFallback resolves the hostname with the syscall resolver keeping the records
+// of Options.FallbackRecordType
+func (d *Dialer) resolveFallback(ctx context.Context, hostname string) (*retryabledns.DNSData, error) {
+	data, err := d.resolveWithSyscall(ctx, hostname)
+	if err != nil {
+		return nil, err
+	}
+	switch d.options.FallbackRecordType {
+	case FallbackA:
+		data.AAAA = nil
+	case FallbackAAAA:
+		data.A = nil
+	}
+	return data, nil
+}
+
+// raceSyscall resolves the hostname with the dns client and the syscall resolver concurrently
+// and returns the first answer with records. If neither has any, the primary outcome is returned
+func (d *Dialer) raceSyscall(ctx context.Context, hostname string) (*retryabledns.DNSData, error) {
+	type result struct {
+		data    *retryabledns.DNSData
+		err     error
+		primary bool
+	}
+	// the syscall lookup is canceled once the primary resolver answers first
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan result, 2)
+	go func() {
+		data, err := d.resolvePrimary(hostname)
+		results <- result{data: data, err: err, primary: true}
+	}()
+	go func() {
+		data, err := d.resolveFallback(ctx, hostname)
+		results <- result{data: data, err: err}
+	}()
+	var primary result
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if r.err == nil && r.data != nil && len(r.data.A)+len(r.data.AAAA) > 0 {
+			return r.data, nil
+		}
+		if r.primary {
+			primary = r
+		}
+	}
+	return primary.data, primary.err
+}
+
+// randomizeCase flips the case of the letters of the name at random (dns 0x20 encoding),
+// adding entropy to the query on top of its random id and source port
+func randomizeCase(name string) string {
+	bits := make([]byte, len(name))
+	if _, err := rand.Read(bits); err != nil {
+		return name
+	}
+	randomized := []byte(name)
+	for i, c := range randomized {
+		if bits[i]&1 == 0 {
+			continue
+		}
+		switch {
+		case 'a' <= c && c <= 'z':
+			randomized[i] = c - 'a' + 'A'
+		case 'A' <= c && c <= 'Z':
+			randomized[i] = c - 'A' + 'a'
+		}
+	}
+	return string(randomized)
+}
+
+// paddingBlockSize is the query block length recommended by rfc 8467
+const paddingBlockSize = 128
+
+// padQuery adds an edns0 padding option (rfc 7830) extending the query to a multiple of the block size
+func padQuery(msg *dns.Msg) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		msg.SetEdns0(4096, false)
+		opt = msg.IsEdns0()
+	}
+	padding := &dns.EDNS0_PADDING{}
+	opt.Option = append(opt.Option, padding)
+	if remainder := msg.Len() % paddingBlockSize; remainder != 0 {
+		padding.Padding = make([]byte, paddingBlockSize-remainder)
+	}
+}
+
+// sortRecords orders the A and AAAA records numerically with Options.SortIPs, so that
+// the dial order doesn't depend on the resolver
+func (d *Dialer) sortRecords(data *retryabledns.DNSData) *retryabledns.DNSData {
+	if data == nil || !d.options.SortIPs {
+		return data
+	}
+	sortIPs(data.A)
+	sortIPs(data.AAAA)
+	return data
+}
+
+// sortIPs sorts the addresses numerically, invalid ones are ordered as strings after the valid ones
+func sortIPs(ips []string) {
+	sort.SliceStable(ips, func(i, j int) bool {
+		first, firstErr := netip.ParseAddr(ips[i])
+		second, secondErr := netip.ParseAddr(ips[j])
+		switch {
+		case firstErr == nil && secondErr == nil:
+			return first.Less(second)
+		case firstErr == nil || secondErr == nil:
+			return firstErr == nil
+		default:
+			return ips[i] < ips[j]
+		}
+	})
+}
+
+// limitRecords drops the addresses beyond Options.MaxRecords, A records are kept first
+func (d *Dialer) limitRecords(data *retryabledns.DNSData) *retryabledns.DNSData {
+	maxRecords := d.options.MaxRecords
+	if data == nil || maxRecords <= 0 || len(data.A)+len(data.AAAA) <= maxRecords {
+		return data
+	}
+	d.options.warnf("truncating %d records of %s to %d", len(data.A)+len(data.AAAA), data.Host, maxRecords)
+	if len(data.A) > maxRecords {
+		data.A = data.A[:maxRecords]
+	}
+	data.AAAA = data.AAAA[:maxRecords-len(data.A)]
+	return data
+}
+
+// partialResolveError drops the error of a resolution which still yielded addresses, as only
+// the query of the other family failed. The error is logged as a warning
+func (d *Dialer) partialResolveError(hostname string, data *retryabledns.DNSData, err error) error {
+	if err == nil || data == nil || len(data.A)+len(data.AAAA) == 0 {
+		return err
+	}
+	d.options.warnf("partial resolution of %s: %s", hostname, err)
+	return nil
+}
+
+// maxCNAMEDepth bounds the cname chain followed by a resolution
+const maxCNAMEDepth = 8
+
+// isCNAMEOnly checks if the answer contains an alias but no address
+func isCNAMEOnly(data *retryabledns.DNSData) bool {
+	return data != nil && len(data.CNAME) > 0 && len(data.A)+len(data.AAAA) == 0
+}
+
+// followCNAME resolves the cname chain left unresolved by non recursive resolvers and returns
+// the addresses of its target along with the whole chain
+func (d *Dialer) followCNAME(data *retryabledns.DNSData) (*retryabledns.DNSData, error) {
+	visited := map[string]struct{}{strings.ToLower(data.Host): {}}
+	current := data
+	for depth := 0; depth < maxCNAMEDepth && isCNAMEOnly(current); depth++ {
+		target := strings.ToLower(strings.TrimSuffix(current.CNAME[len(current.CNAME)-1], "."))
+		if _, ok := visited[target]; ok {
+			break
+		}
+		visited[target] = struct{}{}
+		next, err := d.dnsclient.Resolve(target)
+		if err != nil {
+			return nil, err
+		}
+		data.CNAME = append(data.CNAME, next.CNAME...)
+		current = next
+	}
+	data.A = current.A
+	data.AAAA = current.AAAA
+	return data, nil
+}
+
+// systemResolverName is the resolver recorded in the dns data resolved by the os
+const systemResolverName = "system"
+
+// syscallTTL is the ttl, in seconds, given to the addresses resolved by the os which
+// doesn't report the record ones
+const syscallTTL = 60
+
+// resolveWithSyscall resolves the hostname with the os resolver, the lookup is aborted once the context is done
+func (d *Dialer) resolveWithSyscall(ctx context.Context, hostname string) (*retryabledns.DNSData, error) {
+	addrs, err := d.syscallResolver.LookupIPAddr(ctx, hostname)
+	if err != nil {
+		return nil, err
+	}
+	data := &retryabledns.DNSData{Host: hostname, Resolver: []string{systemResolverName}, TTL: syscallTTL, Timestamp: time.Now()}
+	for _, addr := range addrs {
+		if ipv4 := addr.IP.To4(); ipv4 != nil {
+			data.A = append(data.A, addr.IP.String())
+		} else if ipv6 := addr.IP.To16(); ipv6 != nil {
+			data.AAAA = append(data.AAAA, addr.IP.String())
+		}
+	}
+	return data, nil
+}
+
+// resolveWithContext resolves the hostname returning early once the context is done
+func (d *Dialer) resolveWithContext(ctx context.Context, hostname string) (*retryabledns.DNSData, error) {
+	if ctx.Done() == nil {
+		return d.resolveWithTimeoutRetries(ctx, hostname)
+	}
+	type result struct {
+		data *retryabledns.DNSData
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		data, err := d.resolveWithTimeoutRetries(ctx, hostname)
+		results <- result{data: data, err: err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-results:
+		return r.data, r.err
+	}
+}
+
+// ResolveWith resolves the hostname through the single resolver, given like Options.BaseResolvers
+// or as tls://host[:port] for dns over tls. The cache is neither read nor written and the hosts
+// file, fallbacks and cname following don't apply
+func (d *Dialer) ResolveWith(ctx context.Context, hostname, resolver string) (*retryabledns.DNSData, error) {
+	hostname = asAscii(hostname)
+	if err := d.validateDomain(hostname); err != nil {
+		return nil, err
+	}
+	var client dnsResolver
+	if strings.HasPrefix(resolver, dotScheme) {
+		dotclient, err := newDoTResolvers([]string{resolver}, d.options.DoTServerName, d.options.MaxRetries)
+		if err != nil {
+			return nil, err
+		}
+		client = dotclient
+	} else {
+		resolvers := normalizeResolvers([]string{resolver})
+		if len(resolvers) != 1 || !isValidResolver(resolvers[0]) {
+			return nil, fmt.Errorf("%w: %s", InvalidResolverError, resolver)
+		}
+		if d.options.DNSForceTCP {
+			resolvers = forceTCPResolvers(resolvers)
+		}
+		dnsclient, err := newDNSClient(resolvers, d.options.MaxRetries)
+		if err != nil {
+			return nil, err
+		}
+		client = dnsclient
+	}
+
+	type result struct {
+		data *retryabledns.DNSData
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		data, err := client.Resolve(hostname)
+		results <- result{data: data, err: d.partialResolveError(hostname, data, err)}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-results:
+		if r.err != nil {
+			return nil, newResolveError(hostname, r.data, ResolveHostError, r.err)
+		}
+		return d.limitRecords(d.sortRecords(unmapRecords(r.data))), nil
+	}
+}
+
+// shouldFallback checks if the primary resolution outcome matches the configured fallback condition
+func (d *Dialer) shouldFallback(data *retryabledns.DNSData, err error) bool {
+	if !d.options.EnableFallback {
+		return false
+	}
+	condition := d.options.FallbackCondition
+	if condition == 0 {
+		condition = FallbackOnError
+	}
+	if err != nil {
+		return condition&FallbackOnError != 0
+	}
+	if data == nil || len(data.A)+len(data.AAAA) == 0 {
+		return condition&FallbackOnEmpty != 0
+	}
+	return false
+}
+
+// LookupIPAddr resolves the host through the dns cache and returns its addresses,
+// matching the signature of (*net.Resolver).LookupIPAddr
+func (d *Dialer) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	data, err := d.getDNSData(ctx, host)
+	if err != nil {
+		return nil, newLookupError(host, err)
+	}
+	var addrs []net.IPAddr
+	for _, ip := range append(append([]string{}, data.A...), data.AAAA...) {
+		// zoned literals are kept as is
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			addrs = append(addrs, net.IPAddr{IP: addr.AsSlice(), Zone: addr.Zone()})
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, newLookupError(host, newResolveError(host, data, NoAddressFoundError, nil))
+	}
+	return addrs, nil
+}
+
+// lookupError is the net.DNSError returned by LookupIPAddr, the cause of the failure is
+// still matched by errors.Is and errors.As
+type lookupError struct {
+	*net.DNSError
+	cause error
+}
+
+// newLookupError classifies the resolution failure as the net package does
+func newLookupError(host string, err error) *lookupError {
+	dnsErr := &net.DNSError{Err: err.Error(), Name: host}
+	var netErr net.Error
+	dnsErr.IsTimeout = errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+	dnsErr.IsTemporary = dnsErr.IsTimeout
+	var resolveErr *ResolveError
+	if errors.As(err, &resolveErr) {
+		dnsErr.IsNotFound = resolveErr.Rcode == dns.RcodeNameError || errors.Is(err, ErrNoAddressFound)
+		dnsErr.IsTemporary = resolveErr.Temporary() && !dnsErr.IsNotFound
+	}
+	return &lookupError{DNSError: dnsErr, cause: err}
+}
+
+func (e *lookupError) Unwrap() []error {
+	return []error{e.DNSError, e.cause}
+}
+
+// normalizeResolvers splits comma or space separated resolvers and adds
+// the protocol default port to the ones missing it
+func normalizeResolvers(resolvers []string) []string {
+	var normalized []string
+	for _, value := range resolvers {
+		fields := strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		for _, resolver := range fields {
+			normalized = append(normalized, normalizeResolver(resolver))
+		}
+	}
+	return normalized
+}
+
+// normalizeResolver adds the default port to a [protocol:]host[:port] resolver
+func normalizeResolver(resolver string) string {
+	var protocol string
+	if len(resolver) > 4 && resolver[3] == ':' {
+		switch resolver[:3] {
+		case "doh":
+			// doh resolvers are urls
+			return resolver
+		case "udp", "tcp", "dot":
+			protocol, resolver = resolver[:4], resolver[4:]
+		}
+	}
+	if _, _, err := net.SplitHostPort(resolver); err == nil {
+		return protocol + resolver
+	}
+	port := "53"
+	if protocol == "dot:" {
+		port = "853"
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(resolver, "["), "]")
+	return protocol + net.JoinHostPort(host, port)
+}
+
+// forceTCPResolvers switches the udp resolvers to tcp, encrypted ones are kept as is
+func forceTCPResolvers(resolvers []string) []string {
+	forced := make([]string, 0, len(resolvers))
+	for _, resolver := range resolvers {
+		switch {
+		case strings.HasPrefix(resolver, "tcp:"), strings.HasPrefix(resolver, "dot:"), strings.HasPrefix(resolver, "doh:"):
+		case strings.HasPrefix(resolver, "udp:"):
+			resolver = "tcp:" + strings.TrimPrefix(resolver, "udp:")
+		default:
+			resolver = "tcp:" + resolver
+		}
+		forced = append(forced, resolver)
+	}
+	return forced
+}
@@ -0,0 +1,866 @@
+package fastdialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode"
+
+	retryabledns "github.com/boss-net/retryabledns"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// mockResolver is a dnsResolver returning canned answers
+type mockResolver struct {
+	resolve      func(host string) (*retryabledns.DNSData, error)
+	syscall      func(host string) (*retryabledns.DNSData, error)
+	do           func(msg *dns.Msg) (*dns.Msg, error)
+	resolveCalls int32
+	syscallCalls int32
+}
+
+func (m *mockResolver) Resolve(host string) (*retryabledns.DNSData, error) {
+	atomic.AddInt32(&m.resolveCalls, 1)
+	if m.resolve == nil {
+		return &retryabledns.DNSData{Host: host}, nil
+	}
+	return m.resolve(host)
+}
+
+func (m *mockResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	atomic.AddInt32(&m.syscallCalls, 1)
+	if m.syscall == nil {
+		return nil, nil
+	}
+	data, err := m.syscall(host)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []net.IPAddr
+	for _, ip := range append(append([]string{}, data.A...), data.AAAA...) {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func (m *mockResolver) Do(msg *dns.Msg) (*dns.Msg, error) {
+	atomic.AddInt32(&m.resolveCalls, 1)
+	if m.do == nil {
+		return nil, errors.New("no response")
+	}
+	return m.do(msg)
+}
+
+func staticAnswer(a, aaaa []string) func(string) (*retryabledns.DNSData, error) {
+	return func(host string) (*retryabledns.DNSData, error) {
+		return &retryabledns.DNSData{Host: host, A: a, AAAA: aaaa}, nil
+	}
+}
+
+func failingAnswer(host string) (*retryabledns.DNSData, error) {
+	return nil, errors.New("resolver unreachable")
+}
+
+// newTestDialer returns a memory backed dialer using the given mock resolver
+func newTestDialer(t *testing.T, options Options, resolver *mockResolver) *Dialer {
+	t.Helper()
+	options.HostsFile = false
+	options.ResolversFile = false
+	options.CacheType = Memory
+	fd, err := NewDialer(options)
+	require.Nil(t, err)
+	fd.dnsclient = resolver
+	fd.syscallResolver = resolver
+	t.Cleanup(fd.Close)
+	return fd
+}
+
+func TestResolveFallback(t *testing.T) {
+	syscallAnswer := staticAnswer([]string{"10.0.0.2"}, []string{"fd00::2"})
+
+	tests := []struct {
+		name        string
+		condition   FallbackCondition
+		recordType  FallbackRecordType
+		primary     func(string) (*retryabledns.DNSData, error)
+		wantSyscall bool
+		wantA       []string
+		wantAAAA    []string
+	}{
+		{
+			name:        "default falls back on error",
+			primary:     failingAnswer,
+			wantSyscall: true,
+			wantA:       []string{"10.0.0.2"},
+			wantAAAA:    []string{"fd00::2"},
+		},
+		{
+			name:    "default ignores empty answer",
+			primary: staticAnswer(nil, nil),
+		},
+		{
+			name:        "empty answer falls back",
+			condition:   FallbackOnEmpty,
+			primary:     staticAnswer(nil, nil),
+			wantSyscall: true,
+			wantA:       []string{"10.0.0.2"},
+			wantAAAA:    []string{"fd00::2"},
+		},
+		{
+			name:      "empty condition ignores error",
+			condition: FallbackOnEmpty,
+			primary:   failingAnswer,
+		},
+		{
+			name:        "error and empty conditions combined",
+			condition:   FallbackOnError | FallbackOnEmpty,
+			primary:     failingAnswer,
+			wantSyscall: true,
+			wantA:       []string{"10.0.0.2"},
+			wantAAAA:    []string{"fd00::2"},
+		},
+		{
+			name:        "only A records from fallback",
+			recordType:  FallbackA,
+			primary:     failingAnswer,
+			wantSyscall: true,
+			wantA:       []string{"10.0.0.2"},
+		},
+		{
+			name:        "only AAAA records from fallback",
+			condition:   FallbackOnEmpty,
+			recordType:  FallbackAAAA,
+			primary:     staticAnswer(nil, nil),
+			wantSyscall: true,
+			wantAAAA:    []string{"fd00::2"},
+		},
+		{
+			name:      "successful primary never falls back",
+			condition: FallbackOnError | FallbackOnEmpty,
+			primary:   staticAnswer([]string{"10.0.0.1"}, nil),
+			wantA:     []string{"10.0.0.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &mockResolver{resolve: tt.primary, syscall: syscallAnswer}
+			options := DefaultOptions
+			options.EnableFallback = true
+			options.FallbackCondition = tt.condition
+			options.FallbackRecordType = tt.recordType
+			fd := newTestDialer(t, options, resolver)
+
+			data, err := fd.resolve(context.Background(), "example.com")
+			require.Equal(t, tt.wantSyscall, atomic.LoadInt32(&resolver.syscallCalls) == 1)
+			if !tt.wantSyscall && tt.wantA == nil {
+				// primary outcome is returned as is
+				return
+			}
+			require.Nil(t, err)
+			require.Equal(t, tt.wantA, data.A)
+			require.Equal(t, tt.wantAAAA, data.AAAA)
+		})
+	}
+}
+
+func TestConcurrentSyscallFallback(t *testing.T) {
+	slowAnswer := func(host string) (*retryabledns.DNSData, error) {
+		time.Sleep(time.Second)
+		return &retryabledns.DNSData{Host: host, A: []string{"10.0.0.1"}}, nil
+	}
+	resolver := &mockResolver{resolve: slowAnswer, syscall: staticAnswer([]string{"10.0.0.2"}, nil)}
+	options := DefaultOptions
+	options.ConcurrentSyscallFallback = true
+	fd := newTestDialer(t, options, resolver)
+
+	start := time.Now()
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.2"}, data.A)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// the winning answer is cached
+	data, err = fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.2"}, data.A)
+	require.Equal(t, int32(1), atomic.LoadInt32(&resolver.syscallCalls))
+
+	// the primary answer is used when the syscall resolver fails
+	resolver.syscall = failingAnswer
+	data, err = fd.GetDNSData("example.org")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, data.A)
+}
+
+func TestResolveAllRecords(t *testing.T) {
+	resolver := &mockResolver{resolve: func(host string) (*retryabledns.DNSData, error) {
+		return &retryabledns.DNSData{Host: host, CNAME: []string{"cdn.example.net"}, A: []string{"127.0.0.1"}, AAAA: []string{"::1"}}, nil
+	}}
+	fd := newTestDialer(t, DefaultOptions, resolver)
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"cdn.example.net"}, data.CNAME)
+	require.Equal(t, []string{"127.0.0.1"}, data.A)
+	require.Equal(t, []string{"::1"}, data.AAAA)
+
+	cached, err := fd.GetDNSDataFromCache("example.com")
+	require.Nil(t, err)
+	require.Equal(t, data.CNAME, cached.CNAME)
+	require.Equal(t, data.A, cached.A)
+	require.Equal(t, data.AAAA, cached.AAAA)
+
+	// reads and dials are served by the same entry
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	conn, err := fd.Dial(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+	require.Nil(t, err)
+	conn.Close()
+	_, err = fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&resolver.resolveCalls))
+}
+
+func TestResolveFallbackDisabled(t *testing.T) {
+	resolver := &mockResolver{resolve: failingAnswer}
+	fd := newTestDialer(t, DefaultOptions, resolver)
+
+	_, err := fd.GetDNSData("example.com")
+	require.NotNil(t, err)
+	require.Zero(t, atomic.LoadInt32(&resolver.syscallCalls))
+}
+
+func TestWithNoSyscallFallback(t *testing.T) {
+	for _, concurrent := range []bool{false, true} {
+		resolver := &mockResolver{resolve: failingAnswer, syscall: staticAnswer([]string{"10.0.0.2"}, nil)}
+		options := DefaultOptions
+		options.EnableFallback = true
+		options.ConcurrentSyscallFallback = concurrent
+		fd := newTestDialer(t, options, resolver)
+
+		_, err := fd.GetDNSDataContext(WithNoSyscallFallback(context.Background()), "example.com")
+		require.NotNil(t, err)
+		require.Zero(t, atomic.LoadInt32(&resolver.syscallCalls))
+
+		// the other calls still fall back
+		data, err := fd.GetDNSData("example.com")
+		require.Nil(t, err)
+		require.Equal(t, []string{"10.0.0.2"}, data.A)
+		require.Equal(t, int32(1), atomic.LoadInt32(&resolver.syscallCalls))
+	}
+}
+
+// firstAddr mimics a component consuming a net.Resolver compatible lookup
+func firstAddr(ctx context.Context, lookuper ipAddrResolver, host string) (string, error) {
+	addrs, err := lookuper.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	return addrs[0].String(), nil
+}
+
+func TestLookupIPAddr(t *testing.T) {
+	var _ ipAddrResolver = net.DefaultResolver
+
+	resolver := &mockResolver{resolve: staticAnswer([]string{"10.0.0.1"}, []string{"fd00::1"})}
+	fd := newTestDialer(t, DefaultOptions, resolver)
+
+	addrs, err := fd.LookupIPAddr(context.Background(), "example.com")
+	require.Nil(t, err)
+	require.Equal(t, []net.IPAddr{{IP: net.ParseIP("10.0.0.1").To4()}, {IP: net.ParseIP("fd00::1")}}, addrs)
+
+	addr, err := firstAddr(context.Background(), fd, "example.com")
+	require.Nil(t, err)
+	require.Equal(t, "10.0.0.1", addr)
+	// second lookup is served from cache
+	require.Equal(t, int32(1), atomic.LoadInt32(&resolver.resolveCalls))
+
+	noRecords := newTestDialer(t, DefaultOptions, &mockResolver{})
+	_, err = firstAddr(context.Background(), noRecords, "example.com")
+	var dnsErr *net.DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	require.True(t, dnsErr.IsNotFound)
+
+	// the zone of ipv6 literals is kept
+	addrs, err = fd.LookupIPAddr(context.Background(), "fe80::1%lo")
+	require.Nil(t, err)
+	require.Equal(t, []net.IPAddr{{IP: net.ParseIP("fe80::1"), Zone: "lo"}}, addrs)
+
+	// the cause and the classification of the failures are kept
+	nxdomain := newTestDialer(t, DefaultOptions, &mockResolver{resolve: func(host string) (*retryabledns.DNSData, error) {
+		return &retryabledns.DNSData{Host: host, StatusCode: "NXDOMAIN", StatusCodeRaw: dns.RcodeNameError}, nil
+	}})
+	_, err = nxdomain.LookupIPAddr(context.Background(), "missing.example.com")
+	require.ErrorAs(t, err, &dnsErr)
+	require.True(t, dnsErr.IsNotFound)
+	require.False(t, dnsErr.IsTemporary)
+	require.ErrorIs(t, err, ErrNoAddressFound)
+	var resolveErr *ResolveError
+	require.ErrorAs(t, err, &resolveErr)
+	require.Equal(t, dns.RcodeNameError, resolveErr.Rcode)
+
+	failing := newTestDialer(t, DefaultOptions, &mockResolver{resolve: failingAnswer})
+	_, err = failing.LookupIPAddr(context.Background(), "example.com")
+	require.ErrorAs(t, err, &dnsErr)
+	require.False(t, dnsErr.IsNotFound)
+	require.True(t, dnsErr.IsTemporary)
+	require.ErrorIs(t, err, ResolveHostError)
+}
+
+func TestNormalizeResolvers(t *testing.T) {
+	tests := []struct {
+		resolvers []string
+		want      []string
+	}{
+		{resolvers: []string{"1.1.1.1"}, want: []string{"1.1.1.1:53"}},
+		{resolvers: []string{"1.1.1.1:5353"}, want: []string{"1.1.1.1:5353"}},
+		{resolvers: []string{"1.1.1.1,8.8.8.8:5353, 9.9.9.9"}, want: []string{"1.1.1.1:53", "8.8.8.8:5353", "9.9.9.9:53"}},
+		{resolvers: []string{"1.1.1.1 8.8.8.8", "1.0.0.1"}, want: []string{"1.1.1.1:53", "8.8.8.8:53", "1.0.0.1:53"}},
+		{resolvers: []string{"2606:4700::1111", "[2606:4700::1001]:5353"}, want: []string{"[2606:4700::1111]:53", "[2606:4700::1001]:5353"}},
+		{resolvers: []string{"tcp:1.1.1.1", "udp:1.1.1.1:5353"}, want: []string{"tcp:1.1.1.1:53", "udp:1.1.1.1:5353"}},
+		{resolvers: []string{"dot:1.1.1.1", "dot:1.1.1.1:8853"}, want: []string{"dot:1.1.1.1:853", "dot:1.1.1.1:8853"}},
+		{resolvers: []string{"doh:https://cloudflare-dns.com/dns-query:post"}, want: []string{"doh:https://cloudflare-dns.com/dns-query:post"}},
+		{resolvers: []string{"", " , "}, want: nil},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, normalizeResolvers(tt.resolvers), tt.resolvers)
+	}
+}
+
+// rcodeAnswer replies to A queries with the given rcode, and with an A record on success
+func rcodeAnswer(rcode int, authoritative bool) func(*dns.Msg) (*dns.Msg, error) {
+	return func(msg *dns.Msg) (*dns.Msg, error) {
+		resp := &dns.Msg{}
+		resp.SetRcode(msg, rcode)
+		resp.Authoritative = authoritative
+		question := msg.Question[0]
+		if rcode == dns.RcodeSuccess && question.Qtype == dns.TypeA {
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP("10.0.0.1"),
+			})
+		}
+		if rcode != dns.RcodeSuccess {
+			return resp, errors.New("could not resolve, max retries exceeded")
+		}
+		return resp, nil
+	}
+}
+
+func TestResolveResponseCodes(t *testing.T) {
+	tests := []struct {
+		name          string
+		rcode         int
+		authoritative bool
+		wantName      string
+		wantCached    bool
+	}{
+		{name: "noerror", rcode: dns.RcodeSuccess, authoritative: true, wantName: "NOERROR", wantCached: true},
+		{name: "nxdomain", rcode: dns.RcodeNameError, authoritative: true, wantName: "NXDOMAIN"},
+		{name: "servfail", rcode: dns.RcodeServerFailure, wantName: "SERVFAIL"},
+		{name: "refused", rcode: dns.RcodeRefused, wantName: "REFUSED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fd := newTestDialer(t, DefaultOptions, &mockResolver{do: rcodeAnswer(tt.rcode, tt.authoritative)})
+
+			response, err := fd.Resolve("example.com")
+			require.Nil(t, err)
+			require.Equal(t, tt.rcode, response.Rcode)
+			require.Equal(t, tt.wantName, response.RcodeName)
+			require.Equal(t, tt.authoritative, response.Authoritative)
+			require.Equal(t, tt.rcode == dns.RcodeNameError, response.IsNXDomain())
+			require.Equal(t, tt.rcode == dns.RcodeServerFailure, response.IsServFail())
+
+			cached, err := fd.GetDNSDataFromCache("example.com")
+			if tt.wantCached {
+				require.Nil(t, err)
+				require.Equal(t, []string{"10.0.0.1"}, cached.A)
+			} else {
+				require.ErrorIs(t, err, NoDNSDataError)
+			}
+		})
+	}
+}
+
+func TestResolveUnreachable(t *testing.T) {
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{})
+	_, err := fd.Resolve("example.com")
+	require.NotNil(t, err)
+}
+
+func TestPartialResolution(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	// the A query succeeded but the AAAA one failed
+	resolver := &mockResolver{resolve: func(host string) (*retryabledns.DNSData, error) {
+		return &retryabledns.DNSData{Host: host, A: []string{"127.0.0.1"}}, errors.New("aaaa query timed out")
+	}}
+	var logs strings.Builder
+	options := DefaultOptions
+	options.Logger = log.New(&logs, "", 0)
+	fd := newTestDialer(t, options, resolver)
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1"}, data.A)
+	// the dropped error goes to the logger
+	require.Equal(t, "[WRN] fastdialer: partial resolution of example.com: aaaa query timed out\n", logs.String())
+
+	conn, err := fd.Dial(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+	require.Nil(t, err)
+	conn.Close()
+
+	// without any address the error is kept
+	resolver.resolve = func(host string) (*retryabledns.DNSData, error) {
+		return &retryabledns.DNSData{Host: host}, errors.New("queries timed out")
+	}
+	_, err = fd.GetDNSData("unresolved.example.com")
+	require.NotNil(t, err)
+}
+
+// answerA replies with the given number of A records, truncating the response when requested
+func answerA(records int, truncated bool, calls *int32) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt32(calls, 1)
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		resp.Truncated = truncated
+		question := req.Question[0]
+		if question.Qtype == dns.TypeA {
+			for i := 1; i <= records; i++ {
+				resp.Answer = append(resp.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+					A:   net.IPv4(10, 0, 0, byte(i)),
+				})
+			}
+		}
+		_ = w.WriteMsg(resp)
+	}
+}
+
+func newResolverTestDialer(t *testing.T, options Options, resolver string) *Dialer {
+	t.Helper()
+	options.BaseResolvers = []string{resolver}
+	options.HostsFile = false
+	options.ResolversFile = false
+	options.CacheType = Memory
+	fd, err := NewDialer(options)
+	require.Nil(t, err)
+	t.Cleanup(fd.Close)
+	return fd
+}
+
+func TestTruncatedResponseTCPFallback(t *testing.T) {
+	var udpCalls, tcpCalls int32
+	resolver := newTestDNSServer(t, answerA(2, true, &udpCalls), answerA(40, false, &tcpCalls))
+	fd := newResolverTestDialer(t, DefaultOptions, resolver)
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Len(t, data.A, 40)
+	require.NotZero(t, atomic.LoadInt32(&udpCalls))
+	require.NotZero(t, atomic.LoadInt32(&tcpCalls))
+
+	// the queries built by the dialer are retried over tcp as well
+	for name, set := range map[string]func(*Options){
+		"query hook":        func(o *Options) { o.DNSQueryHook = func(*dns.Msg) {} },
+		"padding":           func(o *Options) { o.EDNSPadding = true },
+		"randomized":        func(o *Options) { o.RandomizeQueries = true },
+		"concurrent family": func(o *Options) { o.ConcurrentFamilyResolve = true },
+	} {
+		t.Run(name, func(t *testing.T) {
+			options := DefaultOptions
+			set(&options)
+			fd := newResolverTestDialer(t, options, resolver)
+			tcpBefore := atomic.LoadInt32(&tcpCalls)
+
+			data, err := fd.GetDNSData("example.com")
+			require.Nil(t, err)
+			require.Len(t, data.A, 40)
+			require.Greater(t, atomic.LoadInt32(&tcpCalls), tcpBefore)
+		})
+	}
+}
+
+func TestDNSForceTCP(t *testing.T) {
+	var udpCalls, tcpCalls int32
+	resolver := newTestDNSServer(t, answerA(2, true, &udpCalls), answerA(40, false, &tcpCalls))
+	options := DefaultOptions
+	options.DNSForceTCP = true
+	fd := newResolverTestDialer(t, options, resolver)
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Len(t, data.A, 40)
+	require.Zero(t, atomic.LoadInt32(&udpCalls))
+
+	require.Equal(t, []string{"tcp:1.1.1.1:53", "tcp:1.1.1.1:53", "tcp:1.1.1.1:53", "dot:1.1.1.1:853"}, forceTCPResolvers([]string{"1.1.1.1:53", "udp:1.1.1.1:53", "tcp:1.1.1.1:53", "dot:1.1.1.1:853"}))
+}
+
+// blockingLookup hangs until the lookup context is done and reports it
+type blockingLookup chan error
+
+func (b blockingLookup) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	<-ctx.Done()
+	b <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func TestResolveWithSyscallCancel(t *testing.T) {
+	options := DefaultOptions
+	options.EnableFallback = true
+	fd := newTestDialer(t, options, &mockResolver{resolve: failingAnswer})
+	lookup := make(blockingLookup, 1)
+	fd.syscallResolver = lookup
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := fd.LookupIPAddr(ctx, "slow.example.com")
+	require.NotNil(t, err)
+	select {
+	case err := <-lookup:
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(time.Second):
+		require.Fail(t, "syscall lookup was not canceled")
+	}
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestFollowCNAME(t *testing.T) {
+	answers := map[string]*retryabledns.DNSData{
+		"www.example.com":  {Host: "www.example.com", CNAME: []string{"edge.example.net"}},
+		"edge.example.net": {Host: "edge.example.net", CNAME: []string{"pop.example.org."}},
+		"pop.example.org":  {Host: "pop.example.org", A: []string{"10.0.0.1"}, AAAA: []string{"fd00::1"}},
+		"loop.example.com": {Host: "loop.example.com", CNAME: []string{"back.example.com"}},
+		"back.example.com": {Host: "back.example.com", CNAME: []string{"loop.example.com"}},
+	}
+	resolver := &mockResolver{resolve: func(host string) (*retryabledns.DNSData, error) {
+		data := *answers[host]
+		return &data, nil
+	}}
+	fd := newTestDialer(t, DefaultOptions, resolver)
+
+	data, err := fd.GetDNSData("www.example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, data.A)
+	require.Equal(t, []string{"fd00::1"}, data.AAAA)
+	require.Equal(t, []string{"edge.example.net", "pop.example.org."}, data.CNAME)
+	require.Equal(t, int32(3), atomic.LoadInt32(&resolver.resolveCalls))
+
+	cached, err := fd.GetDNSDataFromCache("www.example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, cached.A)
+
+	// cycles end the chain without addresses
+	data, err = fd.GetDNSData("loop.example.com")
+	require.Nil(t, err)
+	require.Empty(t, data.A)
+	require.Equal(t, int32(5), atomic.LoadInt32(&resolver.resolveCalls))
+}
+
+func TestMaxRecords(t *testing.T) {
+	var a, aaaa []string
+	for i := 1; i <= 1000; i++ {
+		a = append(a, net.IPv4(10, 0, byte(i>>8), byte(i)).String())
+		aaaa = append(aaaa, fmt.Sprintf("fd00::%x", i))
+	}
+	options := DefaultOptions
+	options.MaxRecords = 10
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer(a, aaaa)})
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, a[:10], data.A)
+	require.Empty(t, data.AAAA)
+
+	fd = newTestDialer(t, options, &mockResolver{resolve: staticAnswer(a[:4], aaaa)})
+	data, err = fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, a[:4], data.A)
+	require.Equal(t, aaaa[:6], data.AAAA)
+}
+
+func TestSortIPs(t *testing.T) {
+	resolver := &mockResolver{resolve: func(host string) (*retryabledns.DNSData, error) {
+		return &retryabledns.DNSData{
+			Host: host,
+			A:    []string{"10.0.0.10", "10.0.0.9", "9.255.0.1", "10.0.0.9"},
+			AAAA: []string{"fd00::10", "fd00::9", "2001:db8::1"},
+		}, nil
+	}}
+	options := DefaultOptions
+	options.SortIPs = true
+	fd := newTestDialer(t, options, resolver)
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"9.255.0.1", "10.0.0.9", "10.0.0.9", "10.0.0.10"}, data.A)
+	require.Equal(t, []string{"2001:db8::1", "fd00::9", "fd00::10"}, data.AAAA)
+
+	// cached answers keep the order
+	data, err = fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"9.255.0.1", "10.0.0.9", "10.0.0.9", "10.0.0.10"}, data.A)
+
+	ips := []string{"invalid", "10.0.0.2", "10.0.0.1"}
+	sortIPs(ips)
+	require.Equal(t, []string{"10.0.0.1", "10.0.0.2", "invalid"}, ips)
+}
+
+func TestDNSQueryHook(t *testing.T) {
+	var calls int32
+	var mu sync.Mutex
+	var received []*dns.Msg
+	answer := answerA(1, false, &calls)
+	handler := func(w dns.ResponseWriter, req *dns.Msg) {
+		mu.Lock()
+		received = append(received, req)
+		mu.Unlock()
+		answer(w, req)
+	}
+	resolver := newTestDNSServer(t, handler, handler)
+	options := DefaultOptions
+	options.DNSQueryHook = func(msg *dns.Msg) {
+		msg.RecursionDesired = false
+		msg.IsEdns0().Option = append(msg.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"})
+	}
+	fd := newResolverTestDialer(t, options, resolver)
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, data.A)
+
+	mu.Lock()
+	require.Len(t, received, 2)
+	for _, req := range received {
+		require.False(t, req.RecursionDesired)
+		require.Len(t, req.IsEdns0().Option, 1)
+		require.Equal(t, uint16(dns.EDNS0COOKIE), req.IsEdns0().Option[0].Option())
+	}
+	mu.Unlock()
+
+	// the answer is cached as usual
+	_, err = fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestEDNSPadding(t *testing.T) {
+	var calls int32
+	var mu sync.Mutex
+	var received [][]byte
+	answer := answerA(1, false, &calls)
+	handler := func(w dns.ResponseWriter, req *dns.Msg) {
+		wire, err := req.Pack()
+		require.Nil(t, err)
+		mu.Lock()
+		received = append(received, wire)
+		mu.Unlock()
+		answer(w, req)
+	}
+	resolver := newTestDNSServer(t, handler, handler)
+	options := DefaultOptions
+	options.EDNSPadding = true
+	fd := newResolverTestDialer(t, options, resolver)
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, data.A)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, received, 2)
+	for _, wire := range received {
+		require.Zero(t, len(wire)%paddingBlockSize)
+		msg := &dns.Msg{}
+		require.Nil(t, msg.Unpack(wire))
+		var padded bool
+		for _, option := range msg.IsEdns0().Option {
+			padded = padded || option.Option() == dns.EDNS0PADDING
+		}
+		require.True(t, padded)
+	}
+}
+
+func TestResolveErrorTemporary(t *testing.T) {
+	answer := func(rcode int) func(string) (*retryabledns.DNSData, error) {
+		return func(host string) (*retryabledns.DNSData, error) {
+			return &retryabledns.DNSData{Host: host, StatusCode: dns.RcodeToString[rcode], StatusCodeRaw: rcode}, nil
+		}
+	}
+	tests := []struct {
+		name      string
+		resolve   func(string) (*retryabledns.DNSData, error)
+		temporary bool
+	}{
+		{name: "timeout", resolve: func(host string) (*retryabledns.DNSData, error) {
+			return nil, &net.DNSError{Err: "i/o timeout", Name: host, IsTimeout: true}
+		}, temporary: true},
+		{name: "unreachable", resolve: failingAnswer, temporary: true},
+		{name: "servfail", resolve: answer(dns.RcodeServerFailure), temporary: true},
+		{name: "refused", resolve: answer(dns.RcodeRefused), temporary: true},
+		{name: "nxdomain", resolve: answer(dns.RcodeNameError)},
+		{name: "no records", resolve: answer(dns.RcodeSuccess)},
+		{name: "formerr", resolve: answer(dns.RcodeFormatError)},
+	}
+	for _, tt := range tests {
+		fd := newTestDialer(t, DefaultOptions, &mockResolver{resolve: tt.resolve})
+		_, err := fd.Dial(context.Background(), "tcp", "example.com:80")
+		var resolveErr *ResolveError
+		require.ErrorAs(t, err, &resolveErr, tt.name)
+		require.Equal(t, "example.com", resolveErr.Hostname, tt.name)
+		require.Equal(t, tt.temporary, resolveErr.Temporary(), tt.name)
+	}
+
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{resolve: answer(dns.RcodeNameError)})
+	_, err := fd.Dial(context.Background(), "tcp", "example.com:80")
+	require.ErrorIs(t, err, NoAddressFoundError)
+	var resolveErr *ResolveError
+	require.ErrorAs(t, err, &resolveErr)
+	require.Equal(t, dns.RcodeNameError, resolveErr.Rcode)
+}
+
+func TestRandomizeQueries(t *testing.T) {
+	var calls int32
+	var mu sync.Mutex
+	var names []string
+	answer := answerA(1, false, &calls)
+	echoing := func(w dns.ResponseWriter, req *dns.Msg) {
+		mu.Lock()
+		names = append(names, req.Question[0].Name)
+		mu.Unlock()
+		answer(w, req)
+	}
+	options := DefaultOptions
+	options.RandomizeQueries = true
+	fd := newResolverTestDialer(t, options, newTestDNSServer(t, echoing, echoing))
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, data.A)
+	mu.Lock()
+	require.Len(t, names, 2)
+	for _, name := range names {
+		require.True(t, strings.EqualFold("example.com.", name), name)
+	}
+	mu.Unlock()
+
+	// a resolver altering the case of the question, as a spoofer guessing it would
+	altering := func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		resp.Question[0].Name = strings.Map(func(r rune) rune {
+			if unicode.IsUpper(r) {
+				return unicode.ToLower(r)
+			}
+			return unicode.ToUpper(r)
+		}, resp.Question[0].Name)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: resp.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(10, 0, 0, 2),
+		})
+		_ = w.WriteMsg(resp)
+	}
+	fd = newResolverTestDialer(t, options, newTestDNSServer(t, altering, altering))
+	_, err = fd.GetDNSData("example.com")
+	require.ErrorIs(t, err, QueryMismatchError)
+}
+
+func TestResolutionSentinelErrors(t *testing.T) {
+	require.Equal(t, "could not resolve host", ResolveHostError.Error())
+	require.Equal(t, "no address found for host", NoAddressFoundError.Error())
+
+	// no records
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{})
+	_, err := fd.Dial(context.Background(), "tcp", "example.com:80")
+	require.ErrorIs(t, err, ErrNoAddressFound)
+	require.ErrorIs(t, err, NoAddressFoundError)
+	_, err = fd.DialWithIPs(context.Background(), "tcp", "example.com", nil, "80", false)
+	require.ErrorIs(t, err, ErrNoAddressFound)
+
+	// unreachable resolver
+	fd = newTestDialer(t, DefaultOptions, &mockResolver{resolve: failingAnswer})
+	_, err = fd.GetDNSData("example.com")
+	require.ErrorIs(t, err, ErrNoResolution)
+	require.ErrorIs(t, err, ResolveHostError)
+	_, err = fd.Resolve("example.com")
+	require.ErrorIs(t, err, ErrNoResolution)
+
+	// no answer at all
+	fd = newTestDialer(t, DefaultOptions, &mockResolver{resolve: func(string) (*retryabledns.DNSData, error) { return nil, nil }})
+	_, err = fd.Dial(context.Background(), "tcp", "example.com:80")
+	require.ErrorIs(t, err, ErrNoResolution)
+}
+
+func TestConcurrentFamilyResolve(t *testing.T) {
+	// each query waits for the other one, sequential queries would time out
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	both := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(both)
+	}()
+	resolver := &mockResolver{do: func(msg *dns.Msg) (*dns.Msg, error) {
+		arrived.Done()
+		select {
+		case <-both:
+		case <-time.After(2 * time.Second):
+			return nil, errors.New("queries were not sent concurrently")
+		}
+		// the AAAA query fails without failing the resolution
+		if msg.Question[0].Qtype == dns.TypeAAAA {
+			return nil, errors.New("aaaa query failed")
+		}
+		resp := &dns.Msg{}
+		resp.SetReply(msg)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: msg.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(10, 0, 0, 1),
+		})
+		return resp, nil
+	}}
+	options := DefaultOptions
+	options.ConcurrentFamilyResolve = true
+	fd := newTestDialer(t, options, resolver)
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, data.A)
+	require.Empty(t, data.AAAA)
+	require.Equal(t, int32(2), atomic.LoadInt32(&resolver.resolveCalls))
+}
+
+func TestResolveWith(t *testing.T) {
+	var configuredCalls, adHocCalls int32
+	configured := newTestDNSServer(t, answerA(1, false, &configuredCalls), answerA(1, false, &configuredCalls))
+	adHoc := newTestDNSServer(t, answerA(3, false, &adHocCalls), answerA(3, false, &adHocCalls))
+	fd := newResolverTestDialer(t, DefaultOptions, configured)
+
+	data, err := fd.ResolveWith(context.Background(), "example.com", adHoc)
+	require.Nil(t, err)
+	require.Len(t, data.A, 3)
+	require.Zero(t, atomic.LoadInt32(&configuredCalls))
+	require.Positive(t, atomic.LoadInt32(&adHocCalls))
+
+	// the answer isn't cached, the configured resolver is queried by the following resolutions
+	_, ok := fd.hm.Get("example.com")
+	require.False(t, ok)
+	data, err = fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Len(t, data.A, 1)
+
+	_, err = fd.ResolveWith(context.Background(), "example.com", "127.0.0.1:dns")
+	require.ErrorIs(t, err, InvalidResolverError)
+}
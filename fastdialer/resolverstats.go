@@ -0,0 +1,160 @@
+package fastdialer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	retryabledns "github.com/boss-net/retryabledns"
+	"github.com/miekg/dns"
+)
+
+const (
+	// rttSmoothing is the weight of the latest sample in the round trip time moving average
+	rttSmoothing = 0.3
+	// resolverFailurePenalty is added to the samples of failed queries, so that failing resolvers are tried last
+	resolverFailurePenalty = time.Second
+)
+
+// ResolverStat is the latency of a resolver as measured by the dialer
+type ResolverStat struct {
+	Resolver string
+	RTT      time.Duration // moving average of the query round trip times, failures are penalized
+	Queries  int
+}
+
+// dnsClient spreads the queries across the resolvers, truncated responses are retried over tcp
+// to get the full record set
+type dnsClient struct {
+	*retryabledns.Client
+	tcp *retryabledns.Client
+}
+
+// newDNSClient returns a client spreading the queries across the resolvers
+func newDNSClient(resolvers []string, maxRetries int) (*dnsClient, error) {
+	client, err := retryabledns.New(resolvers, maxRetries)
+	if err != nil {
+		return nil, err
+	}
+	// Resolve retries the truncated udp responses itself, Do relies on the tcp client
+	client.TCPFallback = true
+	tcp, err := retryabledns.New(forceTCPResolvers(resolvers), maxRetries)
+	if err != nil {
+		return nil, err
+	}
+	return &dnsClient{Client: client, tcp: tcp}, nil
+}
+
+func (c *dnsClient) Do(msg *dns.Msg) (*dns.Msg, error) {
+	resp, err := c.Client.Do(msg)
+	if resp != nil && resp.Truncated {
+		if tcpResp, tcpErr := c.tcp.Do(msg); tcpResp != nil {
+			return tcpResp, tcpErr
+		}
+	}
+	return resp, err
+}
+
+// rttResolver is a resolver along with its measured latency
+type rttResolver struct {
+	ResolverStat
+	client dnsResolver
+}
+
+// rttResolvers sends each query to the resolvers in order of their round trip time moving
+// average, the next one being tried only if the previous failed. Resolvers not measured yet
+// are tried first
+type rttResolvers struct {
+	mu        sync.Mutex
+	resolvers []*rttResolver
+}
+
+func newRTTResolvers(resolvers []string, maxRetries int) (*rttResolvers, error) {
+	pool := &rttResolvers{}
+	for _, resolver := range resolvers {
+		dnsclient, err := newDNSClient([]string{resolver}, maxRetries)
+		if err != nil {
+			return nil, err
+		}
+		pool.resolvers = append(pool.resolvers, &rttResolver{ResolverStat: ResolverStat{Resolver: resolver}, client: dnsclient})
+	}
+	return pool, nil
+}
+
+// ordered returns the resolvers sorted by round trip time
+func (p *rttResolvers) ordered() []*rttResolver {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ordered := append([]*rttResolver{}, p.resolvers...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].RTT < ordered[j].RTT
+	})
+	return ordered
+}
+
+// record adds the round trip time of a query to the moving average of the resolver
+func (p *rttResolvers) record(resolver *rttResolver, rtt time.Duration, failed bool) {
+	if failed {
+		rtt += resolverFailurePenalty
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if resolver.Queries == 0 {
+		resolver.RTT = rtt
+	} else {
+		resolver.RTT = time.Duration(rttSmoothing*float64(rtt) + (1-rttSmoothing)*float64(resolver.RTT))
+	}
+	resolver.Queries++
+}
+
+// stats returns the resolvers statistics, fastest first
+func (p *rttResolvers) stats() []ResolverStat {
+	ordered := p.ordered()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]ResolverStat, 0, len(ordered))
+	for _, resolver := range ordered {
+		stats = append(stats, resolver.ResolverStat)
+	}
+	return stats
+}
+
+func (p *rttResolvers) Resolve(host string) (data *retryabledns.DNSData, err error) {
+	for _, resolver := range p.ordered() {
+		start := time.Now()
+		data, err = resolver.client.Resolve(host)
+		p.record(resolver, time.Since(start), err != nil)
+		// partial answers are still answers
+		if err == nil || (data != nil && len(data.A)+len(data.AAAA) > 0) {
+			return data, err
+		}
+	}
+	return data, err
+}
+
+func (p *rttResolvers) Do(msg *dns.Msg) (resp *dns.Msg, err error) {
+	for _, resolver := range p.ordered() {
+		start := time.Now()
+		resp, err = resolver.client.Do(msg)
+		p.record(resolver, time.Since(start), resp == nil)
+		if resp != nil {
+			return resp, err
+		}
+	}
+	return resp, err
+}
+
+// ResolverStats returns the measured latency of the resolvers in the order they are tried,
+// it requires Options.SortResolversByRTT
+func (d *Dialer) ResolverStats() []ResolverStat {
+	dnsclient := d.dnsclient
+	// the plain resolvers follow the dns over tls ones
+	if chain, ok := dnsclient.(chainedResolvers); ok {
+		dnsclient = chain[len(chain)-1]
+	}
+	pool, ok := dnsclient.(*rttResolvers)
+	if !ok {
+		return nil
+	}
+	return pool.stats()
+}
@@ -0,0 +1,53 @@
+package fastdialer
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortResolversByRTT(t *testing.T) {
+	var slowCalls, fastCalls int32
+	slowAnswer, fastAnswer := answerA(1, false, &slowCalls), answerA(1, false, &fastCalls)
+	slow := func(w dns.ResponseWriter, req *dns.Msg) {
+		time.Sleep(100 * time.Millisecond)
+		slowAnswer(w, req)
+	}
+	slowResolver := newTestDNSServer(t, slow, slow)
+	fastResolver := newTestDNSServer(t, fastAnswer, fastAnswer)
+
+	options := DefaultOptions
+	options.BaseResolvers = []string{slowResolver, fastResolver}
+	options.SortResolversByRTT = true
+	options.HostsFile = false
+	options.ResolversFile = false
+	options.CacheType = Memory
+	fd, err := NewDialer(options)
+	require.Nil(t, err)
+	t.Cleanup(fd.Close)
+
+	// warm up: each resolver gets measured once
+	for i := 0; i < 2; i++ {
+		_, err := fd.GetDNSData(fmt.Sprintf("warmup%d.example.com", i))
+		require.Nil(t, err)
+	}
+	stats := fd.ResolverStats()
+	require.Len(t, stats, 2)
+	require.Equal(t, fastResolver, stats[0].Resolver)
+	require.Less(t, stats[0].RTT, stats[1].RTT)
+
+	slowBefore := atomic.LoadInt32(&slowCalls)
+	for i := 0; i < 5; i++ {
+		_, err := fd.GetDNSData(fmt.Sprintf("host%d.example.com", i))
+		require.Nil(t, err)
+	}
+	require.Equal(t, slowBefore, atomic.LoadInt32(&slowCalls))
+	require.Equal(t, 6, fd.ResolverStats()[0].Queries)
+
+	// without the option no statistics are kept
+	require.Nil(t, newTestDialer(t, DefaultOptions, &mockResolver{}).ResolverStats())
+}
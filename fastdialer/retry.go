@@ -0,0 +1,93 @@
+package fastdialer
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	retryabledns "github.com/boss-net/retryabledns"
+)
+
+// withConnectRetries calls dial until it succeeds or fails with something else than a connect
+// error, at most Options.ConnectRetries more times. Options.ConnectBackoff is waited before the
+// first retry and doubled before each following one, each wait is jittered by Options.RetryJitter
+func (d *Dialer) withConnectRetries(ctx context.Context, dial func() (net.Conn, error)) (net.Conn, error) {
+	backoff := d.options.ConnectBackoff
+	for attempt := 0; ; attempt++ {
+		conn, err := dial()
+		if err == nil || attempt >= d.options.ConnectRetries || !isRetryableConnectError(err) {
+			return conn, err
+		}
+		if !d.waitBackoff(ctx, backoff) {
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// resolveRetryBackoff is waited before the first retry of a timed out resolution
+const resolveRetryBackoff = 100 * time.Millisecond
+
+// resolveWithTimeoutRetries resolves the hostname again while the resolvers time out, at most
+// Options.ResolveRetryOnTimeout more times. These retries are independent of the ones the dns
+// client makes within each resolution, the backoff is doubled before each one
+func (d *Dialer) resolveWithTimeoutRetries(ctx context.Context, hostname string) (*retryabledns.DNSData, error) {
+	backoff := resolveRetryBackoff
+	for attempt := 0; ; attempt++ {
+		data, err := d.resolve(ctx, hostname)
+		if err == nil || attempt >= d.options.ResolveRetryOnTimeout || !isResolveTimeout(err) {
+			return data, err
+		}
+		if !d.waitBackoff(ctx, backoff) {
+			return data, err
+		}
+		backoff *= 2
+	}
+}
+
+// waitBackoff waits for the backoff jittered by Options.RetryJitter and reports false if the
+// context was done first
+func (d *Dialer) waitBackoff(ctx context.Context, backoff time.Duration) bool {
+	if backoff <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(jitter(backoff, d.options.RetryJitter))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// jitter randomizes the backoff within the fraction of its duration, so that concurrent
+// retries don't happen in lockstep. The fraction is capped to 1
+func jitter(backoff time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return backoff
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	return backoff + time.Duration((2*rand.Float64()-1)*fraction*float64(backoff))
+}
+
+// isRetryableConnectError checks if the tcp connection itself failed, timeouts are excluded
+// as they already consumed the dialer timeout
+func isRetryableConnectError(err error) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "dial" || opErr.Timeout() {
+		return false
+	}
+	return !errors.Is(err, SourcePortExhaustedError)
+}
+
+// isResolveTimeout checks if the resolvers didn't answer in time, the expiry of the context
+// of the dial isn't one
+func isResolveTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout() && !errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,134 @@
+package fastdialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	retryabledns "github.com/boss-net/retryabledns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectRetries(t *testing.T) {
+	// reserve a port nothing listens on until the second connection attempt
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	options := DefaultOptions
+	options.ConnectRetries = 2
+	options.ConnectBackoff = 10 * time.Millisecond
+	fd := newTestDialer(t, options, &mockResolver{})
+	var attempts int32
+	fd.dialer.Control = func(network, _ string, _ syscall.RawConn) error {
+		// control runs before connect, start the server for the retry
+		if atomic.AddInt32(&attempts, 1) == 2 {
+			server, err := net.Listen(network, address)
+			require.Nil(t, err)
+			t.Cleanup(func() { server.Close() })
+		}
+		return nil
+	}
+
+	conn, err := fd.Dial(context.Background(), "tcp", address)
+	require.Nil(t, err)
+	conn.Close()
+	require.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
+
+func TestWithConnectRetries(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	options := DefaultOptions
+	options.ConnectRetries = 2
+	fd := newTestDialer(t, options, &mockResolver{})
+
+	var calls int
+	_, err := fd.withConnectRetries(context.Background(), func() (net.Conn, error) {
+		calls++
+		return nil, refused
+	})
+	require.ErrorIs(t, err, syscall.ECONNREFUSED)
+	require.Equal(t, 3, calls)
+
+	// other failures are returned right away
+	calls = 0
+	_, err = fd.withConnectRetries(context.Background(), func() (net.Conn, error) {
+		calls++
+		return nil, errors.New("handshake failure")
+	})
+	require.NotNil(t, err)
+	require.Equal(t, 1, calls)
+
+	// the backoff is interrupted by the context
+	fd.options.ConnectBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	calls = 0
+	_, err = fd.withConnectRetries(ctx, func() (net.Conn, error) {
+		calls++
+		return nil, refused
+	})
+	require.ErrorIs(t, err, syscall.ECONNREFUSED)
+	require.Equal(t, 1, calls)
+}
+
+func TestJitter(t *testing.T) {
+	backoff := 100 * time.Millisecond
+	require.Equal(t, backoff, jitter(backoff, 0))
+
+	seen := make(map[time.Duration]struct{})
+	for i := 0; i < 1000; i++ {
+		got := jitter(backoff, 0.2)
+		require.GreaterOrEqual(t, got, 80*time.Millisecond)
+		require.LessOrEqual(t, got, 120*time.Millisecond)
+		seen[got] = struct{}{}
+	}
+	require.Greater(t, len(seen), 1)
+
+	// the fraction is capped, the backoff never gets negative
+	for i := 0; i < 1000; i++ {
+		got := jitter(backoff, 5)
+		require.GreaterOrEqual(t, got, time.Duration(0))
+		require.LessOrEqual(t, got, 2*backoff)
+	}
+}
+
+func TestResolveRetryOnTimeout(t *testing.T) {
+	timeout := &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded}
+	var timedOut int32
+	resolver := &mockResolver{resolve: func(host string) (*retryabledns.DNSData, error) {
+		// the resolvers time out once then answer
+		if atomic.CompareAndSwapInt32(&timedOut, 0, 1) {
+			return nil, timeout
+		}
+		return &retryabledns.DNSData{Host: host, A: []string{"127.0.0.1"}}, nil
+	}}
+	options := DefaultOptions
+	options.ResolveRetryOnTimeout = 1
+	fd := newTestDialer(t, options, resolver)
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1"}, data.A)
+	require.Equal(t, int32(2), atomic.LoadInt32(&resolver.resolveCalls))
+
+	// other failures are returned right away
+	resolver = &mockResolver{resolve: failingAnswer}
+	fd = newTestDialer(t, options, resolver)
+	_, err = fd.GetDNSData("example.com")
+	require.NotNil(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&resolver.resolveCalls))
+
+	// the timeout is returned once the retries are exhausted
+	resolver = &mockResolver{resolve: func(string) (*retryabledns.DNSData, error) { return nil, timeout }}
+	fd = newTestDialer(t, options, resolver)
+	_, err = fd.GetDNSData("example.com")
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Equal(t, int32(2), atomic.LoadInt32(&resolver.resolveCalls))
+}
@@ -0,0 +1,64 @@
+package fastdialer
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	iputil "github.com/boss-net/goutils/ip"
+)
+
+// serviceAddress is the ip, and optionally the port, a hostname is pinned to
+type serviceAddress struct {
+	ip   string
+	port string
+}
+
+// parseServiceMap validates the ip or ip:port values of Options.ServiceMap. Pinned hostnames
+// are dialed to the ip without resolving them, on the mapped port if any instead of the
+// dialed one. The network policy, WithForcedIP and the family of the network still apply
+func parseServiceMap(services map[string]string) (map[string]serviceAddress, error) {
+	if len(services) == 0 {
+		return nil, nil
+	}
+	parsed := make(map[string]serviceAddress, len(services))
+	for hostname, value := range services {
+		address, ok := parseServiceAddress(value)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s: %s", InvalidServiceAddressError, hostname, value)
+		}
+		parsed[serviceKey(hostname)] = address
+	}
+	return parsed, nil
+}
+
+// parseServiceAddress parses an ip, ip:port or [ipv6]:port address
+func parseServiceAddress(value string) (serviceAddress, bool) {
+	var address serviceAddress
+	if host, port, err := net.SplitHostPort(value); err == nil {
+		portNumber, err := strconv.Atoi(port)
+		if err != nil || portNumber < 1 || portNumber > 65535 {
+			return address, false
+		}
+		address.ip, address.port = host, port
+	} else {
+		address.ip = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	}
+	address.ip = unmapIPv4(address.ip)
+	return address, iputil.IsIP(withoutZone(address.ip))
+}
+
+// serviceKey normalizes the hostname to look it up in the service map
+func serviceKey(hostname string) string {
+	return strings.ToLower(strings.TrimSuffix(asAscii(hostname), "."))
+}
+
+// lookupService returns the address the hostname is pinned to, if any
+func (d *Dialer) lookupService(hostname string) (serviceAddress, bool) {
+	if len(d.services) == 0 {
+		return serviceAddress{}, false
+	}
+	address, ok := d.services[serviceKey(hostname)]
+	return address, ok
+}
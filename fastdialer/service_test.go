@@ -0,0 +1,79 @@
+package fastdialer
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseServiceAddress(t *testing.T) {
+	tests := []struct {
+		value string
+		want  serviceAddress
+		valid bool
+	}{
+		{"127.0.0.1", serviceAddress{ip: "127.0.0.1"}, true},
+		{"127.0.0.1:8443", serviceAddress{ip: "127.0.0.1", port: "8443"}, true},
+		{"[::ffff:127.0.0.1]:8443", serviceAddress{ip: "127.0.0.1", port: "8443"}, true},
+		{"[fd00::1]:53", serviceAddress{ip: "fd00::1", port: "53"}, true},
+		{"fd00::1", serviceAddress{ip: "fd00::1"}, true},
+		{"127.0.0.1:0", serviceAddress{}, false},
+		{"127.0.0.1:https", serviceAddress{}, false},
+		{"example.com:443", serviceAddress{}, false},
+	}
+	for _, tt := range tests {
+		address, ok := parseServiceAddress(tt.value)
+		require.Equal(t, tt.valid, ok, tt.value)
+		if tt.valid {
+			require.Equal(t, tt.want, address, tt.value)
+		}
+	}
+}
+
+func TestServiceMap(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	options := DefaultOptions
+	options.ServiceMap = map[string]string{
+		"api.example.com":    net.JoinHostPort("127.0.0.1", port),
+		"pinned.example.com": "127.0.0.1",
+	}
+	resolver := &mockResolver{resolve: staticAnswer([]string{"10.0.0.1"}, nil)}
+	fd := newTestDialer(t, options, resolver)
+
+	// the mapped port replaces the dialed one
+	conn, err := fd.Dial(context.Background(), "tcp", "API.example.com.:1")
+	require.Nil(t, err)
+	require.Equal(t, net.JoinHostPort("127.0.0.1", port), conn.RemoteAddr().String())
+	conn.Close()
+
+	conn, err = fd.Dial(context.Background(), "tcp", net.JoinHostPort("pinned.example.com", port))
+	require.Nil(t, err)
+	require.Equal(t, net.JoinHostPort("127.0.0.1", port), conn.RemoteAddr().String())
+	conn.Close()
+	require.Zero(t, atomic.LoadInt32(&resolver.resolveCalls))
+
+	// the forced ip and the family of the network still apply
+	_, err = fd.Dial(WithForcedIP(context.Background(), "127.0.0.2"), "tcp", "api.example.com:1")
+	require.ErrorIs(t, err, ForcedIPNotResolvedError)
+	_, err = fd.Dial(context.Background(), "tcp6", "api.example.com:1")
+	require.ErrorIs(t, err, NoAddressFoundError)
+	conn, err = fd.Dial(context.Background(), "tcp4", "api.example.com:1")
+	require.Nil(t, err)
+	conn.Close()
+
+	// the network policy still applies
+	options.Deny = []string{"127.0.0.1"}
+	fd = newTestDialer(t, options, resolver)
+	_, err = fd.Dial(context.Background(), "tcp", "api.example.com:1")
+	require.ErrorIs(t, err, ErrAllBlocked)
+
+	options.Deny = nil
+	options.ServiceMap = map[string]string{"api.example.com": "api.internal:443"}
+	_, err = NewDialer(options)
+	require.ErrorIs(t, err, InvalidServiceAddressError)
+	require.ErrorIs(t, options.Validate(), InvalidServiceAddressError)
+}
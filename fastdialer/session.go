@@ -0,0 +1,72 @@
+package fastdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWarmTLSConcurrency = 10
+	// tls 1.3 session tickets are sent after the handshake and only processed while reading
+	warmTLSTicketTimeout = 100 * time.Millisecond
+)
+
+// WarmTLS performs a tls handshake with each address to populate the session cache and
+// closes the connections, so that later dials to the same addresses resume the sessions.
+// Dials go through the network policy and at most Options.WarmTLSConcurrency run at once
+func (d *Dialer) WarmTLS(ctx context.Context, addresses []string) error {
+	if d.sessionCache == nil {
+		return TLSSessionCacheDisabledError
+	}
+	concurrency := d.options.WarmTLSConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultWarmTLSConcurrency
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	sem := make(chan struct{}, concurrency)
+	for _, address := range addresses {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func(address string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := d.warmTLS(ctx, address); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", address, err))
+				mu.Unlock()
+			}
+		}(address)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// warmTLS completes a single handshake and waits for the session tickets
+func (d *Dialer) warmTLS(ctx context.Context, address string) error {
+	conn, err := d.DialTLSWithConfig(ctx, "tcp", address, d.defaultTLSConfig())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if tlsConn, ok := dialedConn(conn).(*tls.Conn); ok && tlsConn.ConnectionState().Version == tls.VersionTLS13 {
+		_ = tlsConn.SetReadDeadline(time.Now().Add(warmTLSTicketTimeout))
+		_, _ = tlsConn.Read(make([]byte, 1))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package fastdialer
+
+import (
+	"net"
+	"syscall"
+)
+
+// netConnUnwrapper is implemented by tls and utls connections
+type netConnUnwrapper interface {
+	NetConn() net.Conn
+}
+
+// tuneConn applies the configured tcp options to the connection underlying conn.
+// ztls connections don't expose it and are left untouched
+func (d *Dialer) tuneConn(conn net.Conn) error {
+	if d.options.TCPNoDelay == nil && d.options.ReadBufferSize <= 0 && d.options.WriteBufferSize <= 0 {
+		return nil
+	}
+	for {
+		unwrapper, ok := conn.(netConnUnwrapper)
+		if !ok {
+			break
+		}
+		conn = unwrapper.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if d.options.TCPNoDelay != nil {
+		if err := tcpConn.SetNoDelay(*d.options.TCPNoDelay); err != nil {
+			return err
+		}
+	}
+	if d.options.ReadBufferSize > 0 {
+		if err := tcpConn.SetReadBuffer(d.options.ReadBufferSize); err != nil {
+			return err
+		}
+	}
+	if d.options.WriteBufferSize > 0 {
+		if err := tcpConn.SetWriteBuffer(d.options.WriteBufferSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chainControl returns a dialer control function invoking first, if any, then second
+func chainControl(first, second func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	if first == nil {
+		return second
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if err := first(network, address, c); err != nil {
+			return err
+		}
+		return second(network, address, c)
+	}
+}
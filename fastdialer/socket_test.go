@@ -0,0 +1,85 @@
+//go:build linux
+
+package fastdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// getsockopt reads an integer socket option of the tcp connection underlying conn
+func getsockopt(t *testing.T, conn net.Conn, level, opt int) int {
+	t.Helper()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	rawConn, err := conn.(*net.TCPConn).SyscallConn()
+	require.Nil(t, err)
+	var value int
+	var sockErr error
+	require.Nil(t, rawConn.Control(func(fd uintptr) {
+		value, sockErr = syscall.GetsockoptInt(int(fd), level, opt)
+	}))
+	require.Nil(t, sockErr)
+	return value
+}
+
+func TestSocketOptions(t *testing.T) {
+	noDelay := false
+	options := DefaultOptions
+	options.TCPNoDelay = &noDelay
+	options.ReadBufferSize = 64 * 1024
+	options.WriteBufferSize = 32 * 1024
+	fd := newTestDialer(t, options, &mockResolver{})
+
+	plain, err := fd.Dial(context.Background(), "tcp", newTestTCPServer(t))
+	require.Nil(t, err)
+	defer plain.Close()
+	secure, err := fd.DialTLS(context.Background(), "tcp", newTestTLSServer(t, &tls.Config{}))
+	require.Nil(t, err)
+	defer secure.Close()
+
+	for _, conn := range []net.Conn{plain, secure} {
+		require.Zero(t, getsockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_NODELAY))
+		// the kernel doubles the requested sizes for bookkeeping
+		require.GreaterOrEqual(t, getsockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_RCVBUF), options.ReadBufferSize)
+		require.GreaterOrEqual(t, getsockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_SNDBUF), options.WriteBufferSize)
+	}
+
+	// defaults are left untouched
+	fd = newTestDialer(t, DefaultOptions, &mockResolver{})
+	conn, err := fd.Dial(context.Background(), "tcp", newTestTCPServer(t))
+	require.Nil(t, err)
+	defer conn.Close()
+	require.Equal(t, 1, getsockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_NODELAY))
+}
+
+func TestControl(t *testing.T) {
+	address := newTestTCPServer(t)
+	var controlled []string
+	options := DefaultOptions
+	options.Control = func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, 64*1024)
+		})
+		if err != nil {
+			return err
+		}
+		controlled = append(controlled, network+" "+address)
+		return sockErr
+	}
+	fd := newTestDialer(t, options, &mockResolver{})
+
+	conn, err := fd.Dial(context.Background(), "tcp", address)
+	require.Nil(t, err)
+	defer conn.Close()
+	require.Equal(t, []string{"tcp4 " + address}, controlled)
+	// the kernel doubles the requested size
+	require.Equal(t, 128*1024, getsockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_RCVBUF))
+}
@@ -0,0 +1,105 @@
+package fastdialer
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"syscall"
+)
+
+// validSourcePortRange checks if the range is within the port bounds and ordered
+func validSourcePortRange(portRange [2]int) bool {
+	return portRange[0] > 0 && portRange[1] <= 65535 && portRange[0] <= portRange[1]
+}
+
+// bindAddresses are the local ips of Options.BindAddresses, used in turn per address family
+type bindAddresses struct {
+	ipv4, ipv6 []net.IP
+	next       atomic.Uint32
+}
+
+// parseBindAddresses parses the ips, nil is returned if there are none
+func parseBindAddresses(addresses []string) (*bindAddresses, error) {
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+	bind := &bindAddresses{}
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		switch {
+		case ip == nil:
+			return nil, fmt.Errorf("%w: %s", InvalidBindAddressError, address)
+		case ip.To4() != nil:
+			bind.ipv4 = append(bind.ipv4, ip.To4())
+		default:
+			bind.ipv6 = append(bind.ipv6, ip)
+		}
+	}
+	return bind, nil
+}
+
+// pick returns the next ip of the family of the network, nil if there are none
+func (b *bindAddresses) pick(network string) net.IP {
+	if b == nil {
+		return nil
+	}
+	ips := b.ipv4
+	if network[len(network)-1] == '6' {
+		ips = b.ipv6
+	}
+	if len(ips) == 0 {
+		return nil
+	}
+	return ips[int(b.next.Add(1)-1)%len(ips)]
+}
+
+// sourcePortControl returns a dialer control function binding each socket to the next of the
+// addresses and to a free port of the range, any port if the range is zero. The port search
+// starts after the last assigned port to spread the dials. Sockets of a family without
+// addresses are bound to the wildcard one. The next control function, if any, is invoked once
+// the socket is bound
+func sourcePortControl(portRange [2]int, addresses *bindAddresses, next func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	var offset uint32
+	size := portRange[1] - portRange[0] + 1
+	return func(network, address string, c syscall.RawConn) error {
+		// without a port range, only the sockets of a family with addresses are bound
+		if ip := addresses.pick(network); ip != nil || portRange != [2]int{} {
+			var bindErr error
+			err := c.Control(func(fd uintptr) {
+				start := int(atomic.AddUint32(&offset, 1)-1) % size
+				for i := 0; i < size; i++ {
+					port := portRange[0] + (start+i)%size
+					if bindErr = bindSourcePort(fd, network, ip, port); bindErr == nil {
+						return
+					}
+				}
+				if portRange != [2]int{} {
+					bindErr = fmt.Errorf("%w %d-%d: %w", SourcePortExhaustedError, portRange[0], portRange[1], bindErr)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			if bindErr != nil {
+				return bindErr
+			}
+		}
+		if next != nil {
+			return next(network, address, c)
+		}
+		return nil
+	}
+}
+
+// sourceSockaddr returns the address of the network family with the given ip, the wildcard
+// one if nil, and port
+func sourceSockaddr(network string, ip net.IP, port int) syscall.Sockaddr {
+	if network[len(network)-1] == '6' {
+		sockaddr := &syscall.SockaddrInet6{Port: port}
+		copy(sockaddr.Addr[:], ip.To16())
+		return sockaddr
+	}
+	sockaddr := &syscall.SockaddrInet4{Port: port}
+	copy(sockaddr.Addr[:], ip.To4())
+	return sockaddr
+}
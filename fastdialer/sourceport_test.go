@@ -0,0 +1,41 @@
+//go:build linux || darwin
+
+package fastdialer
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// freePort returns a local port currently not in use
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestSourcePortRange(t *testing.T) {
+	address := newTestTCPServer(t)
+	port := freePort(t)
+	options := DefaultOptions
+	options.SourcePortRange = [2]int{port, port}
+	fd := newTestDialer(t, options, &mockResolver{})
+
+	conn, err := fd.Dial(context.Background(), "tcp", address)
+	require.Nil(t, err)
+	defer conn.Close()
+	require.Equal(t, port, conn.LocalAddr().(*net.TCPAddr).Port)
+
+	// the only port of the range is held by the open connection
+	_, err = fd.Dial(context.Background(), "tcp", address)
+	require.ErrorIs(t, err, SourcePortExhaustedError)
+
+	options.SourcePortRange = [2]int{port, port - 1}
+	_, err = NewDialer(options)
+	require.ErrorIs(t, err, InvalidSourcePortRangeError)
+}
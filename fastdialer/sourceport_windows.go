@@ -0,0 +1,12 @@
+//go:build windows
+
+package fastdialer
+
+import (
+	"net"
+	"syscall"
+)
+
+func bindSourcePort(fd uintptr, network string, ip net.IP, port int) error {
+	return syscall.Bind(syscall.Handle(fd), sourceSockaddr(network, ip, port))
+}
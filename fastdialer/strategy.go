@@ -0,0 +1,202 @@
+package fastdialer
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"sort"
+	"sync"
+)
+
+// DialFunc establishes a connection to one of the resolved ips of the dialed host
+type DialFunc func(ctx context.Context, ip string) (net.Conn, error)
+
+// DialStrategy decides in which order and how concurrently the resolved ips of a host are dialed.
+// The ips are ordered and already filtered by the network policy, Dial returns one of the
+// connections established by dial and closes the others. The attempts made through dial are
+// reported by the dialer in ConnectError
+type DialStrategy interface {
+	Dial(ctx context.Context, hostname string, ips []string, dial DialFunc) (net.Conn, error)
+}
+
+// SequentialStrategy dials the ips one after the other until a connection is established
+type SequentialStrategy struct{}
+
+// Dial implements DialStrategy
+func (SequentialStrategy) Dial(ctx context.Context, hostname string, ips []string, dial DialFunc) (net.Conn, error) {
+	conn, _, err := dialSequential(ctx, ips, dial)
+	return conn, err
+}
+
+// dialSequential returns the first established connection and the ip it was dialed to
+func dialSequential(ctx context.Context, ips []string, dial DialFunc) (net.Conn, string, error) {
+	err := CouldNotConnectError
+	for _, ip := range ips {
+		var conn net.Conn
+		if conn, err = dial(ctx, ip); err == nil {
+			return conn, ip, nil
+		}
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+	}
+	return nil, "", err
+}
+
+// StickyStrategy dials first the ip a connection to the host was last established to, then the
+// other ones in order, so that the following dials stay on a working address. The zero value is
+// ready to use, it must be shared by pointer
+type StickyStrategy struct {
+	mu   sync.Mutex
+	last map[string]string
+}
+
+// Dial implements DialStrategy
+func (s *StickyStrategy) Dial(ctx context.Context, hostname string, ips []string, dial DialFunc) (net.Conn, error) {
+	s.mu.Lock()
+	last := s.last[hostname]
+	s.mu.Unlock()
+
+	ordered := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if ip == last {
+			ordered = append([]string{ip}, ordered...)
+		} else {
+			ordered = append(ordered, ip)
+		}
+	}
+	conn, ip, err := dialSequential(ctx, ordered, dial)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err == nil:
+		if s.last == nil {
+			s.last = make(map[string]string)
+		}
+		s.last[hostname] = ip
+	case ctx.Err() == nil:
+		delete(s.last, hostname)
+	}
+	return conn, err
+}
+
+const (
+	// defaultAdaptiveDecay is the weight of the past outcomes in the adaptive score
+	defaultAdaptiveDecay = 0.8
+	// defaultAdaptiveMaxIPs bounds the ips whose stats are kept by an adaptive strategy
+	defaultAdaptiveMaxIPs = 10000
+)
+
+// AdaptiveStats are the outcomes of the dials to an ip made by an AdaptiveStrategy
+type AdaptiveStats struct {
+	Successes int
+	Failures  int
+	Score     float64 // exponentially decayed success rate, 0.5 for ips never dialed
+}
+
+// AdaptiveStrategy dials the ips one after the other in decreasing order of their score, so that
+// the ips succeeding the most are favored. Each dial outcome moves the score of the ip toward
+// 1 or 0, older outcomes weighing less. Ips with equal scores keep the resolution order. Only
+// the MaxIPs most recently dialed ips are tracked, the others are back to the initial score. The
+// zero value is ready to use, it must be shared by pointer
+type AdaptiveStrategy struct {
+	Decay  float64 // weight of the previous score in each update, between 0 and 1, defaults to 0.8
+	MaxIPs int     // ips tracked, the least recently dialed ones are forgotten beyond it, defaults to 10000
+
+	mu    sync.Mutex
+	order *list.List
+	stats map[string]*list.Element
+}
+
+// adaptiveEntry is an element of the adaptive strategy lru
+type adaptiveEntry struct {
+	ip string
+	AdaptiveStats
+}
+
+// Dial implements DialStrategy
+func (s *AdaptiveStrategy) Dial(ctx context.Context, hostname string, ips []string, dial DialFunc) (net.Conn, error) {
+	scores := make(map[string]float64, len(ips))
+	for _, ip := range ips {
+		scores[ip] = s.Stats(ip).Score
+	}
+	ordered := append([]string(nil), ips...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return scores[ordered[i]] > scores[ordered[j]]
+	})
+	conn, _, err := dialSequential(ctx, ordered, func(ctx context.Context, ip string) (net.Conn, error) {
+		conn, err := dial(ctx, ip)
+		// the attempts interrupted by the context say nothing about the ip
+		if err == nil || ctx.Err() == nil {
+			s.record(ip, err == nil)
+		}
+		return conn, err
+	})
+	return conn, err
+}
+
+// Stats returns the outcomes of the dials to the ip
+func (s *AdaptiveStrategy) Stats(ip string) AdaptiveStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.stats[ip]; ok {
+		return elem.Value.(*adaptiveEntry).AdaptiveStats
+	}
+	return AdaptiveStats{Score: 0.5}
+}
+
+// record updates the stats of the ip with the outcome of a dial
+func (s *AdaptiveStrategy) record(ip string, success bool) {
+	decay := s.Decay
+	if decay <= 0 || decay >= 1 {
+		decay = defaultAdaptiveDecay
+	}
+	maxIPs := s.MaxIPs
+	if maxIPs <= 0 {
+		maxIPs = defaultAdaptiveMaxIPs
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats == nil {
+		s.order = list.New()
+		s.stats = make(map[string]*list.Element)
+	}
+	elem, ok := s.stats[ip]
+	if ok {
+		s.order.MoveToFront(elem)
+	} else {
+		elem = s.order.PushFront(&adaptiveEntry{ip: ip, AdaptiveStats: AdaptiveStats{Score: 0.5}})
+		s.stats[ip] = elem
+		for s.order.Len() > maxIPs {
+			oldest := s.order.Back()
+			s.order.Remove(oldest)
+			delete(s.stats, oldest.Value.(*adaptiveEntry).ip)
+		}
+	}
+	stats := &elem.Value.(*adaptiveEntry).AdaptiveStats
+	outcome := 0.0
+	if success {
+		stats.Successes++
+		outcome = 1
+	} else {
+		stats.Failures++
+	}
+	stats.Score = decay*stats.Score + (1-decay)*outcome
+}
+
+// dialStrategy returns Options.DialStrategy, or the adaptive strategy of the dialer with
+// Options.AdaptiveSelection. By default plain dials are raced when Options.HappyEyeballsDelay
+// is set and they aren't proxied
+func (d *Dialer) dialStrategy(encrypted bool) DialStrategy {
+	switch {
+	case d.options.DialStrategy != nil:
+		return d.options.DialStrategy
+	case d.options.AdaptiveSelection:
+		return &d.adaptive
+	case d.options.HappyEyeballsDelay > 0 && !encrypted && d.proxyDialer == nil:
+		return ParallelStrategy{Delay: d.options.HappyEyeballsDelay}
+	default:
+		return SequentialStrategy{}
+	}
+}
@@ -0,0 +1,256 @@
+package fastdialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testDial is a dial function with a delay and an outcome per ip, it records the dialed ips
+type testDial struct {
+	delays map[string]time.Duration
+	errs   map[string]error
+	// the delays aren't interrupted by the context
+	uncancelable bool
+
+	mu     sync.Mutex
+	dialed []string
+	closed int32
+}
+
+func (d *testDial) dial(ctx context.Context, ip string) (net.Conn, error) {
+	d.mu.Lock()
+	d.dialed = append(d.dialed, ip)
+	d.mu.Unlock()
+	if d.uncancelable {
+		time.Sleep(d.delays[ip])
+	} else {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(d.delays[ip]):
+		}
+	}
+	if err := d.errs[ip]; err != nil {
+		return nil, err
+	}
+	client, server := net.Pipe()
+	server.Close()
+	return &testConn{Conn: client, ip: ip, closed: &d.closed}, nil
+}
+
+func (d *testDial) dialedIPs() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.dialed...)
+}
+
+type testConn struct {
+	net.Conn
+	ip     string
+	closed *int32
+}
+
+func (c *testConn) Close() error {
+	atomic.AddInt32(c.closed, 1)
+	return c.Conn.Close()
+}
+
+func TestSequentialStrategy(t *testing.T) {
+	refused := errors.New("refused")
+	dial := &testDial{errs: map[string]error{"10.0.0.1": refused, "10.0.0.2": refused}}
+	ips := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}
+
+	conn, err := SequentialStrategy{}.Dial(context.Background(), "example.com", ips, dial.dial)
+	require.Nil(t, err)
+	require.Equal(t, "10.0.0.3", conn.(*testConn).ip)
+	require.Equal(t, ips[:3], dial.dialedIPs())
+
+	// the last error is returned once all the ips failed
+	_, err = SequentialStrategy{}.Dial(context.Background(), "example.com", ips[:2], dial.dial)
+	require.ErrorIs(t, err, refused)
+
+	// the remaining ips aren't dialed once the context is done
+	dial = &testDial{delays: map[string]time.Duration{"10.0.0.1": time.Second}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = SequentialStrategy{}.Dial(ctx, "example.com", ips, dial.dial)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, ips[:1], dial.dialedIPs())
+}
+
+func TestParallelStrategy(t *testing.T) {
+	// the first ips hang, the second family is tried after the delay
+	dial := &testDial{delays: map[string]time.Duration{"10.0.0.1": time.Second, "::1": 100 * time.Millisecond}}
+	strategy := ParallelStrategy{Delay: 20 * time.Millisecond}
+	start := time.Now()
+	conn, err := strategy.Dial(context.Background(), "example.com", []string{"10.0.0.1", "10.0.0.2", "::1"}, dial.dial)
+	require.Nil(t, err)
+	defer conn.Close()
+	require.Equal(t, "10.0.0.2", conn.(*testConn).ip)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+	require.Equal(t, []string{"10.0.0.1", "::1", "10.0.0.2"}, dial.dialedIPs())
+
+	// without delay all the ips are dialed at once and the losers are closed
+	dial = &testDial{delays: map[string]time.Duration{"10.0.0.1": 100 * time.Millisecond, "10.0.0.2": 10 * time.Millisecond}, uncancelable: true}
+	conn, err = ParallelStrategy{}.Dial(context.Background(), "example.com", []string{"10.0.0.1", "10.0.0.2"}, dial.dial)
+	require.Nil(t, err)
+	require.Equal(t, "10.0.0.2", conn.(*testConn).ip)
+	require.Len(t, dial.dialedIPs(), 2)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&dial.closed) == 1 }, time.Second, 10*time.Millisecond)
+	conn.Close()
+
+	// failures start the next attempt right away
+	refused := errors.New("refused")
+	dial = &testDial{errs: map[string]error{"10.0.0.1": refused, "10.0.0.2": refused}}
+	strategy = ParallelStrategy{Delay: time.Hour}
+	_, err = strategy.Dial(context.Background(), "example.com", []string{"10.0.0.1", "10.0.0.2"}, dial.dial)
+	require.ErrorIs(t, err, refused)
+	require.Len(t, dial.dialedIPs(), 2)
+}
+
+func TestStickyStrategy(t *testing.T) {
+	refused := errors.New("refused")
+	dial := &testDial{errs: map[string]error{"10.0.0.1": refused}}
+	ips := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	strategy := &StickyStrategy{}
+
+	conn, err := strategy.Dial(context.Background(), "example.com", ips, dial.dial)
+	require.Nil(t, err)
+	require.Equal(t, "10.0.0.2", conn.(*testConn).ip)
+
+	// the working ip is dialed first by the following dials of the host
+	dial.dialed = nil
+	conn, err = strategy.Dial(context.Background(), "example.com", ips, dial.dial)
+	require.Nil(t, err)
+	require.Equal(t, "10.0.0.2", conn.(*testConn).ip)
+	require.Equal(t, []string{"10.0.0.2"}, dial.dialedIPs())
+
+	// other hosts start from the first ip
+	dial.dialed = nil
+	_, err = strategy.Dial(context.Background(), "example.org", ips, dial.dial)
+	require.Nil(t, err)
+	require.Equal(t, ips[:2], dial.dialedIPs())
+
+	// once the remembered ip fails the next working one is kept
+	dial.errs["10.0.0.2"] = refused
+	conn, err = strategy.Dial(context.Background(), "example.com", ips, dial.dial)
+	require.Nil(t, err)
+	require.Equal(t, "10.0.0.3", conn.(*testConn).ip)
+	dial.dialed = nil
+	_, err = strategy.Dial(context.Background(), "example.com", ips, dial.dial)
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.3"}, dial.dialedIPs())
+}
+
+func TestAdaptiveStrategy(t *testing.T) {
+	refused := errors.New("refused")
+	dial := &testDial{errs: map[string]error{}}
+	ips := []string{"10.0.0.1", "10.0.0.2"}
+	strategy := &AdaptiveStrategy{}
+
+	var first []string
+	for i := 0; i < 10; i++ {
+		// the first ip fails every other dial
+		if i%2 == 1 {
+			dial.errs["10.0.0.1"] = refused
+		} else {
+			delete(dial.errs, "10.0.0.1")
+		}
+		dial.dialed = nil
+		_, err := strategy.Dial(context.Background(), "example.com", ips, dial.dial)
+		require.Nil(t, err)
+		first = append(first, dial.dialedIPs()[0])
+	}
+	// the resolution order is kept until the first failure, then the reliable ip is favored
+	require.Equal(t, []string{"10.0.0.1", "10.0.0.1"}, first[:2])
+	for _, ip := range first[2:] {
+		require.Equal(t, "10.0.0.2", ip)
+	}
+	unreliable, reliable := strategy.Stats("10.0.0.1"), strategy.Stats("10.0.0.2")
+	require.Equal(t, AdaptiveStats{Successes: 1, Failures: 1, Score: unreliable.Score}, unreliable)
+	require.Equal(t, 9, reliable.Successes)
+	require.Less(t, unreliable.Score, reliable.Score)
+
+	// once the favored ip fails repeatedly the other one takes over
+	dial.errs = map[string]error{"10.0.0.2": refused}
+	for i := 0; i < 3; i++ {
+		_, err := strategy.Dial(context.Background(), "example.com", ips, dial.dial)
+		require.Nil(t, err)
+	}
+	dial.dialed = nil
+	_, err := strategy.Dial(context.Background(), "example.com", ips, dial.dial)
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, dial.dialedIPs())
+
+	// attempts interrupted by the context aren't counted
+	dial = &testDial{delays: map[string]time.Duration{"10.0.0.3": time.Second}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = strategy.Dial(ctx, "example.com", []string{"10.0.0.3"}, dial.dial)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, AdaptiveStats{Score: 0.5}, strategy.Stats("10.0.0.3"))
+
+	// only the most recently dialed ips are tracked
+	bounded := &AdaptiveStrategy{MaxIPs: 2}
+	dial = &testDial{}
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.1", "10.0.0.3"} {
+		_, err := bounded.Dial(context.Background(), "example.com", []string{ip}, dial.dial)
+		require.Nil(t, err)
+	}
+	require.Equal(t, 2, bounded.Stats("10.0.0.1").Successes)
+	require.Equal(t, AdaptiveStats{Score: 0.5}, bounded.Stats("10.0.0.2"))
+	require.Equal(t, 1, bounded.Stats("10.0.0.3").Successes)
+	require.Len(t, bounded.stats, 2)
+}
+
+func TestDialStrategy(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	// nothing listens on 127.0.0.2 at the port
+	resolver := &mockResolver{resolve: staticAnswer([]string{"127.0.0.2", "127.0.0.1"}, nil)}
+	options := DefaultOptions
+	options.DialStrategy = &StickyStrategy{}
+	fd := newTestDialer(t, options, resolver)
+
+	for i := 0; i < 2; i++ {
+		conn, info, err := fd.DialWithInfo(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+		require.Nil(t, err)
+		conn.Close()
+		require.Equal(t, "127.0.0.1", info.IP)
+	}
+
+	// connections not established through the dial function are rejected
+	options.DialStrategy = rogueStrategy{}
+	fd = newTestDialer(t, options, resolver)
+	_, err = fd.Dial(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+	require.ErrorIs(t, err, CouldNotConnectError)
+
+	// the adaptive strategy of the dialer keeps its stats across dials
+	options.DialStrategy = nil
+	options.AdaptiveSelection = true
+	fd = newTestDialer(t, options, resolver)
+	for i := 0; i < 2; i++ {
+		conn, info, err := fd.DialWithInfo(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+		require.Nil(t, err)
+		conn.Close()
+		require.Equal(t, "127.0.0.1", info.IP)
+	}
+	require.Equal(t, 1, fd.adaptive.Stats("127.0.0.2").Failures)
+	require.Equal(t, 2, fd.adaptive.Stats("127.0.0.1").Successes)
+}
+
+// rogueStrategy returns a connection of its own
+type rogueStrategy struct{}
+
+func (rogueStrategy) Dial(ctx context.Context, hostname string, ips []string, dial DialFunc) (net.Conn, error) {
+	client, server := net.Pipe()
+	server.Close()
+	return client, nil
+}
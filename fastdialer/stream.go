@@ -0,0 +1,51 @@
+package fastdialer
+
+import (
+	"context"
+	"sync"
+
+	retryabledns "github.com/boss-net/retryabledns"
+)
+
+// ResolveResult is the outcome of the resolution of a hostname read by ResolveStream
+type ResolveResult struct {
+	Host string
+	Data *retryabledns.DNSData
+	Err  error
+}
+
+// ResolveStream resolves the hostnames read from in through the dns cache, with at most
+// concurrency resolutions at once, and sends their results to out in completion order.
+// It returns once in is closed and drained or the context is done, closing out
+func (d *Dialer) ResolveStream(ctx context.Context, in <-chan string, out chan<- ResolveResult, concurrency int) {
+	defer close(out)
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var host string
+				var ok bool
+				select {
+				case <-ctx.Done():
+					return
+				case host, ok = <-in:
+					if !ok {
+						return
+					}
+				}
+				data, err := d.getDNSData(ctx, host)
+				select {
+				case <-ctx.Done():
+					return
+				case out <- ResolveResult{Host: host, Data: data, Err: err}:
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
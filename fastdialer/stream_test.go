@@ -0,0 +1,49 @@
+package fastdialer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	retryabledns "github.com/boss-net/retryabledns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveStream(t *testing.T) {
+	resolver := &mockResolver{resolve: func(host string) (*retryabledns.DNSData, error) {
+		if strings.HasPrefix(host, "missing") {
+			return nil, fmt.Errorf("no such host %s", host)
+		}
+		return &retryabledns.DNSData{Host: host, A: []string{"10.0.0.1"}}, nil
+	}}
+	fd := newTestDialer(t, DefaultOptions, resolver)
+
+	in := make(chan string)
+	out := make(chan ResolveResult)
+	go fd.ResolveStream(context.Background(), in, out, 4)
+	go func() {
+		for i := 0; i < 100; i++ {
+			in <- fmt.Sprintf("host%d.example.com", i)
+		}
+		in <- "missing.example.com"
+		close(in)
+	}()
+
+	results := map[string]ResolveResult{}
+	for result := range out {
+		results[result.Host] = result
+	}
+	require.Len(t, results, 101)
+	require.Nil(t, results["host42.example.com"].Err)
+	require.Equal(t, []string{"10.0.0.1"}, results["host42.example.com"].Data.A)
+	require.NotNil(t, results["missing.example.com"].Err)
+
+	// a canceled stream stops and closes the output
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out = make(chan ResolveResult)
+	fd.ResolveStream(ctx, make(chan string), out, 2)
+	_, ok := <-out
+	require.False(t, ok)
+}
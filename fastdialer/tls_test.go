@@ -0,0 +1,507 @@
+package fastdialer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/networkpolicy"
+	"github.com/stretchr/testify/require"
+)
+
+var errPinMismatch = errors.New("spki pin mismatch")
+
+// pinSPKI returns a connection verifier accepting only the given leaf public key
+func pinSPKI(cert tls.Certificate) func(tls.ConnectionState) error {
+	pin := sha256.Sum256(cert.Leaf.RawSubjectPublicKeyInfo)
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errPinMismatch
+		}
+		got := sha256.Sum256(state.PeerCertificates[0].RawSubjectPublicKeyInfo)
+		if !bytes.Equal(pin[:], got[:]) {
+			return errPinMismatch
+		}
+		return nil
+	}
+}
+
+func TestVerifyConnection(t *testing.T) {
+	serverCert := newTestCertificate(t)
+	address := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	_, port, _ := net.SplitHostPort(address)
+	target := net.JoinHostPort("localhost", port)
+	resolver := &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)}
+
+	t.Run("pinned key accepted", func(t *testing.T) {
+		options := DefaultOptions
+		options.VerifyConnection = pinSPKI(serverCert)
+		fd := newTestDialer(t, options, resolver)
+
+		conn, err := fd.DialTLS(context.Background(), "tcp", target)
+		require.Nil(t, err)
+		conn.Close()
+	})
+
+	t.Run("mismatched key rejected", func(t *testing.T) {
+		options := DefaultOptions
+		options.VerifyConnection = pinSPKI(newTestCertificate(t))
+		fd := newTestDialer(t, options, resolver)
+
+		_, err := fd.DialTLS(context.Background(), "tcp", target)
+		require.ErrorIs(t, err, errPinMismatch)
+	})
+
+	t.Run("mismatched key rejected with verification", func(t *testing.T) {
+		options := DefaultOptions
+		options.VerifyConnection = pinSPKI(newTestCertificate(t))
+		fd := newTestDialer(t, options, resolver)
+
+		roots := x509.NewCertPool()
+		roots.AddCert(serverCert.Leaf)
+		_, err := fd.DialTLSWithConfig(context.Background(), "tcp", target, &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12})
+		require.ErrorIs(t, err, errPinMismatch)
+	})
+
+	t.Run("mismatched key rejected with impersonation", func(t *testing.T) {
+		options := DefaultOptions
+		options.VerifyConnection = pinSPKI(newTestCertificate(t))
+		fd := newTestDialer(t, options, resolver)
+
+		_, err := fd.DialTLSFingerprint(context.Background(), "tcp", target, "chrome")
+		require.ErrorIs(t, err, errPinMismatch)
+	})
+
+	t.Run("ztls rejected", func(t *testing.T) {
+		// ztls can't run the verification, even a matching pin isn't accepted without it
+		options := DefaultOptions
+		options.WithZTLS = true
+		options.VerifyConnection = pinSPKI(serverCert)
+		fd := newTestDialer(t, options, resolver)
+
+		_, err := fd.DialTLS(context.Background(), "tcp", target)
+		require.ErrorIs(t, err, VerifyNotSupportedError)
+		_, err = fd.DialZTLS(context.Background(), "tcp", target)
+		require.ErrorIs(t, err, VerifyNotSupportedError)
+		_, err = fd.DialWithIPs(context.Background(), "tcp", "localhost", []string{"127.0.0.1"}, port, true)
+		require.ErrorIs(t, err, VerifyNotSupportedError)
+	})
+}
+
+func TestGetOCSPResponse(t *testing.T) {
+	staple := []byte("stapled ocsp response")
+	stapledCert := newTestCertificate(t)
+	stapledCert.OCSPStaple = staple
+	stapled := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{stapledCert}})
+	notStapled := newTestTLSServer(t, &tls.Config{})
+
+	resolver := &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)}
+	fd := newTestDialer(t, DefaultOptions, resolver)
+
+	_, port, _ := net.SplitHostPort(stapled)
+	response, err := fd.GetOCSPResponse(context.Background(), net.JoinHostPort("localhost", port))
+	require.Nil(t, err)
+	require.Equal(t, staple, response)
+
+	response, err = fd.GetOCSPResponse(context.Background(), notStapled)
+	require.Nil(t, err)
+	require.Nil(t, response)
+}
+
+func TestWarmTLS(t *testing.T) {
+	for name, version := range map[string]uint16{"tls12": tls.VersionTLS12, "tls13": tls.VersionTLS13} {
+		version := version
+		t.Run(name, func(t *testing.T) {
+			address := newTestTLSServer(t, &tls.Config{MinVersion: version, MaxVersion: version})
+			options := DefaultOptions
+			options.TLSSessionCacheSize = 16
+			fd := newTestDialer(t, options, &mockResolver{})
+
+			require.Nil(t, fd.WarmTLS(context.Background(), []string{address}))
+
+			conn, err := fd.DialTLS(context.Background(), "tcp", address)
+			require.Nil(t, err)
+			defer conn.Close()
+			require.True(t, conn.(*tls.Conn).ConnectionState().DidResume)
+		})
+	}
+
+	t.Run("network policy", func(t *testing.T) {
+		address := newTestTLSServer(t, &tls.Config{})
+		options := DefaultOptions
+		options.TLSSessionCacheSize = 16
+		options.Deny = []string{"127.0.0.0/8"}
+		fd := newTestDialer(t, options, &mockResolver{})
+		require.ErrorIs(t, fd.WarmTLS(context.Background(), []string{address}), ErrAllBlocked)
+	})
+
+	t.Run("cache disabled", func(t *testing.T) {
+		fd := newTestDialer(t, DefaultOptions, &mockResolver{})
+		require.ErrorIs(t, fd.WarmTLS(context.Background(), []string{"127.0.0.1:443"}), TLSSessionCacheDisabledError)
+	})
+}
+
+func TestProbeTLS(t *testing.T) {
+	cert := newTestCertificate(t)
+	address := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2", "http/1.1"}, MinVersion: tls.VersionTLS13})
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{})
+
+	result, err := fd.ProbeTLS(context.Background(), address, []string{"h2", "http/1.1"})
+	require.Nil(t, err)
+	require.Equal(t, "h2", result.NegotiatedProtocol)
+	require.Equal(t, uint16(tls.VersionTLS13), result.Version)
+	require.NotZero(t, result.CipherSuite)
+	require.Len(t, result.PeerCertificates, 1)
+	require.Equal(t, cert.Leaf.Raw, result.PeerCertificates[0].Raw)
+
+	result, err = fd.ProbeTLS(context.Background(), address, []string{"http/1.1"})
+	require.Nil(t, err)
+	require.Equal(t, "http/1.1", result.NegotiatedProtocol)
+}
+
+func TestDialTLSState(t *testing.T) {
+	cert := newTestCertificate(t)
+	cert.OCSPStaple = []byte("ocsp response")
+	cert.SignedCertificateTimestamps = [][]byte{[]byte("sct")}
+	address := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2"}})
+	_, port, _ := net.SplitHostPort(address)
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+
+	config := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}}
+	conn, state, err := fd.DialTLSState(context.Background(), "tcp", net.JoinHostPort("example.com", port), config)
+	require.Nil(t, err)
+	defer conn.Close()
+	require.True(t, state.HandshakeComplete)
+	require.Equal(t, uint16(tls.VersionTLS13), state.Version)
+	require.NotZero(t, state.CipherSuite)
+	require.Equal(t, "example.com", state.ServerName)
+	require.Equal(t, "h2", state.NegotiatedProtocol)
+	require.Len(t, state.PeerCertificates, 1)
+	require.Equal(t, cert.Leaf.Raw, state.PeerCertificates[0].Raw)
+	require.Equal(t, cert.OCSPStaple, state.OCSPResponse)
+	require.Equal(t, cert.SignedCertificateTimestamps, state.SignedCertificateTimestamps)
+
+	// the dial went through the cache and the network policy
+	fd.networkpolicy, err = networkpolicy.New(networkpolicy.Options{DenyList: []string{"127.0.0.1"}})
+	require.Nil(t, err)
+	_, _, err = fd.DialTLSState(context.Background(), "tcp", net.JoinHostPort("example.com", port), nil)
+	require.ErrorIs(t, err, ErrAllBlocked)
+	require.Equal(t, int32(1), atomic.LoadInt32(&fd.dnsclient.(*mockResolver).resolveCalls))
+}
+
+func TestDialTLSVerify(t *testing.T) {
+	cert := newTestCertificate(t)
+	address := newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	_, port, _ := net.SplitHostPort(address)
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+
+	conn, result, err := fd.DialTLSVerify(context.Background(), "tcp", net.JoinHostPort("localhost", port), roots)
+	require.Nil(t, err)
+	conn.Close()
+	require.True(t, result.Valid)
+	require.Nil(t, result.VerifyError)
+	require.Len(t, result.VerifiedChains, 1)
+	require.True(t, result.State.HandshakeComplete)
+
+	// ip addresses are verified against the ip names of the certificate
+	conn, result, err = fd.DialTLSVerify(context.Background(), "tcp", address, roots)
+	require.Nil(t, err)
+	conn.Close()
+	require.True(t, result.Valid)
+
+	// the self signed certificate isn't trusted by the system roots
+	conn, result, err = fd.DialTLSVerify(context.Background(), "tcp", net.JoinHostPort("localhost", port), nil)
+	require.Nil(t, err)
+	conn.Close()
+	require.False(t, result.Valid)
+	var authorityErr x509.UnknownAuthorityError
+	require.ErrorAs(t, result.VerifyError, &authorityErr)
+
+	// nor valid for other names
+	conn, result, err = fd.DialTLSVerify(context.Background(), "tcp", net.JoinHostPort("example.com", port), roots)
+	require.Nil(t, err)
+	conn.Close()
+	require.False(t, result.Valid)
+	var hostnameErr x509.HostnameError
+	require.ErrorAs(t, result.VerifyError, &hostnameErr)
+	require.Empty(t, result.VerifiedChains)
+}
+
+func TestWithTLSConfig(t *testing.T) {
+	serverNames := make(chan string, 2)
+	address := newTestTLSServer(t, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverNames <- hello.ServerName
+			return nil, nil
+		},
+	})
+	_, port, _ := net.SplitHostPort(address)
+	target := net.JoinHostPort("example.com", port)
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+
+	config := &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12, ServerName: "custom.example.com"}
+	conn, err := fd.DialTLS(WithTLSConfig(context.Background(), config), "tcp", target)
+	require.Nil(t, err)
+	require.Equal(t, uint16(tls.VersionTLS12), conn.(*tls.Conn).ConnectionState().Version)
+	conn.Close()
+	require.Equal(t, "custom.example.com", <-serverNames)
+
+	// an empty server name is set to the hostname
+	config = &tls.Config{InsecureSkipVerify: true}
+	conn, err = fd.DialTLS(WithTLSConfig(context.Background(), config), "tcp", target)
+	require.Nil(t, err)
+	conn.Close()
+	require.Equal(t, "example.com", <-serverNames)
+}
+
+// newRenegotiatingServer starts an openssl tls 1.2 server and returns its address along with
+// a function requesting a renegotiation then sending the message once it completed
+func newRenegotiatingServer(t *testing.T) (string, func(message string)) {
+	t.Helper()
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl not available")
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	genCert := exec.Command("openssl", "req", "-x509", "-newkey", "rsa:2048", "-nodes", "-days", "1", "-subj", "/CN=localhost", "-keyout", keyFile, "-out", certFile)
+	require.Nil(t, genCert.Run())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+	server := exec.Command("openssl", "s_server", "-tls1_2", "-accept", address, "-cert", certFile, "-key", keyFile)
+	stdin, err := server.StdinPipe()
+	require.Nil(t, err)
+	stdout, err := server.StdoutPipe()
+	require.Nil(t, err)
+	require.Nil(t, server.Start())
+	t.Cleanup(func() {
+		_ = server.Process.Kill()
+		_ = server.Wait()
+	})
+	lines := bufio.NewScanner(stdout)
+	waitFor := func(marker string) {
+		for lines.Scan() {
+			if strings.Contains(lines.Text(), marker) {
+				return
+			}
+		}
+	}
+	waitFor("ACCEPT")
+
+	return address, func(message string) {
+		// the connection is established, s_server commands are read from stdin
+		waitFor("CIPHER is")
+		_, _ = stdin.Write([]byte("r\n"))
+		go func() {
+			waitFor("SSL_do_handshake -> 1")
+			_, _ = stdin.Write([]byte(message + "\n"))
+		}()
+	}
+}
+
+func TestRenegotiation(t *testing.T) {
+	// the default is the same whether the options start from DefaultOptions or not
+	for _, options := range []Options{DefaultOptions, {}} {
+		options := options
+		fd := &Dialer{options: &options}
+		require.Equal(t, tls.RenegotiateNever, fd.defaultTLSConfig().Renegotiation)
+	}
+
+	for _, renegotiation := range []tls.RenegotiationSupport{tls.RenegotiateNever, tls.RenegotiateOnceAsClient} {
+		address, renegotiate := newRenegotiatingServer(t)
+		options := DefaultOptions
+		options.Renegotiation = renegotiation
+		fd := newTestDialer(t, options, &mockResolver{})
+
+		conn, err := fd.DialTLS(context.Background(), "tcp", address)
+		require.Nil(t, err)
+		renegotiate("hello")
+		require.Nil(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(conn, buf)
+		conn.Close()
+		if renegotiation == tls.RenegotiateNever {
+			require.ErrorContains(t, err, "no renegotiation")
+			continue
+		}
+		require.Nil(t, err)
+		require.Equal(t, "hello", string(buf))
+	}
+}
+
+func TestSNIMap(t *testing.T) {
+	serverNames := make(chan string, 1)
+	address := newTestTLSServer(t, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverNames <- hello.ServerName
+			return nil, nil
+		},
+	})
+	_, port, _ := net.SplitHostPort(address)
+	options := DefaultOptions
+	options.SNIName = "default.example.com"
+	options.SNIMap = map[string]string{"origin.example.com": "cdn.example.net"}
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+
+	tests := map[string]string{
+		"origin.example.com": "cdn.example.net",
+		"other.example.com":  "default.example.com",
+	}
+	for hostname, want := range tests {
+		conn, err := fd.DialTLS(context.Background(), "tcp", net.JoinHostPort(hostname, port))
+		require.Nil(t, err, hostname)
+		conn.Close()
+		require.Equal(t, want, <-serverNames, hostname)
+	}
+
+	// the hostname is sent when neither is set
+	fd.options.SNIName = ""
+	conn, err := fd.DialTLS(context.Background(), "tcp", net.JoinHostPort("other.example.com", port))
+	require.Nil(t, err)
+	conn.Close()
+	require.Equal(t, "other.example.com", <-serverNames)
+}
+
+func TestDefaultTLSConfig(t *testing.T) {
+	serverCert := newTestCertificate(t)
+	_, port, err := net.SplitHostPort(newTestTLSServer(t, &tls.Config{Certificates: []tls.Certificate{serverCert}}))
+	require.Nil(t, err)
+	target := net.JoinHostPort("localhost", port)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert.Leaf)
+	var verified int32
+	options := DefaultOptions
+	options.DefaultTLSConfig = &tls.Config{
+		// replaced by the dialed hostname
+		ServerName: "other.example.com",
+		RootCAs:    roots,
+		MinVersion: tls.VersionTLS12,
+		MaxVersion: tls.VersionTLS12,
+		VerifyConnection: func(tls.ConnectionState) error {
+			atomic.AddInt32(&verified, 1)
+			return nil
+		},
+	}
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)})
+
+	conn, state, err := fd.DialTLSState(context.Background(), "tcp", target, nil)
+	require.Nil(t, err)
+	conn.Close()
+	require.Equal(t, "localhost", state.ServerName)
+	require.Equal(t, uint16(tls.VersionTLS12), state.Version)
+	require.Equal(t, int32(1), atomic.LoadInt32(&verified))
+	require.Equal(t, "other.example.com", options.DefaultTLSConfig.ServerName)
+
+	// per call configs replace it
+	conn, state, err = fd.DialTLSState(context.Background(), "tcp", target, &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13})
+	require.Nil(t, err)
+	conn.Close()
+	require.Equal(t, uint16(tls.VersionTLS13), state.Version)
+	require.Equal(t, int32(1), atomic.LoadInt32(&verified))
+}
+
+func TestHandshakeDuration(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	serverCert := newTestCertificate(t)
+	slow := newTestTLSServer(t, &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			time.Sleep(delay)
+			return &serverCert, nil
+		},
+	})
+	_, port, err := net.SplitHostPort(slow)
+	require.Nil(t, err)
+	address := net.JoinHostPort("localhost", port)
+	resolver := &mockResolver{resolve: staticAnswer([]string{"127.0.0.1"}, nil)}
+
+	proxied := DefaultOptions
+	proxied.ProxyChain = []string{"socks5://" + newSocks5Server(t).address}
+	tests := []struct {
+		name    string
+		options Options
+		dial    func(fd *Dialer, ctx context.Context) (net.Conn, error)
+	}{
+		{"tls", DefaultOptions, func(fd *Dialer, ctx context.Context) (net.Conn, error) {
+			return fd.DialTLS(ctx, "tcp", address)
+		}},
+		{"impersonate", DefaultOptions, func(fd *Dialer, ctx context.Context) (net.Conn, error) {
+			return fd.DialTLSFingerprint(ctx, "tcp", address, "chrome")
+		}},
+		{"proxy", proxied, func(fd *Dialer, ctx context.Context) (net.Conn, error) {
+			return fd.DialTLS(ctx, "tcp", address)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fd := newTestDialer(t, tt.options, resolver)
+			info := &DialInfo{}
+			conn, err := tt.dial(fd, withDialInfo(context.Background(), info))
+			require.Nil(t, err)
+			conn.Close()
+			require.GreaterOrEqual(t, info.HandshakeDuration, delay)
+			require.Positive(t, info.ConnectDuration)
+			require.Less(t, info.ConnectDuration, delay)
+		})
+	}
+}
+
+func TestUpgradeTLS(t *testing.T) {
+	serverCert := newTestCertificate(t)
+	serverNames := make(chan string, 1)
+	upgrade := func(fd *Dialer, config *tls.Config) (net.Conn, error) {
+		client, server := net.Pipe()
+		t.Cleanup(func() { server.Close() })
+		go func() {
+			tlsConn := tls.Server(server, &tls.Config{
+				Certificates: []tls.Certificate{serverCert},
+				GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+					serverNames <- hello.ServerName
+					return nil, nil
+				},
+			})
+			_, _ = io.Copy(tlsConn, tlsConn)
+		}()
+		return fd.UpgradeTLS(context.Background(), client, "example.com", config)
+	}
+
+	fd := newTestDialer(t, DefaultOptions, &mockResolver{})
+	conn, err := upgrade(fd, nil)
+	require.Nil(t, err)
+	defer conn.Close()
+	require.Equal(t, "example.com", <-serverNames)
+	_, err = conn.Write([]byte("hello"))
+	require.Nil(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(buf))
+
+	// the certificate is verified with the given config
+	_, err = upgrade(fd, &tls.Config{MinVersion: tls.VersionTLS12})
+	require.NotNil(t, err)
+	<-serverNames
+
+	// the tls options of the dialer apply as well
+	options := DefaultOptions
+	options.VerifyConnection = pinSPKI(newTestCertificate(t))
+	fd = newTestDialer(t, options, &mockResolver{})
+	_, err = upgrade(fd, nil)
+	require.ErrorIs(t, err, errPinMismatch)
+}
@@ -0,0 +1,87 @@
+package fastdialer
+
+import (
+	"time"
+
+	retryabledns "github.com/boss-net/retryabledns"
+)
+
+// expiresAt returns when the dns data expires, the record ttl being clamped between
+// Options.MinCacheTTL and Options.MaxCacheTTL. Hosts file entries, like the ones set without
+// a resolution timestamp, never expire
+func (d *Dialer) expiresAt(data *retryabledns.DNSData) (time.Time, bool) {
+	if data.HostsFile || data.Timestamp.IsZero() {
+		return time.Time{}, false
+	}
+	ttl := time.Duration(data.TTL) * time.Second
+	if d.options.MaxCacheTTL > 0 && ttl > d.options.MaxCacheTTL {
+		ttl = d.options.MaxCacheTTL
+	}
+	if ttl < d.options.MinCacheTTL {
+		ttl = d.options.MinCacheTTL
+	}
+	return data.Timestamp.Add(ttl), true
+}
+
+// IsStale checks if the record ttl of the dns data elapsed
+func (d *Dialer) IsStale(data *retryabledns.DNSData) bool {
+	expiry, ok := d.expiresAt(data)
+	return ok && !time.Now().Before(expiry)
+}
+
+// shouldRefresh checks if the cached dns data must be resolved again: once its record ttl
+// elapsed with Options.RespectTTL, otherwise once Options.MaxCacheTTL elapsed, if set
+func (d *Dialer) shouldRefresh(data *retryabledns.DNSData) bool {
+	if d.options.RespectTTL {
+		return d.IsStale(data)
+	}
+	if d.options.MaxCacheTTL <= 0 || data.HostsFile || data.Timestamp.IsZero() {
+		return false
+	}
+	return !time.Now().Before(data.Timestamp.Add(d.options.MaxCacheTTL))
+}
+
+// FlushExpired removes the cached dns data whose record ttl elapsed, see IsStale, and returns
+// the number of removed entries. Caches set with Options.Cache must implement CacheScanner
+func (d *Dialer) FlushExpired() (int, error) {
+	scanner, ok := cacheScanner(d.hm)
+	if !ok {
+		return 0, CacheNotScannableError
+	}
+	// the entries are removed once the scan is over
+	var expired []string
+	scanner.Scan(func(key, value []byte) error {
+		var data retryabledns.DNSData
+		if err := data.Unmarshal(value); err == nil && d.IsStale(&data) {
+			expired = append(expired, string(key))
+		}
+		return nil
+	})
+	for i, key := range expired {
+		if err := d.hm.Delete(key); err != nil {
+			return i, err
+		}
+	}
+	return len(expired), nil
+}
+
+// addresses returns the A and AAAA records of the dns data
+func addresses(data *retryabledns.DNSData) []string {
+	return append(append([]string{}, data.A...), data.AAAA...)
+}
+
+// sameAddresses checks if both lists contain the same set of addresses regardless of the order
+func sameAddresses(a, b []string) bool {
+	set := make(map[string]struct{}, len(a))
+	for _, ip := range a {
+		set[ip] = struct{}{}
+	}
+	other := make(map[string]struct{}, len(b))
+	for _, ip := range b {
+		if _, ok := set[ip]; !ok {
+			return false
+		}
+		other[ip] = struct{}{}
+	}
+	return len(set) == len(other)
+}
@@ -0,0 +1,206 @@
+package fastdialer
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	retryabledns "github.com/boss-net/retryabledns"
+	"github.com/stretchr/testify/require"
+)
+
+// setCachedData stores dns data resolved at the given time in the dialer cache
+func setCachedData(t *testing.T, fd *Dialer, data *retryabledns.DNSData) {
+	t.Helper()
+	b, err := data.Marshal()
+	require.Nil(t, err)
+	require.Nil(t, fd.hm.Set(data.Host, b))
+}
+
+func TestRespectTTL(t *testing.T) {
+	resolver := &mockResolver{resolve: staticAnswer([]string{"10.0.0.2"}, nil)}
+	options := DefaultOptions
+	options.RespectTTL = true
+	fd := newTestDialer(t, options, resolver)
+
+	setCachedData(t, fd, &retryabledns.DNSData{Host: "fresh.example.com", A: []string{"10.0.0.1"}, TTL: 300, Timestamp: time.Now()})
+	setCachedData(t, fd, &retryabledns.DNSData{Host: "expired.example.com", A: []string{"10.0.0.1"}, TTL: 60, Timestamp: time.Now().Add(-time.Hour)})
+
+	data, err := fd.GetDNSData("fresh.example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, data.A)
+	require.False(t, fd.IsStale(data))
+	require.Zero(t, atomic.LoadInt32(&resolver.resolveCalls))
+
+	data, err = fd.GetDNSData("expired.example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.2"}, data.A)
+	require.Equal(t, int32(1), atomic.LoadInt32(&resolver.resolveCalls))
+
+	// without a resolution timestamp entries never expire
+	require.False(t, fd.IsStale(&retryabledns.DNSData{A: []string{"10.0.0.1"}}))
+
+	// the syscall fallback answers expire as well
+	resolver = &mockResolver{resolve: staticAnswer(nil, nil), syscall: staticAnswer([]string{"10.0.0.3"}, nil)}
+	options.EnableFallback = true
+	options.FallbackCondition = FallbackOnEmpty
+	fd = newTestDialer(t, options, resolver)
+	data, err = fd.GetDNSData("fallback.example.com")
+	require.Nil(t, err)
+	require.False(t, fd.IsStale(data))
+	expired := *data
+	expired.Timestamp = time.Now().Add(-time.Hour)
+	require.True(t, fd.IsStale(&expired))
+	setCachedData(t, fd, &expired)
+	_, err = fd.GetDNSData("fallback.example.com")
+	require.Nil(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&resolver.syscallCalls))
+}
+
+func TestServeStaleOnError(t *testing.T) {
+	_, port, err := net.SplitHostPort(newTestTCPServer(t))
+	require.Nil(t, err)
+	stale := &retryabledns.DNSData{Host: "example.com", A: []string{"127.0.0.1"}, TTL: 60, Timestamp: time.Now().Add(-time.Hour)}
+
+	options := DefaultOptions
+	options.RespectTTL = true
+	options.ServeStaleOnError = true
+	fd := newTestDialer(t, options, &mockResolver{resolve: failingAnswer})
+	setCachedData(t, fd, stale)
+
+	data, err := fd.GetDNSData("example.com")
+	require.Nil(t, err)
+	require.True(t, fd.IsStale(data))
+	conn, info, err := fd.DialWithInfo(context.Background(), "tcp", net.JoinHostPort("example.com", port))
+	require.Nil(t, err)
+	conn.Close()
+	require.Equal(t, "127.0.0.1", info.IP)
+	require.True(t, info.Stale)
+
+	// undecodable entries aren't served
+	fd = newTestDialer(t, options, &mockResolver{resolve: failingAnswer})
+	require.Nil(t, fd.hm.Set("example.com", []byte("corrupt")))
+	_, err = fd.GetDNSData("example.com")
+	require.ErrorIs(t, err, ResolveHostError)
+
+	options.ServeStaleOnError = false
+	fd = newTestDialer(t, options, &mockResolver{resolve: failingAnswer})
+	setCachedData(t, fd, stale)
+	_, err = fd.GetDNSData("example.com")
+	require.NotNil(t, err)
+}
+
+func TestOnDNSChange(t *testing.T) {
+	type change struct {
+		hostname string
+		old, new []string
+	}
+	var changes []change
+	options := DefaultOptions
+	options.RespectTTL = true
+	options.OnDNSChange = func(hostname string, old, new []string) {
+		changes = append(changes, change{hostname: hostname, old: old, new: new})
+	}
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"10.0.0.2", "10.0.0.1"}, []string{"fd00::1"})})
+	expired := time.Now().Add(-time.Hour)
+
+	// same set in a different order
+	setCachedData(t, fd, &retryabledns.DNSData{Host: "same.example.com", A: []string{"10.0.0.1", "10.0.0.2"}, AAAA: []string{"fd00::1"}, TTL: 60, Timestamp: expired})
+	_, err := fd.GetDNSData("same.example.com")
+	require.Nil(t, err)
+	require.Empty(t, changes)
+
+	setCachedData(t, fd, &retryabledns.DNSData{Host: "moved.example.com", A: []string{"10.0.0.1"}, TTL: 60, Timestamp: expired})
+	_, err = fd.GetDNSData("moved.example.com")
+	require.Nil(t, err)
+	require.Equal(t, []change{{hostname: "moved.example.com", old: []string{"10.0.0.1"}, new: []string{"10.0.0.2", "10.0.0.1", "fd00::1"}}}, changes)
+
+	// first resolutions aren't changes
+	_, err = fd.GetDNSData("new.example.com")
+	require.Nil(t, err)
+	require.Len(t, changes, 1)
+}
+
+func TestCacheTTLBounds(t *testing.T) {
+	options := DefaultOptions
+	options.RespectTTL = true
+	options.MinCacheTTL = time.Minute
+	options.MaxCacheTTL = time.Hour
+	resolver := &mockResolver{resolve: staticAnswer([]string{"10.0.0.2"}, nil)}
+	fd := newTestDialer(t, options, resolver)
+	now := time.Now()
+
+	tests := []struct {
+		ttl  uint32
+		want time.Duration
+	}{
+		{ttl: 1, want: time.Minute},
+		{ttl: 300, want: 5 * time.Minute},
+		{ttl: 7 * 24 * 3600, want: time.Hour},
+	}
+	for _, tt := range tests {
+		expiry, ok := fd.expiresAt(&retryabledns.DNSData{TTL: tt.ttl, Timestamp: now})
+		require.True(t, ok)
+		require.Equal(t, now.Add(tt.want), expiry)
+	}
+
+	// a day old entry with a week long ttl is resolved again
+	setCachedData(t, fd, &retryabledns.DNSData{Host: "long.example.com", A: []string{"10.0.0.1"}, TTL: 7 * 24 * 3600, Timestamp: now.Add(-24 * time.Hour)})
+	data, err := fd.GetDNSData("long.example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.2"}, data.A)
+
+	// a recent entry with a tiny ttl is still served from the cache
+	setCachedData(t, fd, &retryabledns.DNSData{Host: "short.example.com", A: []string{"10.0.0.1"}, TTL: 1, Timestamp: now.Add(-10 * time.Second)})
+	data, err = fd.GetDNSData("short.example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, data.A)
+	require.Equal(t, int32(1), atomic.LoadInt32(&resolver.resolveCalls))
+
+	// without RespectTTL the record ttl is ignored but the cap still applies
+	options.RespectTTL = false
+	options.MaxCacheTTL = time.Minute
+	resolver = &mockResolver{resolve: staticAnswer([]string{"10.0.0.2"}, nil)}
+	fd = newTestDialer(t, options, resolver)
+	setCachedData(t, fd, &retryabledns.DNSData{Host: "long.example.com", A: []string{"10.0.0.1"}, TTL: 7 * 24 * 3600, Timestamp: now.Add(-2 * time.Minute)})
+	data, err = fd.GetDNSData("long.example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.2"}, data.A)
+	setCachedData(t, fd, &retryabledns.DNSData{Host: "short.example.com", A: []string{"10.0.0.1"}, TTL: 1, Timestamp: now.Add(-10 * time.Second)})
+	data, err = fd.GetDNSData("short.example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, data.A)
+	require.Equal(t, int32(1), atomic.LoadInt32(&resolver.resolveCalls))
+}
+
+func TestFlushExpired(t *testing.T) {
+	options := DefaultOptions
+	options.L1CacheSize = 16
+	fd := newTestDialer(t, options, &mockResolver{resolve: staticAnswer([]string{"10.0.0.2"}, nil)})
+	now := time.Now()
+	setCachedData(t, fd, &retryabledns.DNSData{Host: "fresh.example.com", A: []string{"10.0.0.1"}, TTL: 300, Timestamp: now})
+	setCachedData(t, fd, &retryabledns.DNSData{Host: "expired.example.com", A: []string{"10.0.0.1"}, TTL: 60, Timestamp: now.Add(-time.Hour)})
+	setCachedData(t, fd, &retryabledns.DNSData{Host: "old.example.com", A: []string{"10.0.0.1"}, TTL: 1, Timestamp: now.Add(-time.Minute)})
+	// entries without a resolution timestamp never expire
+	setCachedData(t, fd, &retryabledns.DNSData{Host: "override.example.com", A: []string{"10.0.0.1"}})
+
+	removed, err := fd.FlushExpired()
+	require.Nil(t, err)
+	require.Equal(t, 2, removed)
+	for _, host := range []string{"fresh.example.com", "override.example.com"} {
+		_, err := fd.GetDNSDataFromCache(host)
+		require.Nil(t, err)
+	}
+	for _, host := range []string{"expired.example.com", "old.example.com"} {
+		_, err := fd.GetDNSDataFromCache(host)
+		require.ErrorIs(t, err, NoDNSDataError)
+	}
+
+	// custom caches must be scannable
+	options.Cache = &mapCache{items: make(map[string][]byte)}
+	fd = newTestDialer(t, options, &mockResolver{})
+	_, err = fd.FlushExpired()
+	require.ErrorIs(t, err, CacheNotScannableError)
+}
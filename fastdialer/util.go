@@ -2,7 +2,13 @@ package fastdialer
 
 import (
 	"crypto/tls"
+	"fmt"
+	"net/netip"
+	"strings"
 
+	iputil "github.com/boss-net/goutils/ip"
+	retryabledns "github.com/boss-net/retryabledns"
+	utls "github.com/refraction-networking/utls"
 	"github.com/ulule/deepcopier"
 	ztls "github.com/zmap/zcrypto/tls"
 	"golang.org/x/net/idna"
@@ -31,7 +37,102 @@ func IsTLS13(config interface{}) bool {
 	return false
 }
 
+// asTLSConnectionState converts the utls connection state to the standard library one
+func asTLSConnectionState(state utls.ConnectionState) tls.ConnectionState {
+	return tls.ConnectionState{
+		Version:                     state.Version,
+		HandshakeComplete:           state.HandshakeComplete,
+		DidResume:                   state.DidResume,
+		CipherSuite:                 state.CipherSuite,
+		NegotiatedProtocol:          state.NegotiatedProtocol,
+		ServerName:                  state.ServerName,
+		PeerCertificates:            state.PeerCertificates,
+		VerifiedChains:              state.VerifiedChains,
+		SignedCertificateTimestamps: state.SignedCertificateTimestamps,
+		OCSPResponse:                state.OCSPResponse,
+	}
+}
+
 func asAscii(hostname string) string {
 	hostnameAscii, _ := idna.ToASCII(hostname)
 	return hostnameAscii
 }
+
+// isLocalhost checks if the hostname is localhost or a subdomain of it
+func isLocalhost(hostname string) bool {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	return hostname == "localhost" || strings.HasSuffix(hostname, ".localhost")
+}
+
+// withoutZone strips the zone of a scoped ipv6 address
+func withoutZone(ip string) string {
+	if index := strings.IndexByte(ip, '%'); index >= 0 {
+		return ip[:index]
+	}
+	return ip
+}
+
+// filterNetworkFamily keeps the ips matching the address family of the network, if any
+func filterNetworkFamily(network string, ips []string) []string {
+	var wantIPv6 bool
+	switch {
+	case strings.HasSuffix(network, "4"):
+	case strings.HasSuffix(network, "6"):
+		wantIPv6 = true
+	default:
+		return ips
+	}
+	var filtered []string
+	for _, ip := range ips {
+		if iputil.IsIPv6(withoutZone(ip)) == wantIPv6 {
+			filtered = append(filtered, ip)
+		}
+	}
+	return filtered
+}
+
+// normalizeNetwork defaults an empty network to tcp and checks the network is supported,
+// tls requires a tcp network
+func normalizeNetwork(network string, useTLS bool) (string, error) {
+	switch network {
+	case "":
+		return "tcp", nil
+	case "tcp", "tcp4", "tcp6":
+		return network, nil
+	case "udp", "udp4", "udp6":
+		if !useTLS {
+			return network, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", UnsupportedNetworkError, network)
+}
+
+// unmapIPv4 returns the dotted form of an ipv4-mapped ipv6 address (::ffff:1.2.3.4), other
+// addresses are returned as is
+func unmapIPv4(ip string) string {
+	if addr, err := netip.ParseAddr(ip); err == nil && addr.Is4In6() {
+		return addr.Unmap().String()
+	}
+	return ip
+}
+
+// unmapRecords moves the ipv4-mapped addresses of the AAAA records, which may already be in
+// their ipv4 form, to the A ones so that they are classified and dialed as ipv4
+func unmapRecords(data *retryabledns.DNSData) *retryabledns.DNSData {
+	if data == nil {
+		return data
+	}
+	for i, ip := range data.A {
+		data.A[i] = unmapIPv4(ip)
+	}
+	var aaaa []string
+	for _, ip := range data.AAAA {
+		if unmapped := unmapIPv4(ip); unmapped != ip || iputil.IsIPv4(ip) {
+			data.A = append(data.A, unmapped)
+			continue
+		}
+		aaaa = append(aaaa, ip)
+	}
+	data.AAAA = aaaa
+	return data
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"testing"
 
+	retryabledns "github.com/boss-net/retryabledns"
 	"github.com/stretchr/testify/require"
 	ztls "github.com/zmap/zcrypto/tls"
 )
@@ -21,3 +22,30 @@ func TestAsZTLSConfig(t *testing.T) {
 	require.Nil(t, err)
 	require.NotNil(t, ztlsConfig)
 }
+
+func TestIsLocalhost(t *testing.T) {
+	for _, hostname := range []string{"localhost", "LOCALHOST", "localhost.", "app.localhost", "a.b.localhost"} {
+		require.True(t, isLocalhost(hostname), hostname)
+	}
+	for _, hostname := range []string{"localhost.com", "mylocalhost", "example.com", ""} {
+		require.False(t, isLocalhost(hostname), hostname)
+	}
+}
+
+func TestWithoutZone(t *testing.T) {
+	require.Equal(t, "fe80::1", withoutZone("fe80::1%eth0"))
+	require.Equal(t, "10.0.0.1", withoutZone("10.0.0.1"))
+}
+
+func TestUnmapRecords(t *testing.T) {
+	require.Equal(t, "10.0.0.1", unmapIPv4("::ffff:10.0.0.1"))
+	require.Equal(t, "::1", unmapIPv4("::1"))
+	require.Equal(t, "example.com", unmapIPv4("example.com"))
+
+	data := unmapRecords(&retryabledns.DNSData{
+		A:    []string{"::ffff:10.0.0.1"},
+		AAAA: []string{"::ffff:10.0.0.2", "10.0.0.3", "fd00::1"},
+	})
+	require.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, data.A)
+	require.Equal(t, []string{"fd00::1"}, data.AAAA)
+}
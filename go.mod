@@ -4,6 +4,7 @@ go 1.20
 
 require (
 	github.com/dimchansky/utfbom v1.1.1
+	github.com/miekg/dns v1.1.55
 	github.com/pkg/errors v0.9.1
 	github.com/projectdiscovery/hmap v0.0.13
 	github.com/projectdiscovery/networkpolicy v0.0.6
@@ -28,7 +29,6 @@ require (
 	github.com/gorilla/css v1.0.0 // indirect
 	github.com/klauspost/compress v1.16.6 // indirect
 	github.com/microcosm-cc/bluemonday v1.0.25 // indirect
-	github.com/miekg/dns v1.1.55 // indirect
 	github.com/nxadm/tail v1.4.8 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/projectdiscovery/blackrock v0.0.1 // indirect